@@ -0,0 +1,211 @@
+package flink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/DataWorkbench/common/constants"
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// LogInfo represents a log file item in the response of `/jobmanager/logs` and `/taskmanagers/:id/logs`
+type LogInfo struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+}
+
+// LogList represents the response of `/jobmanager/logs` and `/taskmanagers/:id/logs`
+type LogList struct {
+	Logs []*LogInfo `json:"logs"`
+}
+
+// ThreadInfo represents a thread item in the response of `/jobmanager/thread-dump` and `/taskmanagers/:id/thread-dump`
+type ThreadInfo struct {
+	ThreadName            string `json:"threadName"`
+	StringifiedThreadInfo string `json:"stringifiedThreadInfo"`
+}
+
+// ThreadDump represents the response of `/jobmanager/thread-dump` and `/taskmanagers/:id/thread-dump`
+type ThreadDump struct {
+	ThreadInfos []*ThreadInfo `json:"threadInfos"`
+}
+
+// LogRange specifies the part of log file to read.
+//
+// Offset and Length select a byte range; Length <= 0 means read to the end of file.
+// Tail > 0 means read the last Tail bytes and takes precedence over Offset and Length.
+// A nil *LogRange means read the whole file.
+type LogRange struct {
+	Offset int64
+	Length int64
+	Tail   int64
+}
+
+// header returns the value of the http `Range` header, an empty string means no range.
+func (r *LogRange) header() string {
+	if r == nil {
+		return ""
+	}
+	if r.Tail > 0 {
+		return fmt.Sprintf("bytes=-%d", r.Tail)
+	}
+	if r.Offset <= 0 && r.Length <= 0 {
+		return ""
+	}
+	if r.Length <= 0 {
+		return fmt.Sprintf("bytes=%d-", r.Offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+// cut applies the range on the client side. It is used when the flink rest server
+// ignores the `Range` header and returns the whole file.
+func (r *LogRange) cut(body io.ReadCloser, size int64) (io.ReadCloser, error) {
+	if r.header() == "" {
+		return body, nil
+	}
+	if r.Tail > 0 {
+		if size >= 0 {
+			if size > r.Tail {
+				if _, err := io.CopyN(ioutil.Discard, body, size-r.Tail); err != nil {
+					_ = body.Close()
+					return nil, err
+				}
+			}
+			return body, nil
+		}
+		// The content length is unknown, so keep the last Tail bytes in memory.
+		b, err := ioutil.ReadAll(body)
+		_ = body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(b)) > r.Tail {
+			b = b[int64(len(b))-r.Tail:]
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	if r.Offset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, body, r.Offset); err != nil && err != io.EOF {
+			_ = body.Close()
+			return nil, err
+		}
+	}
+	if r.Length <= 0 {
+		return body, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(body, r.Length), Closer: body}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func jobManagerPath(flinkUrl string) string {
+	return fmt.Sprintf("http://%s/%s", flinkUrl, constants.JobManagerName)
+}
+
+func taskManagerPath(flinkUrl string, taskManagerId string) string {
+	return fmt.Sprintf("http://%s/%ss/%s", flinkUrl, constants.TaskManagerName, url.PathEscape(taskManagerId))
+}
+
+// stream sends a GET request and returns the response body, the caller must close it.
+func (c *Client) stream(ctx context.Context, url string, r *LogRange) (body io.ReadCloser, err error) {
+	var req *http.Request
+	var resp *http.Response
+
+	req, err = http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	if h := r.header(); h != "" {
+		req.Header.Set("Range", h)
+	}
+	if resp, err = c.Send(ctx, req); err != nil {
+		return
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		return r.cut(resp.Body, resp.ContentLength)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The offset is beyond the end of file, there is nothing to read.
+		_ = resp.Body.Close()
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	default:
+		var b []byte
+		b, err = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return
+		}
+		return nil, qerror.FlinkRestError.Format(resp.StatusCode, resp.Status, string(b))
+	}
+}
+
+// ListJobManagerLogs returns the log files of the JobManager.
+func (c *Client) ListJobManagerLogs(ctx context.Context, flinkUrl string) (*LogList, error) {
+	data := new(LogList)
+	if err := c.get(ctx, jobManagerPath(flinkUrl)+"/logs", data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// StreamJobManagerLog returns the content of the JobManager log file with the given name.
+// The caller must close the returned reader.
+func (c *Client) StreamJobManagerLog(ctx context.Context, flinkUrl string, filename string, r *LogRange) (io.ReadCloser, error) {
+	return c.stream(ctx, jobManagerPath(flinkUrl)+"/logs/"+url.PathEscape(filename), r)
+}
+
+// StreamJobManagerStdout returns the stdout of the JobManager. The caller must close the returned reader.
+func (c *Client) StreamJobManagerStdout(ctx context.Context, flinkUrl string, r *LogRange) (io.ReadCloser, error) {
+	return c.stream(ctx, jobManagerPath(flinkUrl)+"/stdout", r)
+}
+
+// DescribeJobManagerThreadDump returns the thread dump of the JobManager.
+func (c *Client) DescribeJobManagerThreadDump(ctx context.Context, flinkUrl string) (*ThreadDump, error) {
+	data := new(ThreadDump)
+	if err := c.get(ctx, jobManagerPath(flinkUrl)+"/thread-dump", data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ListTaskManagerLogs returns the log files of the TaskManager with the given id.
+func (c *Client) ListTaskManagerLogs(ctx context.Context, flinkUrl string, taskManagerId string) (*LogList, error) {
+	data := new(LogList)
+	if err := c.get(ctx, taskManagerPath(flinkUrl, taskManagerId)+"/logs", data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// StreamTaskManagerLog returns the content of the TaskManager log file with the given name.
+// The caller must close the returned reader.
+func (c *Client) StreamTaskManagerLog(ctx context.Context, flinkUrl string, taskManagerId string, filename string, r *LogRange) (io.ReadCloser, error) {
+	return c.stream(ctx, taskManagerPath(flinkUrl, taskManagerId)+"/logs/"+url.PathEscape(filename), r)
+}
+
+// StreamTaskManagerStdout returns the stdout of the TaskManager. The caller must close the returned reader.
+func (c *Client) StreamTaskManagerStdout(ctx context.Context, flinkUrl string, taskManagerId string, r *LogRange) (io.ReadCloser, error) {
+	return c.stream(ctx, taskManagerPath(flinkUrl, taskManagerId)+"/stdout", r)
+}
+
+// DescribeTaskManagerThreadDump returns the thread dump of the TaskManager with the given id.
+func (c *Client) DescribeTaskManagerThreadDump(ctx context.Context, flinkUrl string, taskManagerId string) (*ThreadDump, error) {
+	data := new(ThreadDump)
+	if err := c.get(ctx, taskManagerPath(flinkUrl, taskManagerId)+"/thread-dump", data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package flink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+var testLogContent = strings.Repeat("0123456789", 10)
+
+// newTestLogServer starts a server that serves testLogContent, it honors the `Range` header only if
+// ranged is true. The `Range` header of the last request is sent to the returned channel.
+func newTestLogServer(t *testing.T, ranged bool, chunked bool) (string, <-chan string) {
+	ranges := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges <- r.Header.Get("Range")
+		if ranged {
+			// Only the "bytes=-N" form is needed in test.
+			if h := r.Header.Get("Range"); strings.HasPrefix(h, "bytes=-") {
+				n, _ := strconv.Atoi(strings.TrimPrefix(h, "bytes=-"))
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write([]byte(testLogContent[len(testLogContent)-n:]))
+				return
+			}
+		}
+		if !chunked {
+			w.Header().Set("Content-Length", strconv.Itoa(len(testLogContent)))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(testLogContent[:10]))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(testLogContent[10:]))
+	}))
+	t.Cleanup(ts.Close)
+	return strings.TrimPrefix(ts.URL, "http://"), ranges
+}
+
+func readTestLog(t *testing.T, url string, r *LogRange) string {
+	body, err := client.StreamJobManagerLog(ctx, url, "jobmanager.log", r)
+	require.Nil(t, err)
+	defer func() { _ = body.Close() }()
+	b, err := ioutil.ReadAll(body)
+	require.Nil(t, err)
+	return string(b)
+}
+
+func Test_LogRangeHeader(t *testing.T) {
+	var r *LogRange
+	require.Equal(t, "", r.header())
+	require.Equal(t, "", (&LogRange{}).header())
+	require.Equal(t, "bytes=-16", (&LogRange{Offset: 5, Length: 10, Tail: 16}).header())
+	require.Equal(t, "bytes=5-", (&LogRange{Offset: 5}).header())
+	require.Equal(t, "bytes=0-9", (&LogRange{Length: 10}).header())
+	require.Equal(t, "bytes=5-14", (&LogRange{Offset: 5, Length: 10}).header())
+}
+
+func Test_StreamLogPartialContent(t *testing.T) {
+	url, ranges := newTestLogServer(t, true, false)
+
+	require.Equal(t, testLogContent[90:], readTestLog(t, url, &LogRange{Tail: 10}))
+	require.Equal(t, "bytes=-10", <-ranges)
+}
+
+func Test_StreamLogRangeIgnored(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		url, ranges := newTestLogServer(t, false, chunked)
+
+		require.Equal(t, testLogContent[85:], readTestLog(t, url, &LogRange{Tail: 15}), "chunked: %v", chunked)
+		require.Equal(t, "bytes=-15", <-ranges)
+
+		require.Equal(t, testLogContent, readTestLog(t, url, &LogRange{Tail: 1000}), "chunked: %v", chunked)
+		require.Equal(t, "bytes=-1000", <-ranges)
+
+		require.Equal(t, testLogContent[5:25], readTestLog(t, url, &LogRange{Offset: 5, Length: 20}), "chunked: %v", chunked)
+		require.Equal(t, "bytes=5-24", <-ranges)
+
+		require.Equal(t, testLogContent[95:], readTestLog(t, url, &LogRange{Offset: 95}), "chunked: %v", chunked)
+		require.Equal(t, "bytes=95-", <-ranges)
+
+		require.Equal(t, "", readTestLog(t, url, &LogRange{Offset: 200, Length: 10}), "chunked: %v", chunked)
+		require.Equal(t, "bytes=200-209", <-ranges)
+
+		require.Equal(t, testLogContent, readTestLog(t, url, nil), "chunked: %v", chunked)
+		require.Equal(t, "", <-ranges)
+	}
+}
+
+func Test_StreamLogRangeNotSatisfiable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/jobmanager/logs/jobmanager.log", r.URL.Path)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer ts.Close()
+
+	require.Equal(t, "", readTestLog(t, strings.TrimPrefix(ts.URL, "http://"), &LogRange{Offset: 200}))
+}
+
+func Test_StreamLogError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "file not found", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	body, err := client.StreamTaskManagerStdout(ctx, strings.TrimPrefix(ts.URL, "http://"), "tm-1", &LogRange{Tail: 10})
+	require.Nil(t, body)
+	require.NotNil(t, err)
+	require.Equal(t, qerror.FlinkRestError.Code(), err.(*qerror.Error).Code())
+	require.Contains(t, err.(*qerror.Error).String(), "file not found")
+}
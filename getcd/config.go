@@ -0,0 +1,316 @@
+package getcd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-playground/validator/v10"
+	"github.com/sethvargo/go-envconfig"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigHandler called after a new configuration was published.
+// The oldValue and newValue are the pointers returned by `newValue` in NewConfigLoader.
+type ConfigHandler func(ctx context.Context, oldValue interface{}, newValue interface{})
+
+// ConfigLoader loads the keys under a prefix into a struct and reloads it when the keys changed.
+//
+// The key relative to the prefix is the path of field in struct, levels are separated by "/".
+// The value of key is decoded as yaml (so json is also accepted). Eg, with prefix "/config/apiserver/":
+//
+//	/config/apiserver/log_level           => "info"
+//	/config/apiserver/grpc_server/address => "0.0.0.0:9001"
+//	/config/apiserver/redis               => {"mode": "standalone", "standalone_addr": "127.0.0.1:6379"}
+//
+// Fields are matched by the yaml tags, which are the same as the json tags in our configs.
+// The defaults in `env` tags (eg: `env:"DIAL_TIMEOUT,default=5s"`) are applied before decode, the same
+// as load the configuration from environment, and the `validate` tags are checked after decode.
+// An invalid configuration will be rejected and the last good value kept.
+type ConfigLoader struct {
+	lp       *glog.Logger
+	cli      *Client
+	prefix   string
+	newValue func() interface{}
+	validate *validator.Validate
+
+	// value holds the current snapshot, it never be changed after published.
+	value atomic.Value
+	// kvs is the raw keys currently in etcd, the key is relative to the prefix.
+	kvs      map[string][]byte
+	revision int64
+
+	mu       sync.RWMutex
+	handlers []ConfigHandler
+	closed   bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+// NewConfigLoader creates a ConfigLoader and loads the configuration for the first time.
+// The newValue must returns a new pointer to struct each time it is called.
+func NewConfigLoader(ctx context.Context, cli *Client, prefix string, newValue func() interface{}) (*ConfigLoader, error) {
+	if newValue == nil {
+		panic("ConfigLoader: newValue can not be nil")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	lp := glog.FromContext(ctx).Clone()
+	lp.ResetFields().AddString("prefix", prefix)
+
+	cctx, cancel := context.WithCancel(ctx)
+	l := &ConfigLoader{
+		lp:       lp,
+		cli:      cli,
+		prefix:   prefix,
+		newValue: newValue,
+		validate: validator.New(),
+		ctx:      cctx,
+		cancel:   cancel,
+		wg:       new(sync.WaitGroup),
+	}
+
+	if err := l.sync(); err != nil {
+		cancel()
+		_ = lp.Close()
+		return nil, err
+	}
+	v, err := l.decode()
+	if err != nil {
+		lp.Error().Error("ConfigLoader: invalid configuration", err).Fire()
+		cancel()
+		_ = lp.Close()
+		return nil, err
+	}
+	l.value.Store(v)
+
+	lp.Info().Msg("ConfigLoader: successfully loaded configuration").Int64("revision", l.revision).Fire()
+	return l, nil
+}
+
+// Load returns the current snapshot of configuration. The returned value must be treated as read-only.
+func (l *ConfigLoader) Load() interface{} {
+	return l.value.Load()
+}
+
+// OnChange registers a handler that called after each new configuration was published.
+// Handlers are called in order of registration in the goroutine of Watch.
+func (l *ConfigLoader) OnChange(handler ConfigHandler) {
+	l.mu.Lock()
+	l.handlers = append(l.handlers, handler)
+	l.mu.Unlock()
+}
+
+// Watch for start watch the configuration changes. It blocks until Close is called.
+func (l *ConfigLoader) Watch() {
+	// Add to the WaitGroup under the lock, so that it never races with the Wait in Close.
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.wg.Add(1)
+	l.mu.Unlock()
+	defer l.wg.Done()
+
+	lg := l.lp
+	lg.Debug().Msg("ConfigLoader: start monitored the configuration changes").Fire()
+
+	var sleep bool
+	for {
+		if sleep {
+			// Sleep to prevents died loop.
+			select {
+			case <-time.After(time.Second):
+			case <-l.ctx.Done():
+				return
+			}
+		}
+		sleep = true
+
+		wctx, wcancel := context.WithCancel(l.ctx)
+		watchChan := l.cli.Watch(wctx, l.prefix, etcdv3.WithPrefix(), etcdv3.WithRev(l.revision+1))
+		for resp := range watchChan {
+			if err := resp.Err(); err != nil {
+				lg.Error().Error("ConfigLoader: watch error, resync now", err).Fire()
+				break
+			}
+			for _, event := range resp.Events {
+				l.apply(event.Type, event.Kv)
+			}
+			l.revision = resp.Header.Revision
+			l.reload()
+		}
+		wcancel()
+		if l.ctx.Err() != nil {
+			return
+		}
+
+		// The watch channel may be closed by compaction or error, so reload all keys.
+		if err := l.sync(); err != nil {
+			continue
+		}
+		l.reload()
+	}
+}
+
+// Close stop the Watch and wait for it exit.
+func (l *ConfigLoader) Close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	l.cancel()
+	l.wg.Wait()
+	_ = l.lp.Close()
+}
+
+// sync gets all keys under prefix.
+func (l *ConfigLoader) sync() error {
+	resp, err := l.cli.Get(l.ctx, l.prefix, etcdv3.WithPrefix())
+	if err != nil {
+		l.lp.Error().Error("ConfigLoader: get configuration keys error", err).Fire()
+		return err
+	}
+	l.kvs = make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		l.apply(EventPUT, kv)
+	}
+	l.revision = resp.Header.Revision
+	return nil
+}
+
+func (l *ConfigLoader) apply(eventType EventType, kv *KeyValue) {
+	name := strings.TrimPrefix(string(kv.Key), l.prefix)
+	switch eventType {
+	case EventPUT:
+		l.kvs[name] = kv.Value
+	case EventDELETE:
+		delete(l.kvs, name)
+	}
+}
+
+// reload decodes the current keys and publish it if valid.
+func (l *ConfigLoader) reload() {
+	lg := l.lp
+
+	v, err := l.decode()
+	if err != nil {
+		lg.Error().Msg("ConfigLoader: reject invalid configuration and keep the last good value").
+			Int64("revision", l.revision).Error("error", err).Fire()
+		return
+	}
+	old := l.value.Load()
+	l.value.Store(v)
+
+	lg.Info().Msg("ConfigLoader: configuration reloaded").Int64("revision", l.revision).Fire()
+
+	l.mu.RLock()
+	handlers := l.handlers
+	l.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(l.ctx, old, v)
+	}
+}
+
+// decode converts the keys to a new value and validate it.
+func (l *ConfigLoader) decode() (interface{}, error) {
+	tree, err := buildConfigTree(l.kvs)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	v := l.newValue()
+	// Only the defaults are applied because of the lookuper is empty.
+	if err = envconfig.ProcessWith(l.ctx, v, envconfig.MapLookuper(nil)); err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	if err = l.validate.Struct(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// buildConfigTree converts the flat keys to a nested map.
+func buildConfigTree(kvs map[string][]byte) (map[string]interface{}, error) {
+	// Sort the keys to makes the parent is processed before its children,
+	// so that a more specific key such as "redis/mode" overrides the same field in "redis".
+	names := make([]string, 0, len(kvs))
+	for name := range kvs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	root := make(map[string]interface{})
+	for _, name := range names {
+		value := kvs[name]
+		var x interface{}
+		if err := yaml.Unmarshal(value, &x); err != nil {
+			// Not a valid yaml, treat it as a plain string.
+			x = string(value)
+		}
+
+		if name == "" {
+			// The key equal to the prefix contains the whole document.
+			m, ok := x.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("config: value of the prefix must be a mapping")
+			}
+			mergeConfigTree(root, m)
+			continue
+		}
+
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		node := root
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = x
+	}
+	return root, nil
+}
+
+// mergeConfigTree merges src into dst, the keys already in dst take precedence.
+func mergeConfigTree(dst, src map[string]interface{}) {
+	for k, v := range src {
+		exists, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok1 := exists.(map[string]interface{})
+		sm, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			mergeConfigTree(dm, sm)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package getcd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BuildConfigTree(t *testing.T) {
+	cases := []struct {
+		name string
+		kvs  map[string][]byte
+		want map[string]interface{}
+		err  bool
+	}{
+		{
+			name: "nested keys",
+			kvs: map[string][]byte{
+				"log_level":           []byte("info"),
+				"grpc_server/address": []byte("0.0.0.0:9001"),
+				"/redis/mode/":        []byte("standalone"),
+			},
+			want: map[string]interface{}{
+				"log_level":   "info",
+				"grpc_server": map[string]interface{}{"address": "0.0.0.0:9001"},
+				"redis":       map[string]interface{}{"mode": "standalone"},
+			},
+		},
+		{
+			name: "specific key overrides document",
+			kvs: map[string][]byte{
+				"":           []byte("log_level: debug\nredis: {mode: cluster, standalone_addr: 127.0.0.1:6379}"),
+				"log_level":  []byte("info"),
+				"redis/mode": []byte("standalone"),
+			},
+			want: map[string]interface{}{
+				"log_level": "info",
+				"redis":     map[string]interface{}{"mode": "standalone", "standalone_addr": "127.0.0.1:6379"},
+			},
+		},
+		{
+			name: "invalid yaml as string",
+			kvs:  map[string][]byte{"address": []byte("[127.0.0.1")},
+			want: map[string]interface{}{"address": "[127.0.0.1"},
+		},
+		{
+			name: "prefix value not a mapping",
+			kvs:  map[string][]byte{"": []byte("- a\n- b")},
+			err:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tree, err := buildConfigTree(c.kvs)
+			if c.err {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, c.want, tree)
+		})
+	}
+}
+
+func Test_MergeConfigTree(t *testing.T) {
+	dst := map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": 2},
+		"d": "scalar",
+	}
+	src := map[string]interface{}{
+		"a": 10,
+		"b": map[string]interface{}{"c": 20, "e": 30},
+		"d": map[string]interface{}{"f": 40},
+		"g": 50,
+	}
+	mergeConfigTree(dst, src)
+	require.Equal(t, map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": 2, "e": 30},
+		"d": "scalar",
+		"g": 50,
+	}, dst)
+}
+
+func Test_ConfigLoaderDecodeDefaults(t *testing.T) {
+	type testConfig struct {
+		LogLevel string  `yaml:"log_level" env:"LOG_LEVEL,default=info" validate:"required"`
+		Etcd     *Config `yaml:"etcd"      env:",prefix=ETCD_"`
+	}
+	l := &ConfigLoader{
+		ctx:      context.Background(),
+		validate: validator.New(),
+		newValue: func() interface{} { return new(testConfig) },
+		kvs:      map[string][]byte{"etcd/endpoints": []byte("127.0.0.1:2379")},
+	}
+
+	v, err := l.decode()
+	require.Nil(t, err)
+	cfg := v.(*testConfig)
+	require.Equal(t, "info", cfg.LogLevel)
+	require.Equal(t, "127.0.0.1:2379", cfg.Etcd.Endpoints)
+	require.Equal(t, time.Second*5, cfg.Etcd.DialTimeout)
+
+	l.kvs["log_level"] = []byte("debug")
+	l.kvs["etcd/dial_timeout"] = []byte("10s")
+	v, err = l.decode()
+	require.Nil(t, err)
+	cfg = v.(*testConfig)
+	require.Equal(t, "debug", cfg.LogLevel)
+	require.Equal(t, time.Second*10, cfg.Etcd.DialTimeout)
+
+	// The required field without default is still validated.
+	delete(l.kvs, "etcd/endpoints")
+	_, err = l.decode()
+	require.NotNil(t, err)
+}
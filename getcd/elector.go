@@ -0,0 +1,233 @@
+package getcd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// ErrElectionNoLeader is returned by Elector.Leader when there is no leader currently.
+var ErrElectionNoLeader = concurrency.ErrElectionNoLeader
+
+type ElectorOption func(o *electorOptions)
+
+type electorOptions struct {
+	ttl           int
+	retryInterval time.Duration
+}
+
+func applyElectorOptions(options ...ElectorOption) electorOptions {
+	opts := electorOptions{
+		ttl:           60,
+		retryInterval: time.Millisecond * 100,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithElectionTTL sets the TTL in seconds of the election session.
+// The leader will lose its leadership after TTL if it can't keep alive with etcd.
+// Defaults 60.
+func WithElectionTTL(ttl int) ElectorOption {
+	return func(o *electorOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithElectionRetryInterval sets the interval time to retry after the election failed.
+// Defaults 100ms.
+func WithElectionRetryInterval(d time.Duration) ElectorOption {
+	return func(o *electorOptions) {
+		o.retryInterval = d
+	}
+}
+
+type fencingTokenKey struct{}
+
+// ContextWithFencingToken returns a copy of ctx with the fencing token.
+func ContextWithFencingToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, fencingTokenKey{}, token)
+}
+
+// FencingTokenFromContext returns the fencing token stored in ctx, or 0 if not exists.
+//
+// The token is increasing monotonically across the leaders of an election. Downstream storage can
+// reject writes from a stale leader by remembering the max token seen, eg: gormwrap.FencingScope
+// and rediswrap.FencedSet.
+func FencingTokenFromContext(ctx context.Context) int64 {
+	token, _ := ctx.Value(fencingTokenKey{}).(int64)
+	return token
+}
+
+// Elector is a master worker elector with observable leadership.
+type Elector struct {
+	lp    *glog.Logger
+	cli   *Client
+	key   string
+	value string
+	opts  electorOptions
+
+	mu       sync.RWMutex
+	isLeader bool
+	token    int64
+
+	changeC chan bool
+}
+
+// NewElector creates an Elector to campaign on the key with value. The value used to identify the
+// current worker and can be get by Leader.
+func NewElector(ctx context.Context, cli *Client, key string, value string, options ...ElectorOption) *Elector {
+	nl := glog.FromContext(ctx).Clone()
+	nl.ResetFields().AddString("key", key)
+
+	return &Elector{
+		lp:      nl,
+		cli:     cli,
+		key:     key,
+		value:   value,
+		opts:    applyElectorOptions(options...),
+		changeC: make(chan bool, 1),
+	}
+}
+
+// IsLeader reports whether the current worker is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.isLeader
+}
+
+// FencingToken returns the fencing token of the current term, it is the create revision of the election key.
+// Returns 0 if the current worker is not the leader.
+func (e *Elector) FencingToken() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.token
+}
+
+// Leader returns the value of the current leader. Returns ErrElectionNoLeader if there is no leader.
+func (e *Elector) Leader(ctx context.Context) (string, error) {
+	resp, err := e.cli.Get(ctx, e.key+"/", etcdv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", ErrElectionNoLeader
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Changes returns a channel that receives the leadership whenever it changed.
+// Only the latest leadership is kept if the receiver is slow.
+func (e *Elector) Changes() <-chan bool {
+	return e.changeC
+}
+
+func (e *Elector) setLeader(isLeader bool, token int64) {
+	e.mu.Lock()
+	e.isLeader = isLeader
+	e.token = token
+	e.mu.Unlock()
+
+	if isLeader {
+		electionIsLeader.WithLabelValues(e.key).Set(1)
+		electionLeaderChanges.WithLabelValues(e.key).Inc()
+	} else {
+		electionIsLeader.WithLabelValues(e.key).Set(0)
+	}
+	electionFencingToken.WithLabelValues(e.key).Set(float64(token))
+
+	// Drop the stale leadership that not received.
+	select {
+	case <-e.changeC:
+	default:
+	}
+	e.changeC <- isLeader
+}
+
+// Run campaigns for leadership and blocks until ctx done. The offer will be invoked when the campaign
+// was success, and the ctx passed to it will be canceled when lost leadership.
+// The fencing token of the term is stored in the ctx of offer, use FencingTokenFromContext to get it.
+//
+// Run must be called only once.
+func (e *Elector) Run(ctx context.Context, offer func(ctxCancel context.Context)) {
+	nl := e.lp
+
+	var sleep bool
+LOOP:
+	for {
+		if sleep {
+			// Sleep to prevents died loop.
+			select {
+			case <-time.After(e.opts.retryInterval):
+			case <-ctx.Done():
+				break LOOP
+			}
+		}
+		sleep = true
+
+		nl.Info().Msg("etcd: start leader election").Fire()
+		sess, err := concurrency.NewSession(e.cli, concurrency.WithTTL(e.opts.ttl))
+		if err != nil {
+			nl.Error().Msg("etcd: concurrency new session failed and retry now").Error("error", err).Fire()
+			continue LOOP
+		}
+
+		election := concurrency.NewElection(sess, e.key)
+		if err = election.Campaign(ctx, e.value); err != nil {
+			_ = sess.Close()
+			if err == context.Canceled {
+				nl.Info().Msg("etcd: ctx canceled, stop campaign").Fire()
+				break LOOP
+			}
+			nl.Error().Msg("etcd: election campaign failed and retry now").Error("error", err).Fire()
+			continue LOOP
+		}
+
+		token := election.Rev()
+		nl.Info().Msg("etcd: current worker is leader and start of term").Int64("fencing_token", token).Fire()
+		e.setLeader(true, token)
+
+		ctxCancel, cancel := context.WithCancel(ContextWithFencingToken(ctx, token))
+
+		exitC := make(chan struct{})
+
+		go func() {
+			offer(ctxCancel)
+			close(exitC)
+		}()
+
+		select {
+		case <-sess.Done():
+			nl.Info().Msg("etcd: session done and continue to re-election").Fire()
+
+			cancel()
+			e.setLeader(false, 0)
+			// wait for notify callback func exit.
+			<-exitC
+
+			continue LOOP
+		case <-ctx.Done():
+			nl.Info().Msg("etcd: receive ctx done signal and end of term").Fire()
+
+			cancel()
+			e.setLeader(false, 0)
+			// wait for notify callback func exit.
+			<-exitC
+
+			if err = election.Resign(context.Background()); err != nil {
+				nl.Error().Error("etcd: election resign error", err).Fire()
+			}
+			_ = sess.Close()
+			break LOOP
+		}
+	}
+
+	_ = nl.Close()
+}
@@ -0,0 +1,40 @@
+package getcd
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const subsystem = "etcd"
+
+var (
+	electionIsLeader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "election_is_leader",
+			Help:      "Whether the current worker is the leader of election, 1 is leader and 0 is not.",
+		},
+		[]string{"key"},
+	)
+	electionFencingToken = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "election_fencing_token",
+			Help:      "The fencing token of the current term if the current worker is the leader, otherwise 0.",
+		},
+		[]string{"key"},
+	)
+	electionLeaderChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "election_leader_changes_total",
+			Help:      "How many times the current worker became the leader of election.",
+		},
+		[]string{"key"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(electionIsLeader)
+	prometheus.MustRegister(electionFencingToken)
+	prometheus.MustRegister(electionLeaderChanges)
+}
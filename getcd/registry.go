@@ -0,0 +1,148 @@
+package getcd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+)
+
+// RegistryPrefix is the key prefix that all service endpoints are registered under.
+// The full key of an endpoint is "<RegistryPrefix><service>/<address>".
+const RegistryPrefix = "/services/"
+
+// DefaultRegistryTTL is the lease TTL in seconds of a registered endpoint.
+const DefaultRegistryTTL = 10
+
+func registryServiceKey(service string) string {
+	return RegistryPrefix + service + "/"
+}
+
+// Registration represents a service endpoint registered in etcd.
+// The endpoint is put under a lease and kept alive in background until Deregister is called.
+type Registration struct {
+	lp    *glog.Logger
+	cli   *Client
+	key   string
+	value string
+	ttl   int64
+
+	mu      sync.Mutex
+	leaseID etcdv3.LeaseID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	exitC  chan struct{}
+}
+
+// Register puts the address of service into etcd under a lease with keepalive.
+// The ttl is the lease TTL in seconds, use DefaultRegistryTTL if ttl <= 0.
+//
+// The endpoint will be registered again automatically if the lease lost, eg: network partition.
+func Register(ctx context.Context, cli *Client, service string, address string, ttl int64) (*Registration, error) {
+	if ttl <= 0 {
+		ttl = DefaultRegistryTTL
+	}
+
+	lp := glog.FromContext(ctx).Clone()
+	lp.ResetFields().AddString("service", service)
+	lp.WithFields().AddString("address", address)
+
+	cctx, cancel := context.WithCancel(ctx)
+	r := &Registration{
+		lp:     lp,
+		cli:    cli,
+		key:    registryServiceKey(service) + address,
+		value:  address,
+		ttl:    ttl,
+		ctx:    cctx,
+		cancel: cancel,
+		exitC:  make(chan struct{}),
+	}
+
+	keepAlive, err := r.register()
+	if err != nil {
+		cancel()
+		_ = lp.Close()
+		return nil, err
+	}
+	go r.keepAlive(keepAlive)
+
+	lp.Info().Msg("etcd: service endpoint registered").Fire()
+	return r, nil
+}
+
+// register grants a new lease and puts the endpoint with it.
+func (r *Registration) register() (<-chan *etcdv3.LeaseKeepAliveResponse, error) {
+	lease, err := r.cli.Grant(r.ctx, r.ttl)
+	if err != nil {
+		r.lp.Error().Error("etcd: grant lease for service endpoint error", err).Fire()
+		return nil, err
+	}
+	if _, err = r.cli.Put(r.ctx, r.key, r.value, etcdv3.WithLease(lease.ID)); err != nil {
+		r.lp.Error().Error("etcd: put service endpoint error", err).Fire()
+		return nil, err
+	}
+	keepAlive, err := r.cli.KeepAlive(r.ctx, lease.ID)
+	if err != nil {
+		r.lp.Error().Error("etcd: keepalive service endpoint error", err).Fire()
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.leaseID = lease.ID
+	r.mu.Unlock()
+	return keepAlive, nil
+}
+
+func (r *Registration) keepAlive(keepAlive <-chan *etcdv3.LeaseKeepAliveResponse) {
+	defer close(r.exitC)
+
+	for {
+		for range keepAlive {
+			// Drain the keepalive responses until the channel closed by ctx done or lease lost.
+		}
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		r.lp.Warn().Msg("etcd: service endpoint lease lost and register again").Fire()
+		for {
+			// Sleep to prevents died loop.
+			select {
+			case <-time.After(time.Second):
+			case <-r.ctx.Done():
+				return
+			}
+			var err error
+			if keepAlive, err = r.register(); err == nil {
+				break
+			}
+		}
+		r.lp.Info().Msg("etcd: service endpoint registered again").Fire()
+	}
+}
+
+// Deregister stops the keepalive and deletes the endpoint from etcd by revoke the lease.
+func (r *Registration) Deregister(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.cancel()
+	<-r.exitC
+
+	r.mu.Lock()
+	leaseID := r.leaseID
+	r.mu.Unlock()
+
+	defer func() { _ = r.lp.Close() }()
+
+	if _, err := r.cli.Revoke(ctx, leaseID); err != nil {
+		r.lp.Error().Error("etcd: revoke service endpoint lease error", err).Fire()
+		return err
+	}
+	r.lp.Info().Msg("etcd: service endpoint deregistered").Fire()
+	return nil
+}
@@ -0,0 +1,168 @@
+package getcd
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/resolver"
+)
+
+// ResolverScheme is the scheme of gRPC target to discovery services registered by Register.
+// eg: "etcd:///apiserver".
+const ResolverScheme = "etcd"
+
+// NewResolverBuilder returns a gRPC resolver.Builder that resolves the "etcd:///<service>"
+// target to the endpoints registered by Register.
+//
+// The builder must be registered by resolver.Register (or use RegisterResolver) before dial.
+func NewResolverBuilder(ctx context.Context, cli *Client) resolver.Builder {
+	return &resolverBuilder{ctx: ctx, cli: cli}
+}
+
+// RegisterResolver is a shortcut to register the etcd resolver.Builder into gRPC globally.
+// It should be called only once at initialization time.
+func RegisterResolver(ctx context.Context, cli *Client) {
+	resolver.Register(NewResolverBuilder(ctx, cli))
+}
+
+type resolverBuilder struct {
+	ctx context.Context
+	cli *Client
+}
+
+// Build implements resolver.Builder
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	service := strings.Trim(target.Endpoint, "/")
+
+	nl := glog.FromContext(b.ctx).Clone()
+	nl.ResetFields().AddString("service", service)
+
+	ctx, cancel := context.WithCancel(glog.WithContext(b.ctx, nl))
+	r := &etcdResolver{
+		lp:     nl,
+		cli:    b.cli,
+		cc:     cc,
+		prefix: registryServiceKey(service),
+		cancel: cancel,
+		exitC:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(r.exitC)
+		r.watch(ctx)
+	}()
+	return r, nil
+}
+
+// Scheme implements resolver.Builder
+func (b *resolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+type etcdResolver struct {
+	lp     *glog.Logger
+	cli    *Client
+	cc     resolver.ClientConn
+	prefix string
+
+	// addrs is the registered endpoints, only accessed in the goroutine of watch.
+	addrs map[string]string
+
+	cancel context.CancelFunc
+	exitC  chan struct{}
+}
+
+// watch lists all endpoints and watches the changes after it. It re-lists on the watch
+// failures such as compaction, so that the deletes during the failures are not missed.
+func (r *etcdResolver) watch(ctx context.Context) {
+	var sleep bool
+	for {
+		if sleep {
+			// Sleep to prevents died loop.
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+		sleep = true
+
+		revision, err := r.sync(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			r.lp.Error().Error("etcd: resolver get service endpoints error", err).Fire()
+			continue
+		}
+
+		wctx, wcancel := context.WithCancel(ctx)
+		watchChan := r.cli.Watch(wctx, r.prefix, etcdv3.WithPrefix(), etcdv3.WithRev(revision+1))
+		for resp := range watchChan {
+			if err = resp.Err(); err != nil {
+				r.lp.Error().Error("etcd: resolver watch error, resync now", err).Fire()
+				break
+			}
+			for _, event := range resp.Events {
+				key := string(event.Kv.Key)
+				switch event.Type {
+				case EventPUT:
+					r.addrs[key] = string(event.Kv.Value)
+				case EventDELETE:
+					delete(r.addrs, key)
+				}
+			}
+			r.updateState()
+		}
+		wcancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// sync gets all endpoints and pushes them to the ClientConn at once.
+func (r *etcdResolver) sync(ctx context.Context) (int64, error) {
+	resp, err := r.cli.Get(ctx, r.prefix, etcdv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	r.addrs = make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		r.addrs[string(kv.Key)] = string(kv.Value)
+	}
+	r.updateState()
+	return resp.Header.Revision, nil
+}
+
+func (r *etcdResolver) updateState() {
+	addrs := make([]string, 0, len(r.addrs))
+	for _, addr := range r.addrs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	r.lp.Debug().Msg("etcd: resolver update service endpoints").Strings("addresses", addrs).Fire()
+
+	state := resolver.State{Addresses: make([]resolver.Address, len(addrs))}
+	for i, addr := range addrs {
+		state.Addresses[i] = resolver.Address{Addr: addr}
+	}
+	if err := r.cc.UpdateState(state); err != nil {
+		r.lp.Warn().Msg("etcd: resolver update state error").Error("error", err).Fire()
+	}
+}
+
+// ResolveNow implements resolver.Resolver. It is a no-op since the endpoints are watched.
+func (r *etcdResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements resolver.Resolver
+func (r *etcdResolver) Close() {
+	r.cancel()
+	<-r.exitC
+	_ = r.lp.Close()
+}
@@ -0,0 +1,157 @@
+package getcd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/DataWorkbench/glog"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/limiter"
+)
+
+var _ limiter.Limiter = (*Semaphore)(nil)
+
+// Semaphore limits the number of holders of a key across the cluster. eg: at most N flink
+// submissions per workspace.
+//
+// Each holder is a key under "<prefix><key>/" that attached to the lease of a session, thus the
+// permits will be released automatically if the process exits unexpectedly.
+type Semaphore struct {
+	lp      *glog.Logger
+	cli     *Client
+	session *concurrency.Session
+	prefix  string
+	limit   int64
+	seq     int64
+}
+
+// NewSemaphore creates a Semaphore that allows at most limit holders for each key under prefix.
+func NewSemaphore(ctx context.Context, cli *Client, prefix string, limit int) (s *Semaphore, err error) {
+	if limit <= 0 {
+		panic("Semaphore: limit must be greater than 0")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	nl := glog.FromContext(ctx)
+
+	var session *concurrency.Session
+	nl.Debug().Msg("etcd: creating a session for semaphore").String("prefix", prefix).Fire()
+	session, err = concurrency.NewSession(cli)
+	if err != nil {
+		nl.Error().Error("etcd: create session error", err).Fire()
+		return
+	}
+
+	s = &Semaphore{
+		lp:      nl,
+		cli:     cli,
+		session: session,
+		prefix:  prefix,
+		limit:   int64(limit),
+	}
+	return
+}
+
+// Acquire tries to take a permit of key without blocking. It implements limiter.Limiter.
+// Returns qerror.TooManyRequests if there are already limit holders.
+func (s *Semaphore) Acquire(ctx context.Context, key string) (release func(), err error) {
+	var holder string
+	var rev int64
+	if holder, rev, err = s.put(ctx, key); err != nil {
+		return
+	}
+
+	var ok bool
+	if ok, _, err = s.check(ctx, key, rev); err != nil || !ok {
+		s.delete(holder)
+		if err == nil {
+			err = qerror.TooManyRequests.Format(key)
+		}
+		return nil, err
+	}
+	return func() { s.delete(holder) }, nil
+}
+
+// Wait takes a permit of key, blocking until a permit is available or ctx done.
+// The holders are granted permits in the order of calls.
+func (s *Semaphore) Wait(ctx context.Context, key string) (release func(), err error) {
+	var holder string
+	var rev int64
+	if holder, rev, err = s.put(ctx, key); err != nil {
+		return
+	}
+
+	for {
+		var ok bool
+		var header int64
+		if ok, header, err = s.check(ctx, key, rev); err != nil {
+			s.delete(holder)
+			return nil, err
+		}
+		if ok {
+			return func() { s.delete(holder) }, nil
+		}
+
+		// Wait for any holder released.
+		wctx, cancel := context.WithCancel(ctx)
+		watchChan := s.cli.Watch(wctx, s.prefix+key+"/", etcdv3.WithPrefix(), etcdv3.WithRev(header+1), etcdv3.WithFilterPut())
+		select {
+		case <-watchChan:
+		case <-s.session.Done():
+		}
+		cancel()
+
+		if err = ctx.Err(); err != nil {
+			s.delete(holder)
+			return nil, err
+		}
+		select {
+		case <-s.session.Done():
+			return nil, concurrency.ErrSessionExpired
+		default:
+		}
+	}
+}
+
+// Close releases all permits held by the Semaphore by revoke the session lease.
+func (s *Semaphore) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.session.Close()
+}
+
+// put creates the holder key and returns its create revision.
+func (s *Semaphore) put(ctx context.Context, key string) (holder string, rev int64, err error) {
+	holder = fmt.Sprintf("%s%s/%x-%d", s.prefix, key, s.session.Lease(), atomic.AddInt64(&s.seq, 1))
+	resp, err := s.cli.Put(ctx, holder, "", etcdv3.WithLease(s.session.Lease()))
+	if err != nil {
+		s.lp.Error().Error("etcd: semaphore put holder error", err).String("holder", holder).Fire()
+		return
+	}
+	rev = resp.Header.Revision
+	return
+}
+
+// check reports whether the holder created at rev is within the first limit holders of key.
+func (s *Semaphore) check(ctx context.Context, key string, rev int64) (ok bool, header int64, err error) {
+	resp, err := s.cli.Get(ctx, s.prefix+key+"/", etcdv3.WithPrefix(), etcdv3.WithMaxCreateRev(rev), etcdv3.WithCountOnly())
+	if err != nil {
+		s.lp.Error().Error("etcd: semaphore count holders error", err).String("key", key).Fire()
+		return
+	}
+	return resp.Count <= s.limit, resp.Header.Revision, nil
+}
+
+func (s *Semaphore) delete(holder string) {
+	if _, err := s.cli.Delete(context.Background(), holder); err != nil {
+		s.lp.Error().Error("etcd: semaphore delete holder error", err).String("holder", holder).Fire()
+	}
+}
@@ -0,0 +1,160 @@
+package getcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+	etcdv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+func newTestContext() context.Context {
+	return glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+}
+
+func freeTestURL(t *testing.T) url.URL {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer func() { _ = lis.Close() }()
+	return url.URL{Scheme: "http", Host: lis.Addr().String()}
+}
+
+// newTestClient starts an embedded etcd server and returns a client connected to it.
+func newTestClient(t *testing.T) *Client {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeTestURL(t), freeTestURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+
+	e, err := embed.StartEtcd(cfg)
+	require.Nil(t, err)
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd server not ready")
+	}
+
+	cli, err := NewClient(newTestContext(), &Config{Endpoints: clientURL.Host, DialTimeout: 5 * time.Second})
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = cli.Close() })
+	return cli
+}
+
+func Test_SemaphoreAcquireRelease(t *testing.T) {
+	ctx := newTestContext()
+	cli := newTestClient(t)
+
+	s, err := NewSemaphore(ctx, cli, "/test/semaphore", 2)
+	require.Nil(t, err)
+	defer func() { _ = s.Close() }()
+
+	release1, err := s.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	release2, err := s.Acquire(ctx, "w1")
+	require.Nil(t, err)
+
+	release, err := s.Acquire(ctx, "w1")
+	require.Nil(t, release)
+	require.NotNil(t, err)
+	require.Equal(t, qerror.TooManyRequests.Code(), err.(*qerror.Error).Code())
+
+	// The keys are limited separately.
+	release3, err := s.Acquire(ctx, "w2")
+	require.Nil(t, err)
+	release3()
+
+	// The rejected holder must not take a permit.
+	release1()
+	release1, err = s.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	release1()
+	release2()
+
+	resp, err := cli.Get(ctx, "/test/semaphore/", etcdv3.WithPrefix())
+	require.Nil(t, err)
+	require.Equal(t, int64(0), resp.Count)
+}
+
+func Test_SemaphoreWait(t *testing.T) {
+	ctx := newTestContext()
+	cli := newTestClient(t)
+
+	s, err := NewSemaphore(ctx, cli, "/test/semaphore", 1)
+	require.Nil(t, err)
+	defer func() { _ = s.Close() }()
+
+	release, err := s.Acquire(ctx, "w1")
+	require.Nil(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		r, err := s.Wait(ctx, "w1")
+		if err != nil {
+			r = func() { t.Error(err) }
+		}
+		acquired <- r
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Wait must block until the permit released")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case r := <-acquired:
+		r()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait not return after the permit released")
+	}
+
+	// Wait returns the ctx error if ctx done before a permit available.
+	release, err = s.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	defer release()
+
+	wctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = s.Wait(wctx, "w1")
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func Test_SemaphoreCloseReleasePermits(t *testing.T) {
+	ctx := newTestContext()
+	cli := newTestClient(t)
+
+	s1, err := NewSemaphore(ctx, cli, "/test/semaphore", 1)
+	require.Nil(t, err)
+	s2, err := NewSemaphore(ctx, cli, "/test/semaphore", 1)
+	require.Nil(t, err)
+	defer func() { _ = s2.Close() }()
+
+	_, err = s1.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	_, err = s2.Acquire(ctx, "w1")
+	require.NotNil(t, err)
+
+	require.Nil(t, s1.Close())
+	release, err := s2.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	release()
+}
+
+func Test_NewSemaphoreInvalidLimit(t *testing.T) {
+	require.PanicsWithValue(t, "Semaphore: limit must be greater than 0", func() {
+		_, _ = NewSemaphore(newTestContext(), nil, "/test/semaphore", 0)
+	})
+}
@@ -28,6 +28,7 @@ const (
 
 type MySQLConfig struct {
 	// Hosts sample "127.0.0.1:3306,127.0.0.1:3307,127.0.0.1:3308"
+	// Only the first host is connected, use Replicas to configure the read-only replicas.
 	Hosts       string `json:"hosts"         yaml:"hosts"         env:"HOSTS"                     validate:"required"`
 	Users       string `json:"users"         yaml:"users"         env:"USERS"                     validate:"required"`
 	Password    string `json:"password"      yaml:"password"      env:"PASSWORD"                  validate:"required"`
@@ -40,10 +41,28 @@ type MySQLConfig struct {
 	ConnMaxLifetime time.Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME,default=10m" validate:"required"`
 	// SlowThreshold time 0 indicates disabled
 	SlowThreshold time.Duration `json:"slow_threshold" yaml:"slow_threshold" env:"SLOW_THRESHOLD,default=2s" validate:"gte=0"`
+
+	// Replicas with their own pool settings, the read-only queries are routed to replicas.
+	Replicas []*ReplicaConfig `json:"replicas" yaml:"replicas" validate:"dive"`
+	// HealthCheckInterval is the interval to ping the replicas, the unhealthy replicas are skipped
+	// until they are recovered. 0 indicates disabled.
+	HealthCheckInterval time.Duration `json:"health_check_interval" yaml:"health_check_interval" env:"HEALTH_CHECK_INTERVAL,default=10s" validate:"gte=0"`
+}
+
+// ReplicaConfig is the config of a read-only replica, the zero value of pool settings means same as primary.
+type ReplicaConfig struct {
+	Host            string        `json:"host"              yaml:"host"              validate:"required"`
+	MaxIdleConn     int           `json:"max_idle_conn"     yaml:"max_idle_conn"     validate:"gte=0"`
+	MaxOpenConn     int           `json:"max_open_conn"     yaml:"max_open_conn"     validate:"gte=0"`
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" validate:"gte=0"`
 }
 
 // NewMySQLConn return a grom.DB by mysql driver
 // NOTICE: Must set glog.Logger into the ctx by glow.WithContext
+//
+// If there are replicas, the queries are routed to replicas and the writes and transactions to primary,
+// use UsePrimary to force the queries to primary. The replica health check stops and the replicas are
+// closed when ctx done.
 func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error) {
 	lp := glog.FromContext(ctx)
 
@@ -62,17 +81,44 @@ func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error
 		return
 	}
 
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
-		cfg.Users, cfg.Password, hosts[0], cfg.Database,
-	)
+	dsn := mysqlDSN(cfg, hosts[0])
+
+	var sqlDB *sql.DB
+	db, sqlDB, err = openConn(ctx, mysql.Open(dsn), &poolConfig{
+		LogLevel:        cfg.LogLevel,
+		SlowThreshold:   cfg.SlowThreshold,
+		MaxIdleConn:     cfg.MaxIdleConn,
+		MaxOpenConn:     cfg.MaxOpenConn,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	})
+	if err != nil {
+		return
+	}
+
+	if len(cfg.Replicas) == 0 {
+		return
+	}
+	err = useReplicas(ctx, db, sqlDB, cfg, cfg.Replicas)
+	return
+}
+
+// poolConfig is the common settings of all drivers.
+type poolConfig struct {
+	LogLevel        int
+	SlowThreshold   time.Duration
+	MaxIdleConn     int
+	MaxOpenConn     int
+	ConnMaxLifetime time.Duration
+}
 
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
+// openConn opens a gorm.DB with the dialector, and sets the logger, connection pool and opentracing plugin.
+func openConn(ctx context.Context, dialector gorm.Dialector, cfg *poolConfig) (db *gorm.DB, sqlDB *sql.DB, err error) {
+	db, err = gorm.Open(dialector, &gorm.Config{
 		//SkipDefaultTransaction: true,
 		Logger: &Logger{
 			Level:         LogLevel(cfg.LogLevel),
 			SlowThreshold: cfg.SlowThreshold,
-			Output:        lp,
+			Output:        glog.FromContext(ctx),
 		},
 	})
 	if err != nil {
@@ -80,7 +126,6 @@ func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error
 	}
 
 	// Set connection pool
-	var sqlDB *sql.DB
 	if sqlDB, err = db.DB(); err != nil {
 		return
 	}
@@ -92,12 +137,20 @@ func NewMySQLConn(ctx context.Context, cfg *MySQLConfig) (db *gorm.DB, err error
 	if err = db.Use(newOpenTracingPlugin(tracer)); err != nil {
 		return
 	}
-
-	// TODO: Adds multiple databases if necessary
-	// import gorm.io/plugin/dbresolver
 	return
 }
 
+func mysqlDSN(cfg *MySQLConfig, host string) string {
+	return fmt.Sprintf(
+		"%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
+		cfg.Users, cfg.Password, host, cfg.Database,
+	)
+}
+
+// Condition is the simple list conditions joint with AND.
+//
+// Deprecated: Use QuerySchema and Query instead, the Condition builds the clauses in random order
+// and doesn't check the column names.
 type Condition struct {
 	// where cond: k = v
 	Values    map[string]interface{}
@@ -0,0 +1,30 @@
+package gormwrap
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrFencingTokenMissing is added to the db by FencingScope if the fencing token is not set.
+var ErrFencingTokenMissing = errors.New("gormwrap: fencing token missing")
+
+// FencingScope returns a scope that guards the writes by the fencing token of leader, eg: the token
+// from getcd.FencingTokenFromContext. It adds the condition "column <= token", so that the writes of
+// a stale leader match no rows after a newer leader has written them.
+//
+// The updates should set the column to the token to advance it, and check the RowsAffected, eg:
+//
+//	token := getcd.FencingTokenFromContext(ctx)
+//	tx := db.Model(&Job{}).Scopes(gormwrap.FencingScope("fencing_token", token)).Where("id = ?", id).
+//		Updates(map[string]interface{}{"status": status, "fencing_token": token})
+func FencingScope(column string, token int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if token <= 0 {
+			_ = db.AddError(ErrFencingTokenMissing)
+			return db
+		}
+		return db.Where(clause.Lte{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: token})
+	}
+}
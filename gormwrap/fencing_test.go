@@ -0,0 +1,43 @@
+package gormwrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func Test_FencingScope(t *testing.T) {
+	type fencedJob struct {
+		ID           int64
+		Status       int
+		FencingToken int64
+	}
+	db := newTestDB(t)
+	require.Nil(t, db.AutoMigrate(&fencedJob{}))
+	require.Nil(t, db.Create(&fencedJob{ID: 1}).Error)
+
+	update := func(token int64, status int) *gorm.DB {
+		return db.Model(&fencedJob{}).Scopes(FencingScope("fencing_token", token)).Where("id = ?", 1).
+			Updates(map[string]interface{}{"status": status, "fencing_token": token})
+	}
+
+	tx := update(5, 1)
+	require.Nil(t, tx.Error)
+	require.Equal(t, int64(1), tx.RowsAffected)
+
+	// The stale leader is rejected.
+	tx = update(3, 2)
+	require.Nil(t, tx.Error)
+	require.Equal(t, int64(0), tx.RowsAffected)
+
+	tx = update(5, 3)
+	require.Nil(t, tx.Error)
+	require.Equal(t, int64(1), tx.RowsAffected)
+
+	require.Equal(t, ErrFencingTokenMissing, update(0, 4).Error)
+
+	var job fencedJob
+	require.Nil(t, db.First(&job, 1).Error)
+	require.Equal(t, fencedJob{ID: 1, Status: 3, FencingToken: 5}, job)
+}
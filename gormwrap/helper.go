@@ -2,12 +2,84 @@ package gormwrap
 
 import (
 	"context"
+	"errors"
+	"math/rand"
+	"time"
 
+	"github.com/DataWorkbench/glog"
+	"github.com/go-sql-driver/mysql"
 	"gorm.io/gorm"
 )
 
+type TxnOption func(o *txnOptions)
+
+type txnOptions struct {
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func applyTxnOptions(options ...TxnOption) txnOptions {
+	opts := txnOptions{
+		maxRetries: 0,
+		minBackoff: time.Millisecond * 20,
+		maxBackoff: time.Second,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithTxnMaxRetries sets the max retries when the txn failed by deadlock or serialization error,
+// 0 means no retry. Defaults 0.
+func WithTxnMaxRetries(n int) TxnOption {
+	return func(o *txnOptions) {
+		o.maxRetries = n
+	}
+}
+
+// WithTxnBackoff sets the exponential backoff between retries, the interval is doubled from min
+// up to max with jitter. Defaults 20ms and 1s.
+func WithTxnBackoff(min time.Duration, max time.Duration) TxnOption {
+	return func(o *txnOptions) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
 // ExecuteFuncWithTxn execute a func with db txn.
-func ExecuteFuncWithTxn(ctx context.Context, conn *gorm.DB, f func(tx *gorm.DB) error) (err error) {
+//
+// The retry is disabled by default. If enabled by WithTxnMaxRetries, the whole txn is retried when it
+// failed by deadlock or serialization error, so the f must be safe to be called multiple times.
+func ExecuteFuncWithTxn(ctx context.Context, conn *gorm.DB, f func(tx *gorm.DB) error, options ...TxnOption) (err error) {
+	opts := applyTxnOptions(options...)
+	backoff := opts.minBackoff
+
+	for retries := 0; ; retries++ {
+		err = executeTxn(ctx, conn, f)
+		if err == nil || retries >= opts.maxRetries || !IsRetryableTxnError(err) {
+			return
+		}
+
+		glog.FromContext(ctx).Warn().Msg("gorm: txn conflicted, retrying").Error("error", err).Int("retries", retries).Fire()
+
+		wait := backoff
+		if wait > 0 {
+			wait = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > opts.maxBackoff {
+			backoff = opts.maxBackoff
+		}
+	}
+}
+
+func executeTxn(ctx context.Context, conn *gorm.DB, f func(tx *gorm.DB) error) (err error) {
 	tx := conn.Begin().WithContext(ctx)
 	if err = tx.Error; err != nil {
 		return
@@ -27,3 +99,20 @@ func ExecuteFuncWithTxn(ctx context.Context, conn *gorm.DB, f func(tx *gorm.DB)
 	}
 	return
 }
+
+// IsRetryableTxnError reports whether the err is a deadlock or serialization error,
+// that the txn has been rolled back and can be retried.
+func IsRetryableTxnError(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		// ER_LOCK_DEADLOCK
+		return myErr.Number == 1213
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		// serialization_failure and deadlock_detected in postgres.
+		state := stateErr.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+	return false
+}
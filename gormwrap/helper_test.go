@@ -0,0 +1,85 @@
+package gormwrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sql state " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func Test_IsRetryableTxnError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{err: &mysql.MySQLError{Number: 1213}, want: true},
+		{err: &mysql.MySQLError{Number: 1062}, want: false},
+		{err: sqlStateError("40001"), want: true},
+		{err: sqlStateError("40P01"), want: true},
+		{err: sqlStateError("23505"), want: false},
+		{err: errors.New("other"), want: false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, IsRetryableTxnError(c.err), c.err.Error())
+	}
+}
+
+func Test_ExecuteFuncWithTxnRetry(t *testing.T) {
+	db := newTestDB(t)
+	cases := []struct {
+		name    string
+		options []TxnOption
+		fails   int
+		calls   int
+		err     bool
+	}{
+		{name: "no retry by default", fails: 1, calls: 1, err: true},
+		{name: "retry succeed", options: []TxnOption{WithTxnMaxRetries(2), WithTxnBackoff(time.Millisecond, time.Millisecond)}, fails: 2, calls: 3},
+		{name: "retry exhausted", options: []TxnOption{WithTxnMaxRetries(2), WithTxnBackoff(time.Millisecond, time.Millisecond)}, fails: 3, calls: 3, err: true},
+		{name: "zero backoff", options: []TxnOption{WithTxnMaxRetries(1), WithTxnBackoff(0, 0)}, fails: 1, calls: 2},
+		{name: "negative backoff", options: []TxnOption{WithTxnMaxRetries(1), WithTxnBackoff(-time.Second, -time.Second)}, fails: 1, calls: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var calls int
+			err := ExecuteFuncWithTxn(newTestContext(), db, func(tx *gorm.DB) error {
+				calls++
+				if calls <= c.fails {
+					return sqlStateError("40001")
+				}
+				return nil
+			}, c.options...)
+			require.Equal(t, c.err, err != nil)
+			require.Equal(t, c.calls, calls)
+		})
+	}
+
+	// The non-retryable error is returned immediately.
+	var calls int
+	err := ExecuteFuncWithTxn(newTestContext(), db, func(tx *gorm.DB) error {
+		calls++
+		return errors.New("other")
+	}, WithTxnMaxRetries(3))
+	require.NotNil(t, err)
+	require.Equal(t, 1, calls)
+
+	// The retry stops when ctx done.
+	ctx, cancel := context.WithCancel(newTestContext())
+	calls = 0
+	err = ExecuteFuncWithTxn(ctx, db, func(tx *gorm.DB) error {
+		calls++
+		cancel()
+		return sqlStateError("40001")
+	}, WithTxnMaxRetries(3), WithTxnBackoff(time.Minute, time.Minute))
+	require.NotNil(t, err)
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,384 @@
+package gormwrap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/gorm"
+)
+
+// DefaultMigrationTable is the default table name that records the applied migrations.
+const DefaultMigrationTable = "schema_migrations"
+
+var (
+	// ErrMigrationChecksum is returned if an applied migration has been modified.
+	ErrMigrationChecksum = errors.New("gormwrap: migration checksum mismatch")
+	// ErrMigrationUnknown is returned if an applied migration isn't in the migration list.
+	ErrMigrationUnknown = errors.New("gormwrap: unknown applied migration")
+)
+
+// Migration is a versioned schema change. Either the SQL or the func can be set for each direction,
+// the func takes precedence over the SQL if both set.
+//
+// The SQL may contains multiple statements, each statement ends with ";" at the end of line.
+type Migration struct {
+	// Version is the unique and increasing number of migration, eg: 1, 2 or 20230528.
+	Version int64
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// Checksum returns the sha256 of the up and down SQL, used to detect the applied migration has been modified.
+// Returns empty if the migration is written by Go func.
+func (m *Migration) Checksum() string {
+	if m.Up != nil || m.UpSQL == "" {
+		return ""
+	}
+	h := sha256.New()
+	_, _ = h.Write([]byte(m.UpSQL))
+	// Separates the up and down, so that moving the text between them changes the checksum.
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(m.DownSQL))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MigrationStatus is the status of a migration.
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	Applied bool
+	// AppliedAt unix timestamp, 0 if not applied.
+	AppliedAt int64
+	// Modified reports whether the migration has been modified since it applied.
+	Modified bool
+}
+
+// migrationHistory is the record of applied migration.
+type migrationHistory struct {
+	Version   int64  `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string `gorm:"column:name;size:255;not null"`
+	Checksum  string `gorm:"column:checksum;size:64;not null"`
+	AppliedAt int64  `gorm:"column:applied_at;not null"`
+}
+
+// Locker is used to serialize the migration runs across replicas, eg: *getcd.Mutex.
+type Locker interface {
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+type MigratorOption func(o *migratorOptions)
+
+type migratorOptions struct {
+	table  string
+	locker Locker
+	dryRun bool
+}
+
+func applyMigratorOptions(options ...MigratorOption) migratorOptions {
+	opts := migratorOptions{
+		table:  DefaultMigrationTable,
+		locker: nil,
+		dryRun: false,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithMigrationTable sets the table name that records the applied migrations. Defaults DefaultMigrationTable.
+func WithMigrationTable(table string) MigratorOption {
+	return func(o *migratorOptions) {
+		o.table = table
+	}
+}
+
+// WithMigrationLocker sets the Locker to serialize runs across replicas, eg:
+//
+//	mutex, _ := getcd.NewMutex(ctx, cli, "/migrations/<service>")
+//	migrator, _ := gormwrap.NewMigrator(ctx, db, migrations, gormwrap.WithMigrationLocker(mutex))
+func WithMigrationLocker(locker Locker) MigratorOption {
+	return func(o *migratorOptions) {
+		o.locker = locker
+	}
+}
+
+// WithMigrationDryRun controls whether only to log the SQL without execute and record it.
+func WithMigrationDryRun(ok bool) MigratorOption {
+	return func(o *migratorOptions) {
+		o.dryRun = ok
+	}
+}
+
+// Migrator applies and rolls back the migrations, and records the applied versions in a history table.
+type Migrator struct {
+	lp         *glog.Logger
+	db         *gorm.DB
+	migrations []*Migration
+	opts       migratorOptions
+}
+
+// NewMigrator creates a Migrator, the migrations are sorted by version.
+func NewMigrator(ctx context.Context, db *gorm.DB, migrations []*Migration, options ...MigratorOption) (*Migrator, error) {
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for i, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("gormwrap: invalid migration version %d", m.Version)
+		}
+		if m.Up == nil && m.UpSQL == "" {
+			return nil, fmt.Errorf("gormwrap: migration %d has no up", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("gormwrap: duplicate migration version %d", m.Version)
+		}
+	}
+
+	return &Migrator{
+		lp:         glog.FromContext(ctx),
+		db:         db,
+		migrations: sorted,
+		opts:       applyMigratorOptions(options...),
+	}, nil
+}
+
+// Up applies all pending migrations in order.
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.UpTo(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// UpTo applies the pending migrations whose version <= target in order.
+// The applied migrations are verified by checksum before apply.
+func (m *Migrator) UpTo(ctx context.Context, target int64) error {
+	return m.withLock(ctx, func() error {
+		histories, err := m.histories(ctx)
+		if err != nil {
+			return err
+		}
+		if err = m.verify(histories); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if migration.Version > target {
+				break
+			}
+			if _, ok := histories[migration.Version]; ok {
+				continue
+			}
+			if err = m.apply(ctx, migration, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DownTo rolls back the applied migrations whose version > target in reverse order.
+func (m *Migrator) DownTo(ctx context.Context, target int64) error {
+	return m.withLock(ctx, func() error {
+		histories, err := m.histories(ctx)
+		if err != nil {
+			return err
+		}
+		if err = m.verify(histories); err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= target {
+				break
+			}
+			if _, ok := histories[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil && migration.DownSQL == "" {
+				return fmt.Errorf("gormwrap: migration %d has no down", migration.Version)
+			}
+			if err = m.apply(ctx, migration, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Verify checks whether the applied migrations are all known and unmodified.
+func (m *Migrator) Verify(ctx context.Context) error {
+	histories, err := m.histories(ctx)
+	if err != nil {
+		return err
+	}
+	return m.verify(histories)
+}
+
+// Status lists the status of all migrations in order.
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	histories, err := m.histories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]*MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		s := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if h, ok := histories[migration.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = h.AppliedAt
+			s.Modified = h.Checksum != migration.Checksum()
+		}
+		status[i] = s
+	}
+	return status, nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, f func() error) (err error) {
+	if m.opts.locker == nil {
+		return f()
+	}
+	m.lp.Debug().Msg("gorm: waiting for the migration lock").Fire()
+	if err = m.opts.locker.Lock(ctx); err != nil {
+		m.lp.Error().Error("gorm: acquire migration lock error", err).Fire()
+		return
+	}
+	defer func() {
+		if uErr := m.opts.locker.Unlock(context.Background()); uErr != nil {
+			m.lp.Error().Error("gorm: release migration lock error", uErr).Fire()
+		}
+	}()
+	return f()
+}
+
+// histories creates the history table if not exists and returns the applied migrations.
+// In dry run mode the table is not created, and returns empty if it not exists.
+func (m *Migrator) histories(ctx context.Context) (map[int64]*migrationHistory, error) {
+	db := m.db.WithContext(ctx)
+	if m.opts.dryRun {
+		if !db.Migrator().HasTable(m.opts.table) {
+			return map[int64]*migrationHistory{}, nil
+		}
+	} else if err := db.Table(m.opts.table).AutoMigrate(&migrationHistory{}); err != nil {
+		m.lp.Error().Error("gorm: create migration table error", err).String("table", m.opts.table).Fire()
+		return nil, err
+	}
+	var records []*migrationHistory
+	if err := db.Table(m.opts.table).Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	histories := make(map[int64]*migrationHistory, len(records))
+	for _, r := range records {
+		histories[r.Version] = r
+	}
+	return histories, nil
+}
+
+func (m *Migrator) verify(histories map[int64]*migrationHistory) error {
+	known := make(map[int64]*Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+	for version, h := range histories {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrMigrationUnknown, version)
+		}
+		if h.Checksum != migration.Checksum() {
+			return fmt.Errorf("%w: %d", ErrMigrationChecksum, version)
+		}
+	}
+	return nil
+}
+
+// apply executes the migration and records it in the same transaction.
+// NOTICE: DDL in MySQL causes an implicit commit, so the migration may be partially applied if failed.
+func (m *Migrator) apply(ctx context.Context, migration *Migration, up bool) error {
+	direction := "down"
+	f, text := migration.Down, migration.DownSQL
+	if up {
+		direction = "up"
+		f, text = migration.Up, migration.UpSQL
+	}
+
+	lg := m.lp.Clone()
+	defer func() { _ = lg.Close() }()
+	lg.WithFields().AddInt64("version", migration.Version)
+	lg.WithFields().AddString("name", migration.Name)
+	lg.WithFields().AddString("direction", direction)
+
+	if m.opts.dryRun {
+		lg.Info().Msg("gorm: dry run migration").Fire()
+		if f != nil {
+			return f(m.db.WithContext(ctx).Session(&gorm.Session{DryRun: true}))
+		}
+		for _, stmt := range splitStatements(text) {
+			lg.Info().Msg("gorm: dry run migration statement").String("sql", stmt).Fire()
+		}
+		return nil
+	}
+
+	lg.Info().Msg("gorm: applying migration").Fire()
+	start := time.Now()
+	err := ExecuteFuncWithTxn(ctx, m.db, func(tx *gorm.DB) error {
+		if f != nil {
+			if err := f(tx); err != nil {
+				return err
+			}
+		} else {
+			for _, stmt := range splitStatements(text) {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if !up {
+			return tx.Table(m.opts.table).Where("version = ?", migration.Version).Delete(&migrationHistory{}).Error
+		}
+		return tx.Table(m.opts.table).Create(&migrationHistory{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Checksum:  migration.Checksum(),
+			AppliedAt: time.Now().Unix(),
+		}).Error
+	})
+	if err != nil {
+		lg.Error().Error("gorm: apply migration error", err).Fire()
+		return err
+	}
+	lg.Info().Msg("gorm: migration applied").Millisecond("elapsed", time.Since(start)).Fire()
+	return nil
+}
+
+// splitStatements splits the SQL by ";" at the end of line, the empty statements are ignored.
+func splitStatements(text string) []string {
+	var stmts []string
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if stmt := strings.TrimSpace(b.String()); stmt != ";" {
+				stmts = append(stmts, stmt)
+			}
+			b.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(b.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
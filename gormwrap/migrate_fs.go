@@ -0,0 +1,77 @@
+//go:build go1.16
+// +build go1.16
+
+package gormwrap
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// LoadMigrationsFS loads the SQL migrations from the dir of fsys, eg: an embed.FS.
+//
+// The file names must be "<version>_<name>.up.sql" or "<version>_<name>.down.sql", eg:
+// "0001_create_users.up.sql". The other files are ignored.
+func LoadMigrationsFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fileName := entry.Name()
+
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			up, base = true, strings.TrimSuffix(fileName, ".up.sql")
+		case strings.HasSuffix(fileName, ".down.sql"):
+			up, base = false, strings.TrimSuffix(fileName, ".down.sql")
+		default:
+			continue
+		}
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("gormwrap: invalid migration file name %s", fileName)
+		}
+		var name string
+		if len(parts) == 2 {
+			name = parts[1]
+		}
+
+		b, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := migrations[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			migrations[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("gormwrap: migration %d has different names %s and %s", version, m.Name, name)
+		}
+		if up {
+			m.UpSQL = string(b)
+		} else {
+			m.DownSQL = string(b)
+		}
+	}
+
+	list := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	return list, nil
+}
@@ -0,0 +1,251 @@
+package gormwrap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestMigrations() []*Migration {
+	return []*Migration{
+		{
+			Version: 1,
+			Name:    "create_a_b",
+			UpSQL:   "CREATE TABLE a (id INTEGER);\nCREATE TABLE b (id INTEGER);\n",
+			DownSQL: "DROP TABLE b;\nDROP TABLE a;\n",
+		},
+		{
+			Version: 2,
+			Name:    "create_c",
+			Up:      func(tx *gorm.DB) error { return tx.Exec("CREATE TABLE c (id INTEGER)").Error },
+			Down:    func(tx *gorm.DB) error { return tx.Exec("DROP TABLE c").Error },
+		},
+		{
+			Version: 3,
+			Name:    "create_d",
+			UpSQL:   "CREATE TABLE d (id INTEGER);",
+			DownSQL: "DROP TABLE d;",
+		},
+	}
+}
+
+func Test_MigrationChecksum(t *testing.T) {
+	base := &Migration{UpSQL: "CREATE TABLE a (id INTEGER);", DownSQL: "DROP TABLE a;"}
+	cases := []struct {
+		name      string
+		migration *Migration
+		same      bool
+	}{
+		{name: "unchanged", migration: &Migration{UpSQL: base.UpSQL, DownSQL: base.DownSQL}, same: true},
+		{name: "up changed", migration: &Migration{UpSQL: "CREATE TABLE b (id INTEGER);", DownSQL: base.DownSQL}},
+		{name: "down changed", migration: &Migration{UpSQL: base.UpSQL, DownSQL: "DROP TABLE IF EXISTS a;"}},
+		{name: "down removed", migration: &Migration{UpSQL: base.UpSQL}},
+		{name: "text moved", migration: &Migration{UpSQL: base.UpSQL + base.DownSQL}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.same, base.Checksum() == c.migration.Checksum())
+		})
+	}
+
+	// The migration written by Go func has no checksum.
+	require.Empty(t, (&Migration{Up: func(tx *gorm.DB) error { return nil }, UpSQL: "SELECT 1;"}).Checksum())
+}
+
+func Test_MigratorUpDown(t *testing.T) {
+	ctx := newTestContext()
+	db := newTestDB(t)
+	m, err := NewMigrator(ctx, db, newTestMigrations())
+	require.Nil(t, err)
+
+	hasTables := func() []bool {
+		var has []bool
+		for _, table := range []string{"a", "b", "c", "d"} {
+			has = append(has, db.Migrator().HasTable(table))
+		}
+		return has
+	}
+	applied := func() []bool {
+		status, err := m.Status(ctx)
+		require.Nil(t, err)
+		var applied []bool
+		for _, s := range status {
+			require.False(t, s.Modified)
+			applied = append(applied, s.Applied)
+		}
+		return applied
+	}
+
+	cases := []struct {
+		name    string
+		run     func() error
+		tables  []bool
+		applied []bool
+	}{
+		{
+			name:    "up to 2",
+			run:     func() error { return m.UpTo(ctx, 2) },
+			tables:  []bool{true, true, true, false},
+			applied: []bool{true, true, false},
+		},
+		{
+			name:    "up all",
+			run:     func() error { return m.Up(ctx) },
+			tables:  []bool{true, true, true, true},
+			applied: []bool{true, true, true},
+		},
+		{
+			name:    "up again",
+			run:     func() error { return m.Up(ctx) },
+			tables:  []bool{true, true, true, true},
+			applied: []bool{true, true, true},
+		},
+		{
+			name:    "down to 1",
+			run:     func() error { return m.DownTo(ctx, 1) },
+			tables:  []bool{true, true, false, false},
+			applied: []bool{true, false, false},
+		},
+		{
+			name:    "down all",
+			run:     func() error { return m.DownTo(ctx, 0) },
+			tables:  []bool{false, false, false, false},
+			applied: []bool{false, false, false},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Nil(t, c.run())
+			require.Equal(t, c.tables, hasTables())
+			require.Equal(t, c.applied, applied())
+		})
+	}
+}
+
+func Test_MigratorNoDown(t *testing.T) {
+	ctx := newTestContext()
+	db := newTestDB(t)
+	migrations := newTestMigrations()
+	migrations[2].DownSQL = ""
+	m, err := NewMigrator(ctx, db, migrations)
+	require.Nil(t, err)
+
+	require.Nil(t, m.Up(ctx))
+	require.NotNil(t, m.DownTo(ctx, 0))
+	// Nothing is rolled back.
+	require.True(t, db.Migrator().HasTable("d"))
+	require.True(t, db.Migrator().HasTable("a"))
+}
+
+func Test_MigratorDryRun(t *testing.T) {
+	ctx := newTestContext()
+	db := newTestDB(t)
+	dm, err := NewMigrator(ctx, db, newTestMigrations(), WithMigrationDryRun(true))
+	require.Nil(t, err)
+
+	// Nothing is created, even the history table.
+	require.Nil(t, dm.Up(ctx))
+	require.False(t, db.Migrator().HasTable(DefaultMigrationTable))
+	for _, table := range []string{"a", "b", "c", "d"} {
+		require.False(t, db.Migrator().HasTable(table))
+	}
+	status, err := dm.Status(ctx)
+	require.Nil(t, err)
+	require.Len(t, status, 3)
+	for _, s := range status {
+		require.False(t, s.Applied)
+	}
+
+	// The applied migrations are read from the existing history table.
+	m, err := NewMigrator(ctx, db, newTestMigrations())
+	require.Nil(t, err)
+	require.Nil(t, m.UpTo(ctx, 1))
+	require.Nil(t, dm.Up(ctx))
+	require.Nil(t, dm.DownTo(ctx, 0))
+	require.True(t, db.Migrator().HasTable("a"))
+	require.False(t, db.Migrator().HasTable("c"))
+	status, err = dm.Status(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []bool{true, false, false}, []bool{status[0].Applied, status[1].Applied, status[2].Applied})
+}
+
+func Test_MigratorVerify(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(migrations []*Migration) []*Migration
+		err    error
+	}{
+		{
+			name:   "unmodified",
+			modify: func(migrations []*Migration) []*Migration { return migrations },
+		},
+		{
+			name: "up modified",
+			modify: func(migrations []*Migration) []*Migration {
+				migrations[0].UpSQL = "CREATE TABLE a (id INTEGER, name TEXT);\nCREATE TABLE b (id INTEGER);\n"
+				return migrations
+			},
+			err: ErrMigrationChecksum,
+		},
+		{
+			name: "down modified",
+			modify: func(migrations []*Migration) []*Migration {
+				migrations[0].DownSQL = "DROP TABLE a;\n"
+				return migrations
+			},
+			err: ErrMigrationChecksum,
+		},
+		{
+			name: "func modified",
+			modify: func(migrations []*Migration) []*Migration {
+				migrations[1].Up = func(tx *gorm.DB) error { return nil }
+				return migrations
+			},
+		},
+		{
+			name:   "applied removed",
+			modify: func(migrations []*Migration) []*Migration { return migrations[1:] },
+			err:    ErrMigrationUnknown,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			m, err := NewMigrator(newTestContext(), db, newTestMigrations()[:2])
+			require.Nil(t, err)
+			require.Nil(t, m.Up(ctx))
+
+			m, err = NewMigrator(newTestContext(), db, c.modify(newTestMigrations()))
+			require.Nil(t, err)
+			err = m.Verify(ctx)
+			if c.err == nil {
+				require.Nil(t, err)
+				require.Nil(t, m.Up(ctx))
+				return
+			}
+			require.True(t, errors.Is(err, c.err), err)
+			// The pending migrations are not applied if verify failed.
+			require.True(t, errors.Is(m.Up(ctx), c.err))
+			require.False(t, db.Migrator().HasTable("d"))
+		})
+	}
+}
+
+func Test_SplitStatements(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: nil},
+		{text: "SELECT 1", want: []string{"SELECT 1"}},
+		{text: "SELECT 1;\n;\nSELECT 2;", want: []string{"SELECT 1;", "SELECT 2;"}},
+		{text: "INSERT INTO a\nVALUES ('x;y');\nSELECT 3", want: []string{"INSERT INTO a\nVALUES ('x;y');", "SELECT 3"}},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.want, splitStatements(c.text), c.text)
+	}
+}
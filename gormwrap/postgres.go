@@ -0,0 +1,76 @@
+package gormwrap
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type PostgresConfig struct {
+	// Hosts sample "127.0.0.1:5432,127.0.0.1:5433", the hosts are tried in order until one connected.
+	Hosts       string `json:"hosts"         yaml:"hosts"         env:"HOSTS"                     validate:"required"`
+	Users       string `json:"users"         yaml:"users"         env:"USERS"                     validate:"required"`
+	Password    string `json:"password"      yaml:"password"      env:"PASSWORD"                  validate:"required"`
+	Database    string `json:"database"      yaml:"database"      env:"DATABASE"                  validate:"required"`
+	MaxIdleConn int    `json:"max_idle_conn" yaml:"max_idle_conn" env:"MAX_IDLE_CONN,default=16"  validate:"required"`
+	MaxOpenConn int    `json:"max_open_conn" yaml:"max_open_conn" env:"MAX_OPEN_CONN,default=128" validate:"required"`
+	// Optional Value: "disable/allow/prefer/require/verify-ca/verify-full".
+	SSLMode string `json:"ssl_mode" yaml:"ssl_mode" env:"SSL_MODE,default=disable" validate:"required"`
+	// The search_path of session, empty means the default "public".
+	Schema string `json:"schema" yaml:"schema" env:"SCHEMA"`
+	// gorm log level: 1 => Silent, 2 => Error, 3 => Warn, 4 => Info
+	LogLevel int `json:"log_level" yaml:"log_level" env:"LOG_LEVEL,default=4" validate:"gte=1,lte=4"`
+	// ConnMaxLifetime unit seconds
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME,default=10m" validate:"required"`
+	// SlowThreshold time 0 indicates disabled
+	SlowThreshold time.Duration `json:"slow_threshold" yaml:"slow_threshold" env:"SLOW_THRESHOLD,default=2s" validate:"gte=0"`
+}
+
+// NewPostgresConn return a grom.DB by postgres driver
+// NOTICE: Must set glog.Logger into the ctx by glow.WithContext
+func NewPostgresConn(ctx context.Context, cfg *PostgresConfig) (db *gorm.DB, err error) {
+	lp := glog.FromContext(ctx)
+
+	defer func() {
+		if err != nil {
+			lp.Error().Error("gorm: create postgres connection error", err).Fire()
+			db = nil
+		}
+	}()
+
+	lp.Info().Msg("gorm: connecting to postgres").String("hosts", cfg.Hosts).String("database", cfg.Database).Fire()
+
+	hosts := strings.ReplaceAll(cfg.Hosts, " ", "")
+	if hosts == "" {
+		err = fmt.Errorf("invalid hosts %s", cfg.Hosts)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", cfg.SSLMode)
+	if cfg.Schema != "" {
+		query.Set("search_path", cfg.Schema)
+	}
+	dsn := (&url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.Users, cfg.Password),
+		Host:     hosts,
+		Path:     "/" + cfg.Database,
+		RawQuery: query.Encode(),
+	}).String()
+
+	db, _, err = openConn(ctx, postgres.Open(dsn), &poolConfig{
+		LogLevel:        cfg.LogLevel,
+		SlowThreshold:   cfg.SlowThreshold,
+		MaxIdleConn:     cfg.MaxIdleConn,
+		MaxOpenConn:     cfg.MaxOpenConn,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	})
+	return
+}
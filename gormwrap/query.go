@@ -0,0 +1,516 @@
+package gormwrap
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// Op is the operator of Filter.
+type Op string
+
+const (
+	OpEq    Op = "eq"
+	OpNe    Op = "ne"
+	OpIn    Op = "in"
+	OpLike  Op = "like"
+	OpGt    Op = "gt"
+	OpGte   Op = "gte"
+	OpLt    Op = "lt"
+	OpLte   Op = "lte"
+	OpRange Op = "range"
+	OpNull  Op = "null"
+)
+
+// ErrCursorNullValue is returned by QuerySchema.NextCursor if the value of an ordering field is NULL.
+var ErrCursorNullValue = errors.New("gormwrap: cursor value is null")
+
+// The reserved keys of url query in QuerySchema.ParseQuery.
+const (
+	QueryKeyLimit  = "limit"
+	QueryKeyOffset = "offset"
+	QueryKeyCursor = "cursor"
+	QueryKeySortBy = "sort_by"
+)
+
+// Filter is a condition on a field or a group of nested conditions.
+// It's a group if the And or Or is not empty, otherwise it's a condition on the Field.
+// The Or group is joint with the And filters by AND if both are set.
+type Filter struct {
+	Field  string
+	Op     Op
+	Values []interface{}
+
+	And []*Filter
+	Or  []*Filter
+}
+
+// Eq returns the Filter of "field = value".
+func Eq(field string, value interface{}) *Filter {
+	return &Filter{Field: field, Op: OpEq, Values: []interface{}{value}}
+}
+
+// Ne returns the Filter of "field <> value".
+func Ne(field string, value interface{}) *Filter {
+	return &Filter{Field: field, Op: OpNe, Values: []interface{}{value}}
+}
+
+// In returns the Filter of "field IN (values...)".
+func In(field string, values ...interface{}) *Filter {
+	return &Filter{Field: field, Op: OpIn, Values: values}
+}
+
+// Like returns the Filter of "field LIKE %value%", the wildcards in value are escaped.
+func Like(field string, value string) *Filter {
+	return &Filter{Field: field, Op: OpLike, Values: []interface{}{value}}
+}
+
+// Range returns the Filter of "field >= min AND field <= max", the nil min or max means unbounded.
+func Range(field string, min interface{}, max interface{}) *Filter {
+	return &Filter{Field: field, Op: OpRange, Values: []interface{}{min, max}}
+}
+
+// IsNull returns the Filter of "field IS NULL" if null is true, otherwise "field IS NOT NULL".
+func IsNull(field string, null bool) *Filter {
+	return &Filter{Field: field, Op: OpNull, Values: []interface{}{null}}
+}
+
+// And returns a group Filter that all of the filters are matched.
+func And(filters ...*Filter) *Filter {
+	return &Filter{And: filters}
+}
+
+// Or returns a group Filter that any of the filters is matched.
+func Or(filters ...*Filter) *Filter {
+	return &Filter{Or: filters}
+}
+
+// Order is a ordering field.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Query is the typed list query, it's built into SQL by QuerySchema.Build.
+type Query struct {
+	Filter *Filter
+	Orders []Order
+	Limit  int
+	// Offset is ignored if the Cursor is set.
+	Offset int
+	// Cursor is the keyset pagination position returned by QuerySchema.NextCursor.
+	Cursor string
+}
+
+// ListRequest is implements by the protobuf list requests.
+type ListRequest interface {
+	GetLimit() int32
+	GetOffset() int32
+	GetSortBy() string
+	GetReverse() bool
+}
+
+// QuerySchema is the whitelist of the fields can be filtered and sorted for a model.
+type QuerySchema struct {
+	// map field name to column name.
+	columns    map[string]string
+	primaryKey string
+}
+
+// NewQuerySchema creates a QuerySchema by the columns that map field name to column name, eg:
+//
+//	gormwrap.NewQuerySchema("id", map[string]string{"id": "id", "name": "name", "created": "created"})
+//
+// The primaryKey is the field that unique identifies a row, it's always appended to the orders
+// to make the result ordering deterministic.
+func NewQuerySchema(primaryKey string, columns map[string]string) *QuerySchema {
+	if _, ok := columns[primaryKey]; !ok {
+		panic("gormwrap NewQuerySchema: primaryKey must be one of the columns")
+	}
+	return &QuerySchema{columns: columns, primaryKey: primaryKey}
+}
+
+// ParseListRequest converts the protobuf list request to Query.
+func (s *QuerySchema) ParseListRequest(req ListRequest) (*Query, error) {
+	q := &Query{
+		Limit:  int(req.GetLimit()),
+		Offset: int(req.GetOffset()),
+	}
+	if sortBy := req.GetSortBy(); sortBy != "" {
+		if _, ok := s.columns[sortBy]; !ok {
+			return nil, qerror.InvalidParams.Format("sort_by")
+		}
+		q.Orders = []Order{{Field: sortBy, Desc: req.GetReverse()}}
+	}
+	return q, nil
+}
+
+// ParseQuery converts url query (eg: gin.Context.Request.URL.Query()) to Query, all conditions are joint with AND.
+//
+// The keys are "<field>" or "<field>.<op>", eg:
+//
+//	?name.like=abc&status.in=1,2&created.range=1600000000,&owner.null=false&sort_by=-created,name&limit=10&cursor=xxx
+//
+// The values of "in" and "range" are separated by comma, the empty side of "range" means unbounded.
+// The "-" prefix in sort_by means descending. The keys that is not a field are ignored, even if
+// it contains a ".", eg: "utm.source"; but an unknown op of a field is invalid.
+func (s *QuerySchema) ParseQuery(values url.Values) (q *Query, err error) {
+	q = &Query{}
+
+	if v := values.Get(QueryKeyLimit); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return nil, qerror.InvalidParams.Format(QueryKeyLimit)
+		}
+	}
+	if v := values.Get(QueryKeyOffset); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return nil, qerror.InvalidParams.Format(QueryKeyOffset)
+		}
+	}
+	q.Cursor = values.Get(QueryKeyCursor)
+	if v := values.Get(QueryKeySortBy); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			order := Order{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+			if _, ok := s.columns[order.Field]; !ok {
+				return nil, qerror.InvalidParams.Format(QueryKeySortBy)
+			}
+			q.Orders = append(q.Orders, order)
+		}
+	}
+
+	// Sort the keys to make the clause order deterministic.
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var filters []*Filter
+	for _, key := range keys {
+		field, op := key, OpEq
+		if i := strings.LastIndex(key, "."); i > 0 {
+			field, op = key[:i], Op(key[i+1:])
+		}
+		// Ignore the other parameters, eg: "utm.source" or "_t".
+		if _, ok := s.columns[field]; !ok {
+			continue
+		}
+
+		value := values.Get(key)
+		var f *Filter
+		switch op {
+		case OpEq, OpNe, OpLike, OpGt, OpGte, OpLt, OpLte:
+			f = &Filter{Field: field, Op: op, Values: []interface{}{value}}
+		case OpIn:
+			items := strings.Split(value, ",")
+			f = &Filter{Field: field, Op: op, Values: make([]interface{}, len(items))}
+			for i := range items {
+				f.Values[i] = items[i]
+			}
+		case OpRange:
+			items := strings.Split(value, ",")
+			if len(items) != 2 {
+				return nil, qerror.InvalidParams.Format(key)
+			}
+			f = &Filter{Field: field, Op: op, Values: []interface{}{nil, nil}}
+			for i := range items {
+				if items[i] != "" {
+					f.Values[i] = items[i]
+				}
+			}
+		case OpNull:
+			null, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, qerror.InvalidParams.Format(key)
+			}
+			f = IsNull(field, null)
+		default:
+			return nil, qerror.InvalidParams.Format(key)
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) > 0 {
+		q.Filter = And(filters...)
+	}
+	return q, nil
+}
+
+// Build adds the where, order, limit and offset (or keyset) clauses of q to tx.
+// Returns qerror.InvalidParams if the fields are not in the QuerySchema.
+func (s *QuerySchema) Build(tx *gorm.DB, q *Query) (*gorm.DB, error) {
+	if q.Filter != nil {
+		expr, err := s.buildFilter(q.Filter)
+		if err != nil {
+			return nil, err
+		}
+		if expr != nil {
+			tx = tx.Where(expr)
+		}
+	}
+
+	orders, err := s.orders(q.Orders)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: s.columns[order.Field]}, Desc: order.Desc})
+	}
+
+	if q.Cursor != "" {
+		expr, err := s.buildCursor(orders, q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where(expr)
+	} else if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+	return tx, nil
+}
+
+// NextCursor returns the cursor of next page by the last row of current page, the row must be a struct
+// or a pointer to struct of the model. The ordering columns must be NOT NULL numbers or strings,
+// since the keyset condition never matches NULL. Returns ErrCursorNullValue if the value is NULL.
+func (s *QuerySchema) NextCursor(db *gorm.DB, q *Query, last interface{}) (string, error) {
+	orders, err := s.orders(q.Orders)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err = stmt.Parse(last); err != nil {
+		return "", err
+	}
+	rv := reflect.Indirect(reflect.ValueOf(last))
+
+	values := make([]interface{}, len(orders))
+	for i, order := range orders {
+		field := stmt.Schema.LookUpField(s.columns[order.Field])
+		if field == nil {
+			return "", qerror.InvalidParams.Format(order.Field)
+		}
+		value, _ := field.ValueOf(db.Statement.Context, rv)
+		if valuer, ok := value.(driver.Valuer); ok {
+			if value, err = valuer.Value(); err != nil {
+				return "", err
+			}
+		}
+		if isNull(value) {
+			return "", fmt.Errorf("%w: %s", ErrCursorNullValue, order.Field)
+		}
+		values[i] = value
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// orders returns the orders with the primary key appended if absent.
+func (s *QuerySchema) orders(orders []Order) ([]Order, error) {
+	result := make([]Order, 0, len(orders)+1)
+	var hasPrimary bool
+	for _, order := range orders {
+		if _, ok := s.columns[order.Field]; !ok {
+			return nil, qerror.InvalidParams.Format(order.Field)
+		}
+		if order.Field == s.primaryKey {
+			hasPrimary = true
+		}
+		result = append(result, order)
+	}
+	if !hasPrimary {
+		var desc bool
+		if len(orders) > 0 {
+			desc = orders[len(orders)-1].Desc
+		}
+		result = append(result, Order{Field: s.primaryKey, Desc: desc})
+	}
+	return result, nil
+}
+
+// buildCursor builds the keyset condition "(c1 > v1) OR (c1 = v1 AND c2 > v2) OR ...".
+func (s *QuerySchema) buildCursor(orders []Order, cursor string) (clause.Expression, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, qerror.InvalidParams.Format(QueryKeyCursor)
+	}
+	var values []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	// Keep the numbers as is to avoid losing precision of int64.
+	decoder.UseNumber()
+	if err = decoder.Decode(&values); err != nil || len(values) != len(orders) {
+		return nil, qerror.InvalidParams.Format(QueryKeyCursor)
+	}
+	for i := range values {
+		switch v := values[i].(type) {
+		case nil:
+			return nil, qerror.InvalidParams.Format(QueryKeyCursor)
+		case json.Number:
+			if values[i], err = v.Int64(); err == nil {
+				continue
+			}
+			if values[i], err = v.Float64(); err != nil {
+				return nil, qerror.InvalidParams.Format(QueryKeyCursor)
+			}
+		case string, bool:
+		default:
+			return nil, qerror.InvalidParams.Format(QueryKeyCursor)
+		}
+	}
+
+	ors := make([]clause.Expression, len(orders))
+	for i, order := range orders {
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Eq{Column: clause.Column{Name: s.columns[orders[j].Field]}, Value: values[j]})
+		}
+		column := clause.Column{Name: s.columns[order.Field]}
+		if order.Desc {
+			ands = append(ands, clause.Lt{Column: column, Value: values[i]})
+		} else {
+			ands = append(ands, clause.Gt{Column: column, Value: values[i]})
+		}
+		ors[i] = clause.And(ands...)
+	}
+	return clause.Or(ors...), nil
+}
+
+// isNull reports whether the value is nil or a nil pointer.
+func isNull(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	rv := reflect.ValueOf(value)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func (s *QuerySchema) buildFilter(f *Filter) (clause.Expression, error) {
+	if len(f.And) > 0 || len(f.Or) > 0 {
+		ands, err := s.buildFilters(f.And)
+		if err != nil {
+			return nil, err
+		}
+		ors, err := s.buildFilters(f.Or)
+		if err != nil {
+			return nil, err
+		}
+		// The Or group is joint with the And filters if both are set.
+		if len(ors) > 0 {
+			ands = append(ands, clause.Or(ors...))
+		}
+		if len(ands) == 0 {
+			return nil, nil
+		}
+		return clause.And(ands...), nil
+	}
+
+	column, ok := s.columns[f.Field]
+	if !ok {
+		return nil, qerror.InvalidParams.Format(f.Field)
+	}
+	col := clause.Column{Name: column}
+
+	switch f.Op {
+	case OpIn:
+		if len(f.Values) == 0 {
+			return nil, qerror.InvalidParams.Format(f.Field)
+		}
+		return clause.IN{Column: col, Values: f.Values}, nil
+	case OpRange:
+		if len(f.Values) != 2 {
+			return nil, qerror.InvalidParams.Format(f.Field)
+		}
+		var exprs []clause.Expression
+		if f.Values[0] != nil {
+			exprs = append(exprs, clause.Gte{Column: col, Value: f.Values[0]})
+		}
+		if f.Values[1] != nil {
+			exprs = append(exprs, clause.Lte{Column: col, Value: f.Values[1]})
+		}
+		if len(exprs) == 0 {
+			return nil, nil
+		}
+		return clause.And(exprs...), nil
+	}
+
+	if len(f.Values) != 1 {
+		return nil, qerror.InvalidParams.Format(f.Field)
+	}
+	value := f.Values[0]
+	switch f.Op {
+	case OpEq:
+		return clause.Eq{Column: col, Value: value}, nil
+	case OpNe:
+		return clause.Neq{Column: col, Value: value}, nil
+	case OpGt:
+		return clause.Gt{Column: col, Value: value}, nil
+	case OpGte:
+		return clause.Gte{Column: col, Value: value}, nil
+	case OpLt:
+		return clause.Lt{Column: col, Value: value}, nil
+	case OpLte:
+		return clause.Lte{Column: col, Value: value}, nil
+	case OpLike:
+		str, ok := value.(string)
+		if !ok {
+			return nil, qerror.InvalidParams.Format(f.Field)
+		}
+		return clause.Expr{
+			SQL:  "? LIKE ? ESCAPE '" + likeEscape + "'",
+			Vars: []interface{}{col, CondLikePlacehold + escapeLike(str) + CondLikePlacehold},
+		}, nil
+	case OpNull:
+		null, ok := value.(bool)
+		if !ok {
+			return nil, qerror.InvalidParams.Format(f.Field)
+		}
+		if null {
+			return clause.Eq{Column: col, Value: nil}, nil
+		}
+		return clause.Neq{Column: col, Value: nil}, nil
+	default:
+		return nil, qerror.InvalidParams.Format(f.Field)
+	}
+}
+
+func (s *QuerySchema) buildFilters(filters []*Filter) ([]clause.Expression, error) {
+	exprs := make([]clause.Expression, 0, len(filters))
+	for _, f := range filters {
+		expr, err := s.buildFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		if expr != nil {
+			exprs = append(exprs, expr)
+		}
+	}
+	return exprs, nil
+}
+
+// likeEscape is the escape character of LIKE, the backslash isn't used since it needs to be escaped in MySQL.
+const likeEscape = "!"
+
+var likeReplacer = strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_")
+
+// escapeLike escapes the wildcards of LIKE with likeEscape.
+func escapeLike(s string) string {
+	return likeReplacer.Replace(s)
+}
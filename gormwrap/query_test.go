@@ -0,0 +1,181 @@
+package gormwrap
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type queryItem struct {
+	ID    int64
+	Name  string
+	Score float64
+	Owner *string
+}
+
+func newQueryTestDB(t *testing.T) *gorm.DB {
+	db := newTestDB(t)
+	require.Nil(t, db.AutoMigrate(&queryItem{}))
+	owner := "u1"
+	items := []*queryItem{
+		{ID: 1 << 60, Name: "b", Score: 1.5},
+		{ID: 2, Name: "a", Score: 0.25, Owner: &owner},
+		{ID: 3, Name: "c", Score: 1.5},
+		{ID: 4, Name: "a", Score: 3},
+		{ID: 5, Name: "b", Score: 0.25},
+		{ID: 6, Name: "c", Score: 2},
+		{ID: 7, Name: "a", Score: 1.5},
+	}
+	require.Nil(t, db.Create(items).Error)
+	return db
+}
+
+func Test_QuerySchemaCursor(t *testing.T) {
+	db := newQueryTestDB(t)
+	schema := NewQuerySchema("id", map[string]string{"id": "id", "name": "name", "score": "score", "owner": "owner"})
+
+	cases := []struct {
+		name   string
+		orders []Order
+		want   []int64
+	}{
+		{
+			name: "primary key",
+			want: []int64{2, 3, 4, 5, 6, 7, 1 << 60},
+		},
+		{
+			name:   "primary key desc",
+			orders: []Order{{Field: "id", Desc: true}},
+			want:   []int64{1 << 60, 7, 6, 5, 4, 3, 2},
+		},
+		{
+			name:   "float desc",
+			orders: []Order{{Field: "score", Desc: true}},
+			want:   []int64{4, 6, 1 << 60, 7, 3, 5, 2},
+		},
+		{
+			name:   "string and float",
+			orders: []Order{{Field: "name"}, {Field: "score", Desc: true}},
+			want:   []int64{4, 7, 2, 1 << 60, 5, 6, 3},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q := &Query{Orders: c.orders, Limit: 3}
+			var got []int64
+			for page := 0; page < 10; page++ {
+				tx, err := schema.Build(db.Model(&queryItem{}), q)
+				require.Nil(t, err)
+				var items []*queryItem
+				require.Nil(t, tx.Find(&items).Error)
+				for _, item := range items {
+					got = append(got, item.ID)
+				}
+				if len(items) < q.Limit {
+					break
+				}
+				q.Cursor, err = schema.NextCursor(db, q, items[len(items)-1])
+				require.Nil(t, err)
+			}
+			require.Equal(t, c.want, got)
+		})
+	}
+}
+
+func Test_QuerySchemaCursorValues(t *testing.T) {
+	db := newQueryTestDB(t)
+	schema := NewQuerySchema("id", map[string]string{"id": "id", "name": "name", "score": "score", "owner": "owner"})
+
+	q := &Query{Orders: []Order{{Field: "score"}, {Field: "name"}}}
+	cursor, err := schema.NextCursor(db, q, &queryItem{ID: 1 << 60, Name: "b", Score: 1.5})
+	require.Nil(t, err)
+	q.Cursor = cursor
+
+	tx, err := schema.Build(db.Session(&gorm.Session{DryRun: true}).Model(&queryItem{}), q)
+	require.Nil(t, err)
+	stmt := tx.Find(&[]*queryItem{}).Statement
+	// The numbers are decoded as int64 and float64 instead of string.
+	require.Contains(t, stmt.Vars, float64(1.5))
+	require.Contains(t, stmt.Vars, int64(1<<60))
+	require.Contains(t, stmt.Vars, "b")
+
+	// The nullable field can't be used as cursor if the value is NULL.
+	q = &Query{Orders: []Order{{Field: "owner"}}}
+	_, err = schema.NextCursor(db, q, &queryItem{ID: 1})
+	require.True(t, errors.Is(err, ErrCursorNullValue))
+	owner := "u1"
+	_, err = schema.NextCursor(db, q, &queryItem{ID: 2, Owner: &owner})
+	require.Nil(t, err)
+}
+
+func Test_QuerySchemaInvalidCursor(t *testing.T) {
+	db := newQueryTestDB(t)
+	schema := NewQuerySchema("id", map[string]string{"id": "id", "name": "name"})
+
+	cases := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "not json", cursor: "bm90IGpzb24"},
+		// [1]
+		{name: "length mismatch", cursor: "WzFd"},
+		// [null,1]
+		{name: "null value", cursor: "W251bGwsMV0"},
+		// [{},1]
+		{name: "object value", cursor: "W3t9LDFd"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := schema.Build(db, &Query{Orders: []Order{{Field: "name"}}, Cursor: c.cursor})
+			require.NotNil(t, err)
+		})
+	}
+}
+
+func Test_QuerySchemaParseQuery(t *testing.T) {
+	db := newQueryTestDB(t)
+	schema := NewQuerySchema("id", map[string]string{"id": "id", "name": "name", "score": "score", "owner": "owner"})
+
+	values := url.Values{
+		"name.in":     {"a,b"},
+		"score.range": {"1,"},
+		"owner.null":  {"true"},
+		"sort_by":     {"-score,id"},
+		"limit":       {"10"},
+		// The keys that is not a field are ignored.
+		"utm.source": {"mail"},
+		"_t":         {"1600000000"},
+	}
+	q, err := schema.ParseQuery(values)
+	require.Nil(t, err)
+	require.Equal(t, &Query{
+		Filter: And(In("name", "a", "b"), IsNull("owner", true), Range("score", "1", nil)),
+		Orders: []Order{{Field: "score", Desc: true}, {Field: "id"}},
+		Limit:  10,
+	}, q)
+
+	tx, err := schema.Build(db.Model(&queryItem{}), q)
+	require.Nil(t, err)
+	var items []*queryItem
+	require.Nil(t, tx.Find(&items).Error)
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	require.Equal(t, []int64{4, 7, 1 << 60}, ids)
+
+	for _, invalid := range []url.Values{
+		{"name.unknown": {"a"}},
+		{"score.range": {"1"}},
+		{"owner.null": {"yes"}},
+		{"sort_by": {"utm"}},
+		{"limit": {"-1"}},
+	} {
+		_, err = schema.ParseQuery(invalid)
+		require.NotNil(t, err, "%v", invalid)
+	}
+}
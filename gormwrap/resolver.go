@@ -0,0 +1,206 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+const callBackUsePrimaryName = "gormwrap:use_primary"
+
+// callBackResolverName is the name of callbacks registered by dbresolver.
+const callBackResolverName = "gorm:db_resolver"
+
+type usePrimaryKey struct{}
+
+// UsePrimary returns a new context that forces the queries to be executed on primary, eg:
+//
+//	db.WithContext(gormwrap.UsePrimary(ctx)).Find(&users)
+//
+// It's useful to read the data just written, without replication lag.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usePrimaryKey{}, true)
+}
+
+func isUsePrimary(ctx context.Context) bool {
+	ok, _ := ctx.Value(usePrimaryKey{}).(bool)
+	return ok
+}
+
+// usePrimary returns a callback that calls the resolve callback of dbresolver again with the
+// write clause, thus the statement is routed to primary if the context by UsePrimary.
+func usePrimary(resolve func(tx *gorm.DB)) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		if tx.Statement.Context != nil && isUsePrimary(tx.Statement.Context) {
+			tx.Statement.AddClause(dbresolver.Write)
+			resolve(tx)
+		}
+	}
+}
+
+// replica is a read-only connection pool with health status.
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy int32
+}
+
+// healthPolicy implements dbresolver.Policy, it selects a healthy replica randomly.
+// The primary is always the last one of the connPools, it is used only if no replica is healthy.
+type healthPolicy struct {
+	replicas map[gorm.ConnPool]*replica
+}
+
+func (p *healthPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	healthy := make([]gorm.ConnPool, 0, len(connPools))
+	for _, connPool := range connPools {
+		if r, ok := p.replicas[connPool]; ok && atomic.LoadInt32(&r.healthy) == 1 {
+			healthy = append(healthy, connPool)
+		}
+	}
+	if len(healthy) == 0 {
+		return connPools[len(connPools)-1]
+	}
+	return healthy[rand.Intn(len(healthy))]
+}
+
+// useReplicas opens the replicas, registers the dbresolver plugin with them, and starts the health check.
+func useReplicas(ctx context.Context, db *gorm.DB, primary *sql.DB, cfg *MySQLConfig, replicaConfigs []*ReplicaConfig) (err error) {
+	lp := glog.FromContext(ctx)
+
+	replicas := make([]*replica, 0, len(replicaConfigs))
+	defer func() {
+		if err != nil {
+			for _, r := range replicas {
+				_ = r.db.Close()
+			}
+		}
+	}()
+
+	for _, rc := range replicaConfigs {
+		lp.Info().Msg("gorm: adding mysql replica").String("host", rc.Host).Fire()
+
+		var sqlDB *sql.DB
+		if sqlDB, err = sql.Open("mysql", mysqlDSN(cfg, rc.Host)); err != nil {
+			return
+		}
+		sqlDB.SetMaxIdleConns(pickInt(rc.MaxIdleConn, cfg.MaxIdleConn))
+		sqlDB.SetMaxOpenConns(pickInt(rc.MaxOpenConn, cfg.MaxOpenConn))
+		if rc.ConnMaxLifetime > 0 {
+			sqlDB.SetConnMaxLifetime(rc.ConnMaxLifetime)
+		} else {
+			sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+		}
+		replicas = append(replicas, &replica{host: rc.Host, db: sqlDB, healthy: 1})
+	}
+
+	// Don't query the server version, thus the replica is allowed to be unavailable at startup.
+	newDialector := func(conn *sql.DB) gorm.Dialector {
+		return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true})
+	}
+	err = registerReplicas(ctx, db, primary, replicas, newDialector, cfg.HealthCheckInterval)
+	return
+}
+
+// registerReplicas registers the dbresolver plugin with the opened replicas. The health check runs every
+// interval if interval > 0, and the replicas are closed when ctx done.
+func registerReplicas(ctx context.Context, db *gorm.DB, primary *sql.DB, replicas []*replica,
+	newDialector func(conn *sql.DB) gorm.Dialector, interval time.Duration) (err error) {
+	policy := &healthPolicy{replicas: make(map[gorm.ConnPool]*replica, len(replicas))}
+	dialectors := make([]gorm.Dialector, 0, len(replicas)+1)
+	for _, r := range replicas {
+		policy.replicas[r.db] = r
+		dialectors = append(dialectors, newDialector(r.db))
+	}
+	// Adds the primary as the fallback of replicas. It also makes the policy always be called
+	// since dbresolver skips the policy if only one replica.
+	dialectors = append(dialectors, newDialector(primary))
+
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   policy,
+	}))
+	if err != nil {
+		return
+	}
+
+	// Route to primary if the context by UsePrimary. The callbacks of dbresolver are registered before
+	// all others, so it resolves the connection again before the statement executed.
+	query, row, raw := db.Callback().Query(), db.Callback().Row(), db.Callback().Raw()
+	if err = query.Before("gorm:query").Register(callBackUsePrimaryName, usePrimary(query.Get(callBackResolverName))); err != nil {
+		return
+	}
+	if err = row.Before("gorm:row").Register(callBackUsePrimaryName, usePrimary(row.Get(callBackResolverName))); err != nil {
+		return
+	}
+	if err = raw.Before("gorm:raw").Register(callBackUsePrimaryName, usePrimary(raw.Get(callBackResolverName))); err != nil {
+		return
+	}
+
+	go checkReplicas(ctx, glog.FromContext(ctx), replicas, interval)
+	return
+}
+
+// checkReplicas pings the replicas every interval and updates their health status until ctx done,
+// then closes the replicas. The health check is disabled if interval <= 0.
+func checkReplicas(ctx context.Context, lp *glog.Logger, replicas []*replica, interval time.Duration) {
+	defer closeReplicas(lp, replicas)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, r := range replicas {
+			pctx, cancel := context.WithTimeout(ctx, interval)
+			err := r.db.PingContext(pctx)
+			cancel()
+
+			var healthy int32
+			if err == nil {
+				healthy = 1
+			}
+			if atomic.SwapInt32(&r.healthy, healthy) == healthy {
+				continue
+			}
+			if err != nil {
+				lp.Warn().Msg("gorm: mysql replica is unhealthy, skip it").String("host", r.host).Error("error", err).Fire()
+			} else {
+				lp.Info().Msg("gorm: mysql replica is recovered").String("host", r.host).Fire()
+			}
+		}
+	}
+}
+
+// closeReplicas marks the replicas unhealthy thus the queries are routed to primary, then closes them.
+func closeReplicas(lp *glog.Logger, replicas []*replica) {
+	for _, r := range replicas {
+		atomic.StoreInt32(&r.healthy, 0)
+		if err := r.db.Close(); err != nil {
+			lp.Error().Msg("gorm: close mysql replica error").String("host", r.host).Error("error", err).Fire()
+		}
+	}
+}
+
+func pickInt(v int, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
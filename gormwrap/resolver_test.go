@@ -0,0 +1,140 @@
+package gormwrap
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type resolverItem struct {
+	ID   int64
+	Name string
+}
+
+// newTestReplica returns an in-memory sqlite database that contains an item with the name.
+func newTestReplica(t *testing.T, name string) *replica {
+	sqlDB, err := sql.Open(sqlite.DriverName, SQLiteMemory)
+	require.Nil(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	_, err = sqlDB.Exec("CREATE TABLE resolver_items (id integer PRIMARY KEY, name text)")
+	require.Nil(t, err)
+	_, err = sqlDB.Exec("INSERT INTO resolver_items (id, name) VALUES (1, ?)", name)
+	require.Nil(t, err)
+	return &replica{host: name, db: sqlDB, healthy: 1}
+}
+
+// newTestResolver returns a db that the queries are routed to the replicas.
+func newTestResolver(t *testing.T, ctx context.Context, interval time.Duration, replicas ...*replica) *gorm.DB {
+	db := newTestDB(t)
+	require.Nil(t, db.AutoMigrate(&resolverItem{}))
+	require.Nil(t, db.Create(&resolverItem{ID: 1, Name: "primary"}).Error)
+
+	primary, err := db.DB()
+	require.Nil(t, err)
+	newDialector := func(conn *sql.DB) gorm.Dialector { return &sqlite.Dialector{Conn: conn} }
+	require.Nil(t, registerReplicas(ctx, db, primary, replicas, newDialector, interval))
+	return db
+}
+
+func readFrom(t *testing.T, db *gorm.DB) string {
+	var item resolverItem
+	require.Nil(t, db.First(&item, 1).Error)
+	return item.Name
+}
+
+func Test_ResolverReadWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	defer cancel()
+	db := newTestResolver(t, ctx, 0, newTestReplica(t, "replica"))
+
+	// The queries are routed to replica.
+	require.Equal(t, "replica", readFrom(t, db))
+	var name string
+	require.Nil(t, db.Raw("SELECT name FROM resolver_items WHERE id = 1").Row().Scan(&name))
+	require.Equal(t, "replica", name)
+
+	// The writes are routed to primary.
+	require.Nil(t, db.Create(&resolverItem{ID: 2, Name: "written"}).Error)
+	var count int64
+	require.Nil(t, db.WithContext(UsePrimary(ctx)).Model(&resolverItem{}).Where("id = ?", 2).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+	require.Nil(t, db.Model(&resolverItem{}).Where("id = ?", 2).Count(&count).Error)
+	require.Equal(t, int64(0), count)
+
+	// The transactions are executed on primary.
+	require.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		require.Equal(t, "primary", readFrom(t, tx))
+		return nil
+	}))
+}
+
+func Test_ResolverUsePrimary(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	defer cancel()
+	db := newTestResolver(t, ctx, 0, newTestReplica(t, "replica"))
+
+	pdb := db.WithContext(UsePrimary(ctx))
+	require.Equal(t, "primary", readFrom(t, pdb))
+	var name string
+	require.Nil(t, pdb.Raw("SELECT name FROM resolver_items WHERE id = 1").Row().Scan(&name))
+	require.Equal(t, "primary", name)
+	require.Nil(t, pdb.Raw("SELECT name FROM resolver_items WHERE id = 1").Scan(&name).Error)
+	require.Equal(t, "primary", name)
+
+	require.Equal(t, "replica", readFrom(t, db.WithContext(ctx)))
+}
+
+func Test_ResolverFallbackToPrimary(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	defer cancel()
+	r1, r2 := newTestReplica(t, "replica1"), newTestReplica(t, "replica2")
+	db := newTestResolver(t, ctx, 0, r1, r2)
+
+	for i := 0; i < 10; i++ {
+		require.NotEqual(t, "primary", readFrom(t, db))
+	}
+
+	atomic.StoreInt32(&r1.healthy, 0)
+	for i := 0; i < 10; i++ {
+		require.Equal(t, "replica2", readFrom(t, db))
+	}
+
+	atomic.StoreInt32(&r2.healthy, 0)
+	require.Equal(t, "primary", readFrom(t, db))
+
+	atomic.StoreInt32(&r1.healthy, 1)
+	require.Equal(t, "replica1", readFrom(t, db))
+}
+
+func Test_ResolverHealthCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	defer cancel()
+	r := newTestReplica(t, "replica")
+	db := newTestResolver(t, ctx, 10*time.Millisecond, r)
+	require.Equal(t, "replica", readFrom(t, db))
+
+	// The replica that fails to ping is skipped.
+	require.Nil(t, r.db.Close())
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&r.healthy) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "primary", readFrom(t, db))
+}
+
+func Test_ResolverCloseReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(newTestContext())
+	r := newTestReplica(t, "replica")
+	db := newTestResolver(t, ctx, 0, r)
+	require.Equal(t, "replica", readFrom(t, db))
+
+	// The replicas are closed when ctx done, the queries are routed to primary.
+	cancel()
+	require.Eventually(t, func() bool { return r.db.Ping() != nil }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "primary", readFrom(t, db))
+}
@@ -0,0 +1,240 @@
+package gormwrap
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+const (
+	callBackVersionBeforeName = "gormwrap:version_before"
+	callBackVersionAfterName  = "gormwrap:version_after"
+	callBackSoftDeleteName    = "gormwrap:soft_delete"
+
+	// The keys in gorm.Statement to mark the statement has been processed.
+	settingVersionKey      = "gormwrap:version"
+	clauseSoftDeleteScoped = "gormwrap:soft_delete_scoped"
+)
+
+type ResourceOption func(o *resourceOptions)
+
+type resourceOptions struct {
+	versionColumn  string
+	updatedColumn  string
+	statusColumn   string
+	deletedStatus  interface{}
+	softDeleteable bool
+}
+
+func applyResourceOptions(options ...ResourceOption) resourceOptions {
+	opts := resourceOptions{
+		versionColumn: "version",
+		updatedColumn: "updated",
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithVersionColumn sets the column of optimistic locking version, empty means disabled. Defaults "version".
+func WithVersionColumn(column string) ResourceOption {
+	return func(o *resourceOptions) {
+		o.versionColumn = column
+	}
+}
+
+// WithUpdatedColumn sets the column of updated time that set by soft delete. Defaults "updated".
+func WithUpdatedColumn(column string) ResourceOption {
+	return func(o *resourceOptions) {
+		o.updatedColumn = column
+	}
+}
+
+// WithSoftDelete enables the soft delete by status column, the deleted status is the value of
+// the deleted resources, eg: WithSoftDelete("status", 3). Disabled by default.
+func WithSoftDelete(statusColumn string, deletedStatus interface{}) ResourceOption {
+	return func(o *resourceOptions) {
+		o.statusColumn = statusColumn
+		o.deletedStatus = deletedStatus
+		o.softDeleteable = true
+	}
+}
+
+// resourcePlugin implements gorm.Plugin to provide optimistic locking and soft delete.
+type resourcePlugin struct {
+	opts resourceOptions
+}
+
+// NewResourcePlugin creates a gorm.Plugin for the models, it only affects the models that have the columns.
+//
+// Optimistic locking: the update of a loaded model (version != 0) is executed with "WHERE version = ?"
+// and increases the version, returns qerror.ResourceConflict if the row has been changed by others.
+//
+// Soft delete: the Delete is converted to update the status to deleted, and the Query, Update and Delete
+// are scoped with "status <> deleted" automatically. Use db.Unscoped() to escape it.
+func NewResourcePlugin(options ...ResourceOption) gorm.Plugin {
+	return &resourcePlugin{opts: applyResourceOptions(options...)}
+}
+
+// Name for Implements gorm.Plugin
+func (pl *resourcePlugin) Name() string {
+	return "ResourcePlugin"
+}
+
+// Initialize for Implements gorm.Plugin
+func (pl *resourcePlugin) Initialize(db *gorm.DB) (err error) {
+	if pl.opts.versionColumn != "" {
+		err = db.Callback().Update().Before("gorm:update").Register(callBackVersionBeforeName, pl.beforeUpdate)
+		if err != nil {
+			return
+		}
+		err = db.Callback().Update().After("gorm:update").Register(callBackVersionAfterName, pl.afterUpdate)
+		if err != nil {
+			return
+		}
+	}
+
+	if pl.opts.softDeleteable {
+		err = db.Callback().Query().Before("gorm:query").Register(callBackSoftDeleteName, pl.scopeNotDeleted)
+		if err != nil {
+			return
+		}
+		err = db.Callback().Row().Before("gorm:row").Register(callBackSoftDeleteName, pl.scopeNotDeleted)
+		if err != nil {
+			return
+		}
+		err = db.Callback().Update().Before("gorm:update").Register(callBackSoftDeleteName, pl.scopeNotDeleted)
+		if err != nil {
+			return
+		}
+		err = db.Callback().Delete().Before("gorm:delete").Register(callBackSoftDeleteName, pl.softDelete)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// beforeUpdate adds the condition of current version and sets the new version.
+func (pl *resourcePlugin) beforeUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.ReflectValue.Kind() != reflect.Struct {
+		return
+	}
+	field := stmt.Schema.LookUpField(pl.opts.versionColumn)
+	if field == nil {
+		return
+	}
+	value, isZero := field.ValueOf(stmt.Context, stmt.ReflectValue)
+	if isZero {
+		// The model isn't loaded from db, eg: db.Model(&T{}).Where(...).Update(...).
+		return
+	}
+	version, ok := toInt64(value)
+	if !ok {
+		_ = db.AddError(fmt.Errorf("gormwrap: unsupported version type %T", value))
+		return
+	}
+
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version},
+	}})
+	stmt.SetColumn(field.DBName, version+1, true)
+	if _, ok := stmt.Dest.(map[string]interface{}); ok && stmt.ReflectValue.CanAddr() {
+		_ = field.Set(stmt.Context, stmt.ReflectValue, version+1)
+	}
+	stmt.Settings.Store(settingVersionKey, version)
+}
+
+// afterUpdate returns qerror.ResourceConflict if the version has been changed.
+func (pl *resourcePlugin) afterUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	version, ok := stmt.Settings.Load(settingVersionKey)
+	if !ok {
+		return
+	}
+	stmt.Settings.Delete(settingVersionKey)
+
+	if db.Error != nil || db.DryRun || db.RowsAffected > 0 {
+		return
+	}
+	// Restores the version of model.
+	if field := stmt.Schema.LookUpField(pl.opts.versionColumn); field != nil && stmt.ReflectValue.CanAddr() {
+		_ = field.Set(stmt.Context, stmt.ReflectValue, version)
+	}
+	_ = db.AddError(qerror.ResourceConflict.Format(stmt.Table))
+}
+
+// scopeNotDeleted adds the condition "status <> deleted".
+func (pl *resourcePlugin) scopeNotDeleted(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Unscoped || stmt.Schema == nil {
+		return
+	}
+	field := stmt.Schema.LookUpField(pl.opts.statusColumn)
+	if field == nil {
+		return
+	}
+	if _, ok := stmt.Clauses[clauseSoftDeleteScoped]; ok {
+		return
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Neq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: pl.opts.deletedStatus},
+	}})
+	stmt.Clauses[clauseSoftDeleteScoped] = clause.Clause{}
+}
+
+// softDelete converts the DELETE to "UPDATE SET status = deleted". It's same as the gorm.DeletedAt.
+func (pl *resourcePlugin) softDelete(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Unscoped || stmt.Schema == nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	field := stmt.Schema.LookUpField(pl.opts.statusColumn)
+	if field == nil {
+		return
+	}
+
+	set := clause.Set{{Column: clause.Column{Name: field.DBName}, Value: pl.opts.deletedStatus}}
+	if updated := stmt.Schema.LookUpField(pl.opts.updatedColumn); updated != nil {
+		var now interface{} = time.Now().Unix()
+		if updated.DataType == schema.Time {
+			now = db.NowFunc()
+		}
+		set = append(set, clause.Assignment{Column: clause.Column{Name: updated.DBName}, Value: now})
+	}
+	stmt.AddClause(set)
+
+	// Adds the primary keys of model as conditions, same as the gorm:delete.
+	_, queryValues := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, stmt.Schema.PrimaryFields)
+	column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+	if len(values) > 0 {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+	}
+	if _, ok := stmt.Clauses["WHERE"]; !db.AllowGlobalUpdate && !ok {
+		_ = db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+
+	pl.scopeNotDeleted(db)
+	stmt.AddClauseIfNotExists(clause.Update{})
+	stmt.Build(db.Callback().Update().Clauses...)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,116 @@
+package gormwrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+const resourceDeleted = 3
+
+type resourceItem struct {
+	ID      int64
+	Name    string
+	Status  int
+	Version int64
+	Updated int64
+}
+
+func newResourceTestDB(t *testing.T) *gorm.DB {
+	db := newTestDB(t)
+	require.Nil(t, db.Use(NewResourcePlugin(WithSoftDelete("status", resourceDeleted))))
+	require.Nil(t, db.AutoMigrate(&resourceItem{}))
+	require.Nil(t, db.Create(&resourceItem{ID: 1, Name: "a", Status: 1, Version: 1}).Error)
+	return db
+}
+
+func Test_ResourcePluginVersion(t *testing.T) {
+	cases := []struct {
+		name     string
+		update   func(db *gorm.DB, item *resourceItem) error
+		conflict bool
+		version  int64
+	}{
+		{
+			name: "save loaded model",
+			update: func(db *gorm.DB, item *resourceItem) error {
+				item.Name = "b"
+				return db.Save(item).Error
+			},
+			version: 2,
+		},
+		{
+			name: "updates loaded model",
+			update: func(db *gorm.DB, item *resourceItem) error {
+				return db.Model(item).Updates(map[string]interface{}{"name": "b"}).Error
+			},
+			version: 2,
+		},
+		{
+			name: "updates stale model",
+			update: func(db *gorm.DB, item *resourceItem) error {
+				require.Nil(t, db.Model(&resourceItem{ID: 1, Version: 1}).Update("name", "c").Error)
+				return db.Model(item).Updates(map[string]interface{}{"name": "b"}).Error
+			},
+			conflict: true,
+			version:  1,
+		},
+		{
+			name: "update without version",
+			update: func(db *gorm.DB, item *resourceItem) error {
+				return db.Model(&resourceItem{}).Where("id = ?", 1).Update("name", "b").Error
+			},
+			version: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := newResourceTestDB(t)
+			var item resourceItem
+			require.Nil(t, db.First(&item, 1).Error)
+
+			err := c.update(db, &item)
+			if c.conflict {
+				qErr, ok := err.(*qerror.Error)
+				require.True(t, ok, err)
+				require.Equal(t, qerror.ResourceConflict.Code(), qErr.Code())
+				require.Equal(t, 409, qErr.Status())
+			} else {
+				require.Nil(t, err)
+			}
+			// The version of model is restored if conflicted.
+			require.Equal(t, c.version, item.Version)
+		})
+	}
+}
+
+func Test_ResourcePluginSoftDelete(t *testing.T) {
+	db := newResourceTestDB(t)
+	require.Nil(t, db.Create(&resourceItem{ID: 2, Name: "b", Status: 1, Version: 1}).Error)
+
+	require.Nil(t, db.Delete(&resourceItem{ID: 1}).Error)
+	require.Equal(t, gorm.ErrMissingWhereClause, db.Delete(&resourceItem{}).Error)
+
+	var items []*resourceItem
+	require.Nil(t, db.Find(&items).Error)
+	require.Len(t, items, 1)
+	require.Equal(t, int64(2), items[0].ID)
+
+	var count int64
+	require.Nil(t, db.Model(&resourceItem{}).Count(&count).Error)
+	require.Equal(t, int64(1), count)
+
+	// The deleted row is kept and can be queried by Unscoped.
+	var deleted resourceItem
+	require.Nil(t, db.Unscoped().First(&deleted, 1).Error)
+	require.Equal(t, resourceDeleted, deleted.Status)
+	require.NotZero(t, deleted.Updated)
+
+	// The deleted row can't be updated.
+	tx := db.Model(&resourceItem{}).Where("id = ?", 1).Update("name", "c")
+	require.Nil(t, tx.Error)
+	require.Equal(t, int64(0), tx.RowsAffected)
+}
@@ -0,0 +1,60 @@
+package gormwrap
+
+import (
+	"context"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// SQLiteMemory is the Path of SQLiteConfig to use an in-memory database.
+const SQLiteMemory = ":memory:"
+
+type SQLiteConfig struct {
+	// The database file path, or SQLiteMemory for an in-memory database.
+	Path        string `json:"path"          yaml:"path"          env:"SQLITE_PATH,default=:memory:" validate:"required"`
+	MaxIdleConn int    `json:"max_idle_conn" yaml:"max_idle_conn" env:"MAX_IDLE_CONN,default=1"       validate:"required"`
+	MaxOpenConn int    `json:"max_open_conn" yaml:"max_open_conn" env:"MAX_OPEN_CONN,default=1"       validate:"required"`
+	// gorm log level: 1 => Silent, 2 => Error, 3 => Warn, 4 => Info
+	LogLevel int `json:"log_level" yaml:"log_level" env:"LOG_LEVEL,default=4" validate:"gte=1,lte=4"`
+	// ConnMaxLifetime unit seconds, 0 means the connections are reused forever.
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME" validate:"gte=0"`
+	// SlowThreshold time 0 indicates disabled
+	SlowThreshold time.Duration `json:"slow_threshold" yaml:"slow_threshold" env:"SLOW_THRESHOLD,default=2s" validate:"gte=0"`
+}
+
+// NewSQLiteConn return a grom.DB by sqlite driver, it's mainly used in unit tests.
+// NOTICE: Must set glog.Logger into the ctx by glow.WithContext
+//
+// Each connection of an in-memory database opens a separate database and the database is
+// dropped when the connection closed, so it's forced to use only one connection that never expires.
+func NewSQLiteConn(ctx context.Context, cfg *SQLiteConfig) (db *gorm.DB, err error) {
+	lp := glog.FromContext(ctx)
+
+	defer func() {
+		if err != nil {
+			lp.Error().Error("gorm: create sqlite connection error", err).Fire()
+			db = nil
+		}
+	}()
+
+	lp.Info().Msg("gorm: connecting to sqlite").String("path", cfg.Path).Fire()
+
+	pool := &poolConfig{
+		LogLevel:        cfg.LogLevel,
+		SlowThreshold:   cfg.SlowThreshold,
+		MaxIdleConn:     cfg.MaxIdleConn,
+		MaxOpenConn:     cfg.MaxOpenConn,
+		ConnMaxLifetime: cfg.ConnMaxLifetime,
+	}
+	if cfg.Path == SQLiteMemory {
+		pool.MaxIdleConn = 1
+		pool.MaxOpenConn = 1
+		pool.ConnMaxLifetime = 0
+	}
+
+	db, _, err = openConn(ctx, sqlite.Open(cfg.Path), pool)
+	return
+}
@@ -0,0 +1,97 @@
+package gormwrap
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/sethvargo/go-envconfig"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTestContext() context.Context {
+	return glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+}
+
+// newTestDB returns an in-memory sqlite db that closed when the test finished.
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := NewSQLiteConn(newTestContext(), &SQLiteConfig{Path: SQLiteMemory, LogLevel: 1})
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		_ = sqlDB.Close()
+	})
+	return db
+}
+
+func Test_SQLiteConfigEnv(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want SQLiteConfig
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"PATH": "/usr/local/bin:/usr/bin"},
+			want: SQLiteConfig{Path: SQLiteMemory, MaxIdleConn: 1, MaxOpenConn: 1, LogLevel: 4, SlowThreshold: time.Second * 2},
+		},
+		{
+			name: "file path",
+			env:  map[string]string{"SQLITE_PATH": "/tmp/test.db", "MAX_OPEN_CONN": "4"},
+			want: SQLiteConfig{Path: "/tmp/test.db", MaxIdleConn: 1, MaxOpenConn: 4, LogLevel: 4, SlowThreshold: time.Second * 2},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var cfg SQLiteConfig
+			require.Nil(t, envconfig.ProcessWith(context.Background(), &cfg, envconfig.MapLookuper(c.env)))
+			require.Equal(t, c.want, cfg)
+		})
+	}
+}
+
+func Test_NewSQLiteConn(t *testing.T) {
+	type item struct {
+		ID   int64
+		Name string
+	}
+
+	t.Run("memory", func(t *testing.T) {
+		db, err := NewSQLiteConn(newTestContext(), &SQLiteConfig{Path: SQLiteMemory, MaxIdleConn: 4, MaxOpenConn: 4, LogLevel: 1})
+		require.Nil(t, err)
+		sqlDB, err := db.DB()
+		require.Nil(t, err)
+		defer func() { _ = sqlDB.Close() }()
+
+		// Only one connection is used, thus the tables are visible to all queries.
+		require.Equal(t, 1, sqlDB.Stats().MaxOpenConnections)
+		require.Nil(t, db.AutoMigrate(&item{}))
+		require.Nil(t, db.Create(&item{ID: 1, Name: "a"}).Error)
+		var got item
+		require.Nil(t, db.First(&got, 1).Error)
+		require.Equal(t, item{ID: 1, Name: "a"}, got)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		cfg := &SQLiteConfig{Path: filepath.Join(t.TempDir(), "test.db"), MaxIdleConn: 1, MaxOpenConn: 2, LogLevel: 1}
+		db, err := NewSQLiteConn(newTestContext(), cfg)
+		require.Nil(t, err)
+		require.Nil(t, db.AutoMigrate(&item{}))
+		require.Nil(t, db.Create(&item{ID: 1, Name: "a"}).Error)
+		sqlDB, _ := db.DB()
+		require.Equal(t, 2, sqlDB.Stats().MaxOpenConnections)
+		require.Nil(t, sqlDB.Close())
+
+		// The data is persisted in the file.
+		db, err = NewSQLiteConn(newTestContext(), cfg)
+		require.Nil(t, err)
+		sqlDB, _ = db.DB()
+		defer func() { _ = sqlDB.Close() }()
+		var got item
+		require.Nil(t, db.First(&got, 1).Error)
+		require.Equal(t, item{ID: 1, Name: "a"}, got)
+	})
+}
@@ -0,0 +1,69 @@
+package grpcwrap
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/DataWorkbench/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/DataWorkbench/common/utils/signer"
+)
+
+var protoDeterministicMarshal = proto.MarshalOptions{Deterministic: true}
+
+// SignatureAuthUnaryServerInterceptor returns a interceptor that verifies the signature in the incoming
+// metadata by the signer of header "authorization", added by WithUnaryServerInterceptors.
+//
+// The request is signed as: method "POST", path the full method name, eg: "/apiserver.Workspace/Describe",
+// no query, and the body is the deterministic protobuf encoding of request. The authenticated account is
+// stored into the context, get it by signer.AccountFromContext.
+func SignatureAuthUnaryServerInterceptor(v *signer.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var body []byte
+		if m, ok := req.(proto.Message); ok {
+			var err error
+			if body, err = protoDeterministicMarshal.Marshal(m); err != nil {
+				return nil, err
+			}
+		}
+		ctx, err := verifySignature(ctx, v, info.FullMethod, body)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// SignatureAuthStreamServerInterceptor returns a interceptor that verifies the signature in the incoming
+// metadata by the signer of header "authorization", added by WithStreamServerInterceptors.
+// Only the method and metadata are signed, the stream messages aren't.
+func SignatureAuthStreamServerInterceptor(v *signer.Verifier) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := verifySignature(ss.Context(), v, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStreamWrap{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func verifySignature(ctx context.Context, v *signer.Verifier, method string, body []byte) (context.Context, error) {
+	headers := make(http.Header)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, values := range md {
+		for _, value := range values {
+			headers.Add(k, value)
+		}
+	}
+
+	req := &signer.Request{Method: http.MethodPost, Path: method, Header: headers, Body: body}
+	account, err := v.Verify(ctx, req)
+	if err != nil {
+		glog.FromContext(ctx).Warn().Msg("grpc request rejected by signature auth").Error("error", err).Fire()
+		return ctx, err
+	}
+	return signer.ContextWithAccount(ctx, account), nil
+}
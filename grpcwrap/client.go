@@ -4,17 +4,16 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/DataWorkbench/glog"
-	"github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/backoff"
-	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
 
 	"github.com/DataWorkbench/common/gtrace"
 )
@@ -33,15 +32,43 @@ func (cc *ClientConn) Close() error {
 	return cc.ClientConn.Close()
 }
 
+// staticScheme is the resolver scheme used for multiple static addresses.
+const staticScheme = "static"
+
+// roundRobinServiceConfig sets the load balancing policy to round_robin.
+const roundRobinServiceConfig = `{"loadBalancingConfig":[{"round_robin":{}}]}`
+
 // ClientConfig used to create an connection to grpc server
 type ClientConfig struct {
-	// Address sample "127.0.0.1:50001" or "127.0.0.1:50001, 127.0.0.1:50002, 127.0.0.1:50003"
+	// Address is the static addresses or a discovery target.
+	// Static addresses sample "127.0.0.1:50001" or "127.0.0.1:50001, 127.0.0.1:50002, 127.0.0.1:50003".
+	// Discovery target sample "etcd:///apiserver", the getcd.RegisterResolver must be called before dial.
 	Address string `json:"address" yaml:"address" env:"ADDRESS" validate:"required"`
+	// TLS enables the TLS or mutual TLS, insecure if not enabled.
+	TLS *TLSConfig `json:"tls" yaml:"tls" env:",prefix=TLS_"`
+	// Keepalive, Backoff of reconnecting and Retry of unary calls, the defaults are used if not set.
+	Keepalive *ClientKeepaliveConfig `json:"keepalive" yaml:"keepalive" env:",prefix=KEEPALIVE_"`
+	Backoff   *BackoffConfig         `json:"backoff"   yaml:"backoff"   env:",prefix=BACKOFF_"`
+	Retry     *RetryConfig           `json:"retry"     yaml:"retry"     env:",prefix=RETRY_"`
+	// The max message size in bytes the client can receive and send, 0 means the grpc defaults (4MB and 2GB).
+	MaxRecvMsgSize int `json:"max_recv_msg_size" yaml:"max_recv_msg_size" env:"MAX_RECV_MSG_SIZE" validate:"gte=0"`
+	MaxSendMsgSize int `json:"max_send_msg_size" yaml:"max_send_msg_size" env:"MAX_SEND_MSG_SIZE" validate:"gte=0"`
+	// ServiceConfig is the default service config JSON, used to set per-method timeout and retry policy, eg:
+	//
+	//	{"methodConfig": [{
+	//		"name": [{"service": "apiserver.Workspace", "method": "Describe"}],
+	//		"timeout": "3s",
+	//		"retryPolicy": {"maxAttempts": 3, "initialBackoff": "0.1s", "maxBackoff": "1s",
+	//			"backoffMultiplier": 2, "retryableStatusCodes": ["UNAVAILABLE"]}
+	//	}]}
+	//
+	// The round_robin load balancing is added if the loadBalancingConfig isn't specified.
+	ServiceConfig string `json:"service_config" yaml:"service_config" env:"SERVICE_CONFIG"`
 }
 
-// NewConn return an new grpc.ClientConn
+// NewConn return an new grpc.ClientConn, the options used to add interceptors and raw grpc.DialOption.
 // NOTICE: Must set glog.loggerT into the ctx by glow.WithContext
-func NewConn(ctx context.Context, cfg *ClientConfig) (conn *ClientConn, err error) {
+func NewConn(ctx context.Context, cfg *ClientConfig, options ...ClientOption) (conn *ClientConn, err error) {
 	lp := glog.FromContext(ctx)
 
 	defer func() {
@@ -52,63 +79,142 @@ func NewConn(ctx context.Context, cfg *ClientConfig) (conn *ClientConn, err erro
 
 	lp.Info().Msg("gRPC client: connecting to server").String("address", cfg.Address).Fire()
 
-	// TODO: support balance
-	// address format "127.0.0.1:50001" or "127.0.0.1:50001, 127.0.0.1:50002, 127.0.0.1:50003"
-	hosts := strings.Split(strings.ReplaceAll(cfg.Address, " ", ""), ",")
-	if len(hosts) == 0 {
-		err = fmt.Errorf("invalid address: %s", cfg.Address)
-		return
-	}
-
 	tracer := gtrace.TracerFromContext(ctx)
+	opts := applyClientOptions(options...)
 
 	var dialOpts []grpc.DialOption
-	// Set and add insecure
+
+	var target string
+	if strings.Contains(cfg.Address, "://") {
+		// Discovery target, eg: "etcd:///apiserver"
+		target = cfg.Address
+	} else {
+		// address format "127.0.0.1:50001" or "127.0.0.1:50001, 127.0.0.1:50002, 127.0.0.1:50003"
+		var hosts []string
+		for _, host := range strings.Split(strings.ReplaceAll(cfg.Address, " ", ""), ",") {
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) == 0 {
+			err = fmt.Errorf("invalid address: %s", cfg.Address)
+			return
+		}
+		if len(hosts) == 1 {
+			target = hosts[0]
+		} else {
+			state := resolver.State{Addresses: make([]resolver.Address, len(hosts))}
+			for i, host := range hosts {
+				state.Addresses[i] = resolver.Address{Addr: host}
+			}
+			r := manual.NewBuilderWithScheme(staticScheme)
+			r.InitialState(state)
+			dialOpts = append(dialOpts, grpc.WithResolvers(r))
+			target = staticScheme + ":///" + hosts[0]
+		}
+	}
+
+	// Balance requests across all resolved addresses, and the per-method policy.
+	var serviceConfig string
+	if serviceConfig, err = buildServiceConfig(cfg.ServiceConfig); err != nil {
+		return
+	}
+	dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(serviceConfig))
+
+	// Set and add transport credentials, insecure if TLS not enabled.
 	//dialOpts = append(dialOpts, grpc.WithInsecure())
-	dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	var creds credentials.TransportCredentials
+	if creds, err = cfg.TLS.clientCredentials(lp); err != nil {
+		return
+	}
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+
+	ka := cfg.Keepalive.withDefaults()
+	bo := cfg.Backoff.withDefaults()
+	retry := cfg.Retry.withDefaults()
+	if err = ka.validate(); err != nil {
+		return
+	}
+	if err = bo.validate(); err != nil {
+		return
+	}
+	if err = retry.validate(); err != nil {
+		return
+	}
+	if cfg.MaxRecvMsgSize < 0 || cfg.MaxSendMsgSize < 0 {
+		err = fmt.Errorf("invalid max message size, recv %d send %d", cfg.MaxRecvMsgSize, cfg.MaxSendMsgSize)
+		return
+	}
+
+	lp.Info().Msg("gRPC client: effective settings").
+		String("address", cfg.Address).
+		String("keepalive.time", ka.Time.String()).
+		String("keepalive.timeout", ka.Timeout.String()).
+		String("backoff.base_delay", bo.BaseDelay.String()).
+		Float64("backoff.multiplier", bo.Multiplier).
+		Float64("backoff.jitter", bo.Jitter).
+		String("backoff.max_delay", bo.MaxDelay.String()).
+		String("backoff.min_connect_timeout", bo.MinConnectTimeout.String()).
+		Int("retry.max_retries", retry.MaxRetries).
+		String("retry.backoff", retry.Backoff.String()).
+		String("retry.per_retry_timeout", retry.PerRetryTimeout.String()).
+		Strings("retry.codes", retry.Codes).
+		Int("max_recv_msg_size", cfg.MaxRecvMsgSize).
+		Int("max_send_msg_size", cfg.MaxSendMsgSize).
+		String("service_config", serviceConfig).
+		Fire()
 
 	// set and add connect params
-	dialOpts = append(dialOpts, grpc.WithConnectParams(grpc.ConnectParams{
-		Backoff: backoff.Config{
-			BaseDelay:  time.Millisecond * 100, // Default was 1s.
-			Multiplier: 1.6,                    // Default
-			Jitter:     0.2,                    // Default
-			MaxDelay:   time.Second * 30,       // Default was 120s.
-		},
-		MinConnectTimeout: time.Second * 5,
-	}))
+	dialOpts = append(dialOpts, bo.dialOption())
 
 	// Setup keepalive params
 	dialOpts = append(dialOpts, grpc.WithKeepaliveParams(
 		keepalive.ClientParameters{
-			Time:                time.Second * 10,
-			Timeout:             time.Second * 5,
+			Time:                ka.Time,
+			Timeout:             ka.Timeout,
 			PermitWithoutStream: true,
 		},
 	))
 
+	// Set and add max message size.
+	var callOpts []grpc.CallOption
+	if cfg.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	if len(callOpts) > 0 {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
 	// Set and add Unary Client Interceptor
-	dialOpts = append(dialOpts, grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor(
-		grpc_retry.WithMax(3),
-		grpc_retry.WithPerRetryTimeout(0),
-		grpc_retry.WithBackoff(grpc_retry.BackoffLinear(time.Second*1)),
-		grpc_retry.WithCodes(codes.Unavailable, codes.Aborted, codes.DeadlineExceeded, codes.ResourceExhausted),
-	)))
-	dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(
+	if interceptor := retry.unaryInterceptor(); interceptor != nil {
+		dialOpts = append(dialOpts, grpc.WithUnaryInterceptor(interceptor))
+	}
+	unaryInterceptors := []grpc.UnaryClientInterceptor{
 		otgrpc.OpenTracingClientInterceptor(tracer, otgrpc.IncludingSpans(traceSpanInclusionFunc)),
 		grpc_prometheus.UnaryClientInterceptor,
 		traceUnaryClientInterceptor(),
-	))
+	}
+	dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(append(unaryInterceptors, opts.unaryInterceptors...)...))
 
 	// Set and add Stream Client Interceptor
-	dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(
+	streamInterceptors := []grpc.StreamClientInterceptor{
 		otgrpc.OpenTracingStreamClientInterceptor(tracer, otgrpc.IncludingSpans(traceSpanInclusionFunc)),
 		grpc_prometheus.StreamClientInterceptor,
 		traceStreamClientInterceptor(),
-	))
+	}
+	dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(append(streamInterceptors, opts.streamInterceptors...)...))
+
+	// The raw options are added last to override the above.
+	dialOpts = append(dialOpts, opts.dialOptions...)
 
 	var c *grpc.ClientConn
-	c, err = grpc.DialContext(ctx, hosts[0], dialOpts...)
+	c, err = grpc.DialContext(ctx, target, dialOpts...)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,60 @@
+package grpcwrap
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval is the interval of run health checks.
+const healthCheckInterval = time.Second * 10
+
+// HealthCheckFunc checks the health of a dependency, eg: redis or mysql.
+// Returns a non-nil error if the dependency is unavailable.
+type HealthCheckFunc func(ctx context.Context) error
+
+type healthCheck struct {
+	name  string
+	check HealthCheckFunc
+}
+
+// AddHealthCheck adds a check that run periodically after the server started.
+// The serving status of the health server will be NOT_SERVING if any check fails.
+// It must be called before ListenAndServe.
+func (s *Server) AddHealthCheck(name string, check HealthCheckFunc) {
+	s.healthChecks = append(s.healthChecks, &healthCheck{name: name, check: check})
+}
+
+func (s *Server) runHealthChecks() {
+	if len(s.healthChecks) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.checkHealth()
+
+		select {
+		case <-ticker.C:
+		case <-s.healthStopC:
+			return
+		}
+	}
+}
+
+func (s *Server) checkHealth() {
+	status := grpc_health_v1.HealthCheckResponse_SERVING
+	for _, hc := range s.healthChecks {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckInterval)
+		err := hc.check(ctx)
+		cancel()
+		if err != nil {
+			s.lp.Error().Msg("gRPC server: health check failed").String("name", hc.name).Error("error", err).Fire()
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	s.health.SetServingStatus("", status)
+}
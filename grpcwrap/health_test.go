@@ -0,0 +1,44 @@
+package grpcwrap
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func Test_AddHealthCheck(t *testing.T) {
+	ctx := newTestContext()
+	s, err := NewServer(ctx, &ServerConfig{Address: "127.0.0.1:0"})
+	require.Nil(t, err)
+
+	var healthy int32 = 1
+	s.AddHealthCheck("noop", func(ctx context.Context) error { return nil })
+	s.AddHealthCheck("redis", func(ctx context.Context) error {
+		if atomic.LoadInt32(&healthy) == 1 {
+			return nil
+		}
+		return errors.New("connection refused")
+	})
+
+	servingStatus := func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := s.health.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		require.Nil(t, err)
+		return resp.Status
+	}
+
+	s.checkHealth()
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, servingStatus())
+
+	// Any failed check makes the server NOT_SERVING.
+	atomic.StoreInt32(&healthy, 0)
+	s.checkHealth()
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, servingStatus())
+
+	atomic.StoreInt32(&healthy, 1)
+	s.checkHealth()
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, servingStatus())
+}
@@ -0,0 +1,64 @@
+package grpcwrap
+
+import (
+	"context"
+
+	"github.com/DataWorkbench/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/limiter"
+)
+
+// LimitKeyFunc returns the key to limit for a gRPC request, eg: workspace id or user id.
+// The req is nil for stream requests. The request will not be limited if the key is empty.
+type LimitKeyFunc func(ctx context.Context, fullMethod string, req interface{}) string
+
+// LimitUnaryServerInterceptor returns a new unary server interceptor that enforce the limiter on requests.
+func LimitUnaryServerInterceptor(l limiter.Limiter, keyFunc LimitKeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := keyFunc(ctx, info.FullMethod, req)
+		if key == "" {
+			return handler(ctx, req)
+		}
+		release, err := l.Acquire(ctx, key)
+		if err != nil {
+			glog.FromContext(ctx).Warn().Msg("grpc request rejected by limiter").String("key", key).Error("error", err).Fire()
+			return nil, limitError(err)
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// LimitStreamServerInterceptor returns a new stream server interceptor that enforce the limiter on requests.
+func LimitStreamServerInterceptor(l limiter.Limiter, keyFunc LimitKeyFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		key := keyFunc(ctx, info.FullMethod, nil)
+		if key == "" {
+			return handler(srv, ss)
+		}
+		release, err := l.Acquire(ctx, key)
+		if err != nil {
+			glog.FromContext(ctx).Warn().Msg("grpc stream rejected by limiter").String("key", key).Error("error", err).Fire()
+			return limitError(err)
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+// limitError converts qerror.TooManyRequests to a status with code ResourceExhausted. The details
+// are kept, so the client can still convert it back by qerror.FromGRPC.
+func limitError(err error) error {
+	e, ok := err.(*qerror.Error)
+	if !ok || e.Code() != qerror.TooManyRequests.Code() {
+		return err
+	}
+	p := e.GRPCStatus().Proto()
+	p.Code = int32(codes.ResourceExhausted)
+	return status.ErrorProto(p)
+}
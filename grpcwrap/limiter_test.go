@@ -0,0 +1,80 @@
+package grpcwrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/DataWorkbench/common/utils/limiter"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func Test_LimitUnaryServerInterceptor(t *testing.T) {
+	ctx := newTestContext()
+	l := limiter.NewLocal(1)
+	interceptor := LimitUnaryServerInterceptor(l, func(ctx context.Context, fullMethod string, req interface{}) string {
+		return req.(string)
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	release, err := l.Acquire(ctx, "w1")
+	require.Nil(t, err)
+
+	reply, err := interceptor(ctx, "w1", info, handler)
+	require.Nil(t, reply)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// The other keys and the requests without key are not limited.
+	reply, err = interceptor(ctx, "w2", info, handler)
+	require.Nil(t, err)
+	require.Equal(t, "ok", reply)
+	_, err = interceptor(ctx, "", info, handler)
+	require.Nil(t, err)
+
+	// The permit is released after the request done.
+	release()
+	_, err = interceptor(ctx, "w1", info, handler)
+	require.Nil(t, err)
+	_, err = interceptor(ctx, "w1", info, handler)
+	require.Nil(t, err)
+}
+
+func Test_LimitStreamServerInterceptor(t *testing.T) {
+	ctx := newTestContext()
+	l := limiter.NewLocal(1)
+	interceptor := LimitStreamServerInterceptor(l, func(ctx context.Context, fullMethod string, req interface{}) string {
+		require.Nil(t, req)
+		return "w1"
+	})
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+	ss := &testServerStream{ctx: ctx}
+
+	var called int
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		called++
+		return nil
+	}
+
+	release, err := l.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	err = interceptor(nil, ss, info, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, 0, called)
+
+	release()
+	require.Nil(t, interceptor(nil, ss, info, handler))
+	require.Equal(t, 1, called)
+}
@@ -14,6 +14,9 @@ var excludeTraceMethod = map[string]bool{
 
 // traceSpanInclusionFunc is used to filter grpc method that don't need to be traced.
 // Is a type of otgrpc.SpanInclusionFunc
+//
+// The otgrpc creates the span before the sampler is asked, so the excluded methods are filtered here
+// to avoid the cost of spans instead of by the gtrace.SamplingRule.
 func traceSpanInclusionFunc(parentSpanCtx opentracing.SpanContext, method string, req, resp interface{}) bool {
 	return !excludeTraceMethod[method]
 }
@@ -0,0 +1,92 @@
+package grpcwrap
+
+import (
+	"google.golang.org/grpc"
+)
+
+// ServerOption used to customize the Server created by NewServer.
+type ServerOption func(o *serverOptions)
+
+type serverOptions struct {
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	grpcOptions        []grpc.ServerOption
+}
+
+func applyServerOptions(options ...ServerOption) serverOptions {
+	opts := serverOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithUnaryServerInterceptors appends the interceptors to the unary chain of server, eg: auth, rate limiting or audit.
+//
+// The chain is executed in order: opentracing => trace id, logger and request validation => panic recovery =>
+// prometheus => the interceptors in order of added => handler. So the interceptors can get the logger and
+// trace id from context, and their panics and rejections are recovered and recorded in metrics.
+func WithUnaryServerInterceptors(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamServerInterceptors appends the interceptors to the stream chain of server.
+// The order is the same as WithUnaryServerInterceptors.
+func WithStreamServerInterceptors(interceptors ...grpc.StreamServerInterceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// WithServerOptions appends the raw grpc.ServerOption, it's an escape hatch for the settings not in ServerConfig.
+// They are applied after the options built from ServerConfig, so they override the same settings.
+func WithServerOptions(options ...grpc.ServerOption) ServerOption {
+	return func(o *serverOptions) {
+		o.grpcOptions = append(o.grpcOptions, options...)
+	}
+}
+
+// ClientOption used to customize the ClientConn created by NewConn.
+type ClientOption func(o *clientOptions)
+
+type clientOptions struct {
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	dialOptions        []grpc.DialOption
+}
+
+func applyClientOptions(options ...ClientOption) clientOptions {
+	opts := clientOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithUnaryClientInterceptors appends the interceptors to the unary chain of client, eg: signing the requests.
+//
+// The chain is executed in order: retry => opentracing => prometheus => trace id injection and validation =>
+// the interceptors in order of added => invoker. So the interceptors are called on each retry attempt.
+func WithUnaryClientInterceptors(interceptors ...grpc.UnaryClientInterceptor) ClientOption {
+	return func(o *clientOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamClientInterceptors appends the interceptors to the stream chain of client.
+// The chain is executed in order: opentracing => prometheus => trace id injection => the interceptors => streamer.
+func WithStreamClientInterceptors(interceptors ...grpc.StreamClientInterceptor) ClientOption {
+	return func(o *clientOptions) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// WithDialOptions appends the raw grpc.DialOption, it's an escape hatch for the settings not in ClientConfig.
+// They are applied after the options built from ClientConfig, so they override the same settings.
+func WithDialOptions(options ...grpc.DialOption) ClientOption {
+	return func(o *clientOptions) {
+		o.dialOptions = append(o.dialOptions, options...)
+	}
+}
@@ -0,0 +1,115 @@
+package grpcwrap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+// orderRecorder records the names of interceptors in order of called.
+type orderRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *orderRecorder) record(name string) {
+	r.mu.Lock()
+	r.names = append(r.names, name)
+	r.mu.Unlock()
+}
+
+func (r *orderRecorder) reset() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := r.names
+	r.names = nil
+	return names
+}
+
+func (r *orderRecorder) server(name string, panicked bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// The trace id is set by the builtin interceptors before.
+		r.record(name + ":" + gtrace.IdFromContext(ctx))
+		if panicked {
+			panic(name)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (r *orderRecorder) client(name string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r.record(name)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func Test_InterceptorOptions(t *testing.T) {
+	ctx := newTestContext()
+	recorder := &orderRecorder{}
+
+	cases := []struct {
+		name          string
+		serverOptions []ServerOption
+		service       string
+		want          []string
+		code          codes.Code
+	}{
+		{
+			name: "in order of added",
+			serverOptions: []ServerOption{
+				WithUnaryServerInterceptors(recorder.server("s1", false)),
+				WithUnaryServerInterceptors(recorder.server("s2", false), recorder.server("s3", false)),
+			},
+			want: []string{"c1", "c2", "s1:tid", "s2:tid", "s3:tid"},
+			code: codes.OK,
+		},
+		{
+			name:          "panic recovered",
+			serverOptions: []ServerOption{WithUnaryServerInterceptors(recorder.server("s1", true), recorder.server("s2", false))},
+			want:          []string{"c1", "c2", "s1:tid"},
+			code:          codes.Internal,
+		},
+		{
+			name: "raw options override config",
+			serverOptions: []ServerOption{
+				WithUnaryServerInterceptors(recorder.server("s1", false)),
+				WithServerOptions(grpc.MaxRecvMsgSize(1)),
+			},
+			service: "too large",
+			want:    []string{"c1", "c2"},
+			code:    codes.ResourceExhausted,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := NewServer(ctx, &ServerConfig{Address: "127.0.0.1:0", MaxRecvMsgSize: 1024}, c.serverOptions...)
+			require.Nil(t, err)
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.Nil(t, err)
+			go func() { _ = s.gRPC.Serve(lis) }()
+			defer s.GracefulStop()
+
+			conn, err := NewConn(ctx, &ClientConfig{Address: lis.Addr().String(), Retry: &RetryConfig{}},
+				WithUnaryClientInterceptors(recorder.client("c1")),
+				WithUnaryClientInterceptors(recorder.client("c2")),
+			)
+			require.Nil(t, err)
+			defer func() { _ = conn.Close() }()
+
+			recorder.reset()
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(gtrace.ContextWithId(ctx, "tid"), &grpc_health_v1.HealthCheckRequest{Service: c.service})
+			require.Equal(t, c.code, status.Code(err), err)
+			require.Equal(t, c.want, recorder.reset())
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package grpcwrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/sethvargo/go-envconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+)
+
+// infinity is used to avoid connection accidentally closed.
+const infinity = time.Duration(math.MaxInt64)
+
+// applyDefaults sets the defaults in env tags to the zero fields of v.
+func applyDefaults(v interface{}) {
+	// Only the defaults are applied because of the lookuper is empty.
+	if err := envconfig.ProcessWith(context.Background(), v, envconfig.MapLookuper(nil)); err != nil {
+		panic(err)
+	}
+}
+
+// ServerKeepaliveConfig is the keepalive policy of grpc server, the defaults in env tags are used if nil.
+type ServerKeepaliveConfig struct {
+	// MinPingInterval is the minimum interval that client can send keepalive pings,
+	// the connection will be closed if the client pings too frequently. Defaults 5s.
+	MinPingInterval time.Duration `json:"min_ping_interval" yaml:"min_ping_interval" env:"MIN_PING_INTERVAL,default=5s" validate:"gte=0"`
+	// MaxConnectionIdle is the duration after which an idle connection will be closed. Defaults 30s.
+	MaxConnectionIdle time.Duration `json:"max_connection_idle" yaml:"max_connection_idle" env:"MAX_CONNECTION_IDLE,default=30s" validate:"gte=0"`
+	// MaxConnectionAge is the maximum duration a connection may exist before it will be closed.
+	// 0 means infinity, set it to rebalance the long-lived connections after scale out. Defaults 0.
+	MaxConnectionAge time.Duration `json:"max_connection_age" yaml:"max_connection_age" env:"MAX_CONNECTION_AGE" validate:"gte=0"`
+	// MaxConnectionAgeGrace is the time for pending RPCs to complete after MaxConnectionAge.
+	// 0 means infinity. Defaults 0.
+	MaxConnectionAgeGrace time.Duration `json:"max_connection_age_grace" yaml:"max_connection_age_grace" env:"MAX_CONNECTION_AGE_GRACE" validate:"gte=0"`
+	// Time is the interval to ping the client if no activity. Defaults 10s.
+	Time time.Duration `json:"time" yaml:"time" env:"TIME,default=10s" validate:"gte=0"`
+	// Timeout is the duration to wait for the ping ack before closing the connection. Defaults 5s.
+	Timeout time.Duration `json:"timeout" yaml:"timeout" env:"TIMEOUT,default=5s" validate:"gte=0"`
+}
+
+func (cfg *ServerKeepaliveConfig) withDefaults() ServerKeepaliveConfig {
+	c := ServerKeepaliveConfig{}
+	if cfg != nil {
+		c = *cfg
+	} else {
+		applyDefaults(&c)
+	}
+	if c.MaxConnectionAge == 0 {
+		c.MaxConnectionAge = infinity
+	}
+	if c.MaxConnectionAgeGrace == 0 {
+		c.MaxConnectionAgeGrace = infinity
+	}
+	return c
+}
+
+func (cfg *ServerKeepaliveConfig) validate() error {
+	if cfg.MinPingInterval < 0 || cfg.MaxConnectionIdle < 0 || cfg.MaxConnectionAge < 0 ||
+		cfg.MaxConnectionAgeGrace < 0 || cfg.Time < 0 || cfg.Timeout < 0 {
+		return fmt.Errorf("keepalive: durations can not be negative")
+	}
+	return nil
+}
+
+func (cfg *ServerKeepaliveConfig) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.MinPingInterval,
+			PermitWithoutStream: true, // The client sends pings without active streams.
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     cfg.MaxConnectionIdle,
+			MaxConnectionAge:      cfg.MaxConnectionAge,
+			MaxConnectionAgeGrace: cfg.MaxConnectionAgeGrace,
+			Time:                  cfg.Time,
+			Timeout:               cfg.Timeout,
+		}),
+	}
+}
+
+// ClientKeepaliveConfig is the keepalive policy of grpc client, the defaults in env tags are used if nil.
+// NOTICE: The Time must not be less than the MinPingInterval of server.
+type ClientKeepaliveConfig struct {
+	// Time is the interval to ping the server if no activity. Defaults 10s.
+	Time time.Duration `json:"time" yaml:"time" env:"TIME,default=10s" validate:"gte=0"`
+	// Timeout is the duration to wait for the ping ack before closing the connection. Defaults 5s.
+	Timeout time.Duration `json:"timeout" yaml:"timeout" env:"TIMEOUT,default=5s" validate:"gte=0"`
+}
+
+func (cfg *ClientKeepaliveConfig) withDefaults() ClientKeepaliveConfig {
+	c := ClientKeepaliveConfig{}
+	if cfg != nil {
+		c = *cfg
+	} else {
+		applyDefaults(&c)
+	}
+	return c
+}
+
+func (cfg *ClientKeepaliveConfig) validate() error {
+	if cfg.Time < 0 || cfg.Timeout < 0 {
+		return fmt.Errorf("keepalive: durations can not be negative")
+	}
+	return nil
+}
+
+// BackoffConfig is the exponential backoff of reconnecting, the defaults in env tags are used if nil.
+type BackoffConfig struct {
+	// BaseDelay is the delay of the first retry. Defaults 100ms.
+	BaseDelay time.Duration `json:"base_delay" yaml:"base_delay" env:"BASE_DELAY,default=100ms" validate:"gte=0"`
+	// Multiplier is the factor to multiply the delay after each failed retry. Defaults 1.6.
+	Multiplier float64 `json:"multiplier" yaml:"multiplier" env:"MULTIPLIER,default=1.6" validate:"gte=0"`
+	// Jitter is the factor to randomize the delay. Defaults 0.2.
+	Jitter float64 `json:"jitter" yaml:"jitter" env:"JITTER,default=0.2" validate:"gte=0,lte=1"`
+	// MaxDelay is the upper bound of delay. Defaults 30s.
+	MaxDelay time.Duration `json:"max_delay" yaml:"max_delay" env:"MAX_DELAY,default=30s" validate:"gte=0"`
+	// MinConnectTimeout is the minimum time to wait for a connection to complete. Defaults 5s.
+	MinConnectTimeout time.Duration `json:"min_connect_timeout" yaml:"min_connect_timeout" env:"MIN_CONNECT_TIMEOUT,default=5s" validate:"gte=0"`
+}
+
+func (cfg *BackoffConfig) withDefaults() BackoffConfig {
+	c := BackoffConfig{}
+	if cfg != nil {
+		c = *cfg
+	} else {
+		applyDefaults(&c)
+	}
+	return c
+}
+
+func (cfg *BackoffConfig) validate() error {
+	if cfg.BaseDelay < 0 || cfg.MaxDelay < 0 || cfg.MinConnectTimeout < 0 {
+		return fmt.Errorf("backoff: durations can not be negative")
+	}
+	if cfg.Multiplier < 1 {
+		return fmt.Errorf("backoff: multiplier must be >= 1, got %v", cfg.Multiplier)
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		return fmt.Errorf("backoff: jitter must be in [0, 1], got %v", cfg.Jitter)
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		return fmt.Errorf("backoff: max_delay %s is less than base_delay %s", cfg.MaxDelay, cfg.BaseDelay)
+	}
+	return nil
+}
+
+func (cfg *BackoffConfig) dialOption() grpc.DialOption {
+	return grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff: backoff.Config{
+			BaseDelay:  cfg.BaseDelay,
+			Multiplier: cfg.Multiplier,
+			Jitter:     cfg.Jitter,
+			MaxDelay:   cfg.MaxDelay,
+		},
+		MinConnectTimeout: cfg.MinConnectTimeout,
+	})
+}
+
+// RetryConfig is the client-side retry for all unary calls, the defaults in env tags are used if nil.
+//
+// The per-method retry policy can be set by ClientConfig.ServiceConfig, set MaxRetries to 0 to
+// disable this retry in order to avoid retrying twice.
+type RetryConfig struct {
+	// MaxRetries is the max number of retries, 0 or -1 means disabled. Defaults 3.
+	MaxRetries int `json:"max_retries" yaml:"max_retries" env:"MAX_RETRIES,default=3" validate:"gte=-1"`
+	// Backoff is the linear interval between retries. Defaults 1s.
+	Backoff time.Duration `json:"backoff" yaml:"backoff" env:"BACKOFF,default=1s" validate:"gte=0"`
+	// PerRetryTimeout is the timeout of each attempt, 0 means use the deadline of call.
+	PerRetryTimeout time.Duration `json:"per_retry_timeout" yaml:"per_retry_timeout" env:"PER_RETRY_TIMEOUT" validate:"gte=0"`
+	// Codes are the status codes to retry, in the names of gRPC spec.
+	// Defaults "UNAVAILABLE,ABORTED,DEADLINE_EXCEEDED,RESOURCE_EXHAUSTED".
+	Codes []string `json:"codes" yaml:"codes" env:"CODES,default=UNAVAILABLE,ABORTED,DEADLINE_EXCEEDED,RESOURCE_EXHAUSTED"`
+}
+
+func (cfg *RetryConfig) withDefaults() RetryConfig {
+	c := RetryConfig{}
+	if cfg != nil {
+		c = *cfg
+	} else {
+		applyDefaults(&c)
+	}
+	return c
+}
+
+func (cfg *RetryConfig) validate() error {
+	if cfg.MaxRetries < -1 {
+		return fmt.Errorf("retry: invalid max_retries %d", cfg.MaxRetries)
+	}
+	if cfg.Backoff < 0 || cfg.PerRetryTimeout < 0 {
+		return fmt.Errorf("retry: durations can not be negative")
+	}
+	_, err := cfg.codes()
+	return err
+}
+
+func (cfg *RetryConfig) codes() ([]codes.Code, error) {
+	cs := make([]codes.Code, len(cfg.Codes))
+	for i, name := range cfg.Codes {
+		if err := cs[i].UnmarshalJSON([]byte(`"` + strings.ToUpper(strings.TrimSpace(name)) + `"`)); err != nil {
+			return nil, fmt.Errorf("retry: invalid code %q", name)
+		}
+	}
+	return cs, nil
+}
+
+// unaryInterceptor returns nil if retry disabled.
+func (cfg *RetryConfig) unaryInterceptor() grpc.UnaryClientInterceptor {
+	if cfg.MaxRetries <= 0 {
+		return nil
+	}
+	cs, _ := cfg.codes()
+	return grpc_retry.UnaryClientInterceptor(
+		grpc_retry.WithMax(uint(cfg.MaxRetries)),
+		grpc_retry.WithPerRetryTimeout(cfg.PerRetryTimeout),
+		grpc_retry.WithBackoff(grpc_retry.BackoffLinear(cfg.Backoff)),
+		grpc_retry.WithCodes(cs...),
+	)
+}
+
+// buildServiceConfig validates the service config JSON and sets the round_robin load balancing
+// if the loadBalancingConfig isn't specified.
+func buildServiceConfig(serviceConfig string) (string, error) {
+	if strings.TrimSpace(serviceConfig) == "" {
+		return roundRobinServiceConfig, nil
+	}
+	var sc map[string]interface{}
+	if err := json.Unmarshal([]byte(serviceConfig), &sc); err != nil {
+		return "", fmt.Errorf("invalid service config: %w", err)
+	}
+	if _, ok := sc["loadBalancingConfig"]; !ok {
+		if _, ok = sc["loadBalancingPolicy"]; !ok {
+			sc["loadBalancingConfig"] = []interface{}{map[string]interface{}{"round_robin": map[string]interface{}{}}}
+		}
+	}
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// durationString formats the duration for logging, the infinity shows as "infinity".
+func durationString(d time.Duration) string {
+	if d == infinity {
+		return "infinity"
+	}
+	return d.String()
+}
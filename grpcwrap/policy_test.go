@@ -0,0 +1,115 @@
+package grpcwrap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func Test_PolicyDefaults(t *testing.T) {
+	var serverKa *ServerKeepaliveConfig
+	require.Equal(t, ServerKeepaliveConfig{
+		MinPingInterval:       time.Second * 5,
+		MaxConnectionIdle:     time.Second * 30,
+		MaxConnectionAge:      infinity,
+		MaxConnectionAgeGrace: infinity,
+		Time:                  time.Second * 10,
+		Timeout:               time.Second * 5,
+	}, serverKa.withDefaults())
+
+	var clientKa *ClientKeepaliveConfig
+	require.Equal(t, ClientKeepaliveConfig{Time: time.Second * 10, Timeout: time.Second * 5}, clientKa.withDefaults())
+
+	var bo *BackoffConfig
+	require.Equal(t, BackoffConfig{
+		BaseDelay:         time.Millisecond * 100,
+		Multiplier:        1.6,
+		Jitter:            0.2,
+		MaxDelay:          time.Second * 30,
+		MinConnectTimeout: time.Second * 5,
+	}, bo.withDefaults())
+
+	var retry *RetryConfig
+	require.Equal(t, RetryConfig{
+		MaxRetries: 3,
+		Backoff:    time.Second,
+		Codes:      []string{"UNAVAILABLE", "ABORTED", "DEADLINE_EXCEEDED", "RESOURCE_EXHAUSTED"},
+	}, retry.withDefaults())
+}
+
+func Test_PolicyExplicitZero(t *testing.T) {
+	// The zero values set explicitly are kept instead of replaced by the defaults.
+	bo := (&BackoffConfig{Multiplier: 1.6, MaxDelay: time.Second}).withDefaults()
+	require.Equal(t, time.Duration(0), bo.BaseDelay)
+	require.Equal(t, float64(0), bo.Jitter)
+	require.Nil(t, bo.validate())
+
+	retry := (&RetryConfig{}).withDefaults()
+	require.Nil(t, retry.validate())
+	require.Nil(t, retry.unaryInterceptor())
+
+	ka := (&ServerKeepaliveConfig{}).withDefaults()
+	require.Equal(t, infinity, ka.MaxConnectionAge)
+	require.Equal(t, infinity, ka.MaxConnectionAgeGrace)
+	require.Equal(t, time.Duration(0), ka.MaxConnectionIdle)
+}
+
+func Test_PolicyValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy interface{ validate() error }
+		err    bool
+	}{
+		{name: "server keepalive", policy: &ServerKeepaliveConfig{Time: time.Second}},
+		{name: "server keepalive negative", policy: &ServerKeepaliveConfig{Timeout: -time.Second}, err: true},
+		{name: "client keepalive negative", policy: &ClientKeepaliveConfig{Time: -time.Second}, err: true},
+		{name: "backoff", policy: &BackoffConfig{BaseDelay: time.Second, Multiplier: 1, MaxDelay: time.Second}},
+		{name: "backoff multiplier", policy: &BackoffConfig{Multiplier: 0.5, MaxDelay: time.Second}, err: true},
+		{name: "backoff jitter", policy: &BackoffConfig{Multiplier: 1.6, Jitter: 1.5, MaxDelay: time.Second}, err: true},
+		{name: "backoff max delay", policy: &BackoffConfig{BaseDelay: time.Second, Multiplier: 1.6, MaxDelay: time.Millisecond}, err: true},
+		{name: "retry codes", policy: &RetryConfig{MaxRetries: 1, Codes: []string{"unavailable", " ABORTED"}}},
+		{name: "retry invalid code", policy: &RetryConfig{MaxRetries: 1, Codes: []string{"NOT_A_CODE"}}, err: true},
+		{name: "retry invalid max", policy: &RetryConfig{MaxRetries: -2}, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.policy.validate()
+			if c.err {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+		})
+	}
+
+	cs, err := (&RetryConfig{Codes: []string{"unavailable", " ABORTED"}}).codes()
+	require.Nil(t, err)
+	require.Equal(t, []codes.Code{codes.Unavailable, codes.Aborted}, cs)
+}
+
+func Test_BuildServiceConfig(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		want   string
+		err    bool
+	}{
+		{name: "empty", config: " ", want: roundRobinServiceConfig},
+		{name: "add round robin", config: `{"methodConfig":[]}`, want: `{"loadBalancingConfig":[{"round_robin":{}}],"methodConfig":[]}`},
+		{name: "keep policy", config: `{"loadBalancingPolicy":"pick_first"}`, want: `{"loadBalancingPolicy":"pick_first"}`},
+		{name: "invalid", config: `{`, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sc, err := buildServiceConfig(c.config)
+			if c.err {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			require.JSONEq(t, c.want, sc)
+		})
+	}
+}
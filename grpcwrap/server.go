@@ -2,10 +2,10 @@ package grpcwrap
 
 import (
 	"context"
-	"math"
+	"fmt"
 	"net"
 	"reflect"
-	"time"
+	"sync"
 
 	"github.com/DataWorkbench/glog"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
@@ -15,8 +15,8 @@ import (
 	"google.golang.org/grpc/reflection"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/keepalive"
 
+	"github.com/DataWorkbench/common/getcd"
 	"github.com/DataWorkbench/common/gtrace"
 )
 
@@ -29,6 +29,16 @@ type GServer = grpc.Server
 type ServerConfig struct {
 	// Listening address of the grpc server.
 	Address string `json:"address" yaml:"address" env:"ADDRESS" validate:"required"`
+	// The address registered to service discovery. Defaults to the listening address,
+	// and the first non-loopback IP is used if the listening host is unspecified.
+	AdvertiseAddress string `json:"advertise_address" yaml:"advertise_address" env:"ADVERTISE_ADDRESS"`
+	// TLS enables the TLS or mutual TLS, use PeerIdentityFromContext to get the client identity in handler.
+	TLS *TLSConfig `json:"tls" yaml:"tls" env:",prefix=TLS_"`
+	// Keepalive policy, the defaults are used if not set.
+	Keepalive *ServerKeepaliveConfig `json:"keepalive" yaml:"keepalive" env:",prefix=KEEPALIVE_"`
+	// The max message size in bytes the server can receive and send, 0 means the grpc defaults (4MB and 2GB).
+	MaxRecvMsgSize int `json:"max_recv_msg_size" yaml:"max_recv_msg_size" env:"MAX_RECV_MSG_SIZE" validate:"gte=0"`
+	MaxSendMsgSize int `json:"max_send_msg_size" yaml:"max_send_msg_size" env:"MAX_SEND_MSG_SIZE" validate:"gte=0"`
 }
 
 // Server is an wrapper for gRPC server.
@@ -36,11 +46,26 @@ type Server struct {
 	lp   *glog.Logger // the parent logger
 	cfg  *ServerConfig
 	gRPC *grpc.Server
+
+	// for service discovery.
+	etcdCli *getcd.Client
+	service string
+
+	// mu protects the registration and stopped that accessed by ListenAndServe and GracefulStop.
+	mu           sync.Mutex
+	registration *getcd.Registration
+	stopped      bool
+
+	// for health check.
+	health         *health.Server
+	healthChecks   []*healthCheck
+	healthStopC    chan struct{}
+	healthStopOnce sync.Once
 }
 
-// NewServer return a new Server
+// NewServer return a new Server, the options used to add interceptors and raw grpc.ServerOption.
 // NOTICE: Must set glog.loggerT into the ctx by glow.WithContext
-func NewServer(ctx context.Context, cfg *ServerConfig) (s *Server, err error) {
+func NewServer(ctx context.Context, cfg *ServerConfig, options ...ServerOption) (s *Server, err error) {
 	lp := glog.FromContext(ctx)
 
 	defer func() {
@@ -50,53 +75,81 @@ func NewServer(ctx context.Context, cfg *ServerConfig) (s *Server, err error) {
 	}()
 
 	tracer := gtrace.TracerFromContext(ctx)
+	opts := applyServerOptions(options...)
 
 	var srvOpts []grpc.ServerOption
 
-	// Set and add keepalive enforcement policy
-	// TODO: set keepalive parameters by config
-	srvOpts = append(srvOpts, grpc.KeepaliveEnforcementPolicy(
-		keepalive.EnforcementPolicy{
-			MinTime:             time.Second * 5,
-			PermitWithoutStream: true,
-		}))
-
-	// Set and add keepalive server parameters
-	// TODO: set keepalive parameters by config
-	srvOpts = append(srvOpts, grpc.KeepaliveParams(
-		keepalive.ServerParameters{
-			MaxConnectionIdle:     time.Second * 30,
-			MaxConnectionAge:      time.Duration(math.MaxInt64), // Sets to infinity to avoid connection accidentally closed.
-			MaxConnectionAgeGrace: time.Duration(math.MaxInt64), // Sets to infinity to avoid connection accidentally closed.
-			Time:                  time.Second * 10,
-			Timeout:               time.Second * 5,
-		}))
+	// Set and add transport credentials
+	creds, err := cfg.TLS.serverCredentials(lp)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		lp.Info().Msg("gRPC server: TLS enabled").Bool("require_client_cert", cfg.TLS.RequireClientCert).Fire()
+		srvOpts = append(srvOpts, grpc.Creds(creds))
+	}
+
+	// Set and add keepalive enforcement policy and server parameters.
+	ka := cfg.Keepalive.withDefaults()
+	if err = ka.validate(); err != nil {
+		return nil, err
+	}
+	srvOpts = append(srvOpts, ka.serverOptions()...)
+
+	// Set and add max message size.
+	if cfg.MaxRecvMsgSize < 0 || cfg.MaxSendMsgSize < 0 {
+		return nil, fmt.Errorf("invalid max message size, recv %d send %d", cfg.MaxRecvMsgSize, cfg.MaxSendMsgSize)
+	}
+	if cfg.MaxRecvMsgSize > 0 {
+		srvOpts = append(srvOpts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		srvOpts = append(srvOpts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+
+	lp.Info().Msg("gRPC server: effective settings").
+		String("keepalive.min_ping_interval", ka.MinPingInterval.String()).
+		String("keepalive.max_connection_idle", ka.MaxConnectionIdle.String()).
+		String("keepalive.max_connection_age", durationString(ka.MaxConnectionAge)).
+		String("keepalive.max_connection_age_grace", durationString(ka.MaxConnectionAgeGrace)).
+		String("keepalive.time", ka.Time.String()).
+		String("keepalive.timeout", ka.Timeout.String()).
+		Int("max_recv_msg_size", cfg.MaxRecvMsgSize).
+		Int("max_send_msg_size", cfg.MaxSendMsgSize).
+		Fire()
 
 	// Set and add Unary Server Interceptor
-	srvOpts = append(srvOpts, grpc.ChainUnaryInterceptor(
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
 		otgrpc.OpenTracingServerInterceptor(tracer, otgrpc.IncludingSpans(traceSpanInclusionFunc)),
 		traceUnaryServerInterceptor(lp),
 		recoverUnaryServerInterceptor(),
 		grpc_prometheus.UnaryServerInterceptor,
-	))
+	}
+	srvOpts = append(srvOpts, grpc.ChainUnaryInterceptor(append(unaryInterceptors, opts.unaryInterceptors...)...))
 
 	// Set and add Stream Server Interceptor
-	srvOpts = append(srvOpts, grpc.ChainStreamInterceptor(
+	streamInterceptors := []grpc.StreamServerInterceptor{
 		otgrpc.OpenTracingStreamServerInterceptor(tracer, otgrpc.IncludingSpans(traceSpanInclusionFunc)),
 		traceStreamServerInterceptor(lp),
 		recoverStreamServerInterceptor(),
 		grpc_prometheus.StreamServerInterceptor,
-	))
+	}
+	srvOpts = append(srvOpts, grpc.ChainStreamInterceptor(append(streamInterceptors, opts.streamInterceptors...)...))
+
+	// The raw options are added last to override the above.
+	srvOpts = append(srvOpts, opts.grpcOptions...)
 
 	s = &Server{
-		lp:   lp,
-		cfg:  cfg,
-		gRPC: grpc.NewServer(srvOpts...),
+		lp:          lp,
+		cfg:         cfg,
+		gRPC:        grpc.NewServer(srvOpts...),
+		health:      health.NewServer(),
+		healthStopC: make(chan struct{}),
 	}
 
 	// Register the health server that used by k8s health probe.
 	//grpc_health_v1.RegisterHealthServer(s.gRPC, health.NewServer())
-	s.RegisterService(&grpc_health_v1.Health_ServiceDesc, health.NewServer())
+	s.RegisterService(&grpc_health_v1.Health_ServiceDesc, s.health)
 
 	return s, nil
 }
@@ -114,6 +167,13 @@ func (s *Server) RegisterService(sd *grpc.ServiceDesc, impl interface{}) {
 	s.gRPC.RegisterService(sd, impl)
 }
 
+// RegisterDiscovery sets the server to be registered into etcd with the service name once it is listening.
+// The endpoint will be deregistered on GracefulStop. Clients can dial it by "etcd:///<service>".
+func (s *Server) RegisterDiscovery(cli *getcd.Client, service string) {
+	s.etcdCli = cli
+	s.service = service
+}
+
 // ListenAndServe creates an net listener by config and called  grpc.Server.Serve
 func (s *Server) ListenAndServe() error {
 	s.lp.Info().String("gRPC server: start listening", s.cfg.Address).Fire()
@@ -127,6 +187,39 @@ func (s *Server) ListenAndServe() error {
 	reflection.Register(s.gRPC)
 	grpc_prometheus.Register(s.gRPC)
 
+	if s.etcdCli != nil {
+		address, err := advertiseAddress(s.cfg.AdvertiseAddress, lis.Addr())
+		if err != nil {
+			s.lp.Error().Error("gRPC server: get advertise address error", err).Fire()
+			_ = lis.Close()
+			return err
+		}
+		ctx := glog.WithContext(context.Background(), s.lp)
+		registration, err := getcd.Register(ctx, s.etcdCli, s.service, address, getcd.DefaultRegistryTTL)
+		if err != nil {
+			s.lp.Error().Error("gRPC server: register to service discovery error", err).Fire()
+			_ = lis.Close()
+			return err
+		}
+
+		s.mu.Lock()
+		stopped := s.stopped
+		if !stopped {
+			s.registration = registration
+		}
+		s.mu.Unlock()
+		if stopped {
+			// GracefulStop was called during registering.
+			if err = registration.Deregister(context.Background()); err != nil {
+				s.lp.Error().Error("gRPC server: deregister from service discovery error", err).Fire()
+			}
+			_ = lis.Close()
+			return grpc.ErrServerStopped
+		}
+	}
+
+	go s.runHealthChecks()
+
 	err = s.gRPC.Serve(lis)
 	if err != nil {
 		s.lp.Error().Error("gRPC server: serve error", err).Fire()
@@ -139,7 +232,48 @@ func (s *Server) GracefulStop() {
 	if s == nil {
 		return
 	}
+
+	s.mu.Lock()
+	s.stopped = true
+	registration := s.registration
+	s.registration = nil
+	s.mu.Unlock()
+
+	if registration != nil {
+		// Deregister first to stop the clients send new requests.
+		if err := registration.Deregister(context.Background()); err != nil {
+			s.lp.Error().Error("gRPC server: deregister from service discovery error", err).Fire()
+		}
+	}
+	// GracefulStop may be called many times, eg: by both the signal handler and defer.
+	s.healthStopOnce.Do(func() { close(s.healthStopC) })
+	s.health.Shutdown()
 	s.lp.Info().Msg("gRPC server: waiting for stop").Fire()
 	s.gRPC.GracefulStop()
 	s.lp.Info().Msg("gRPC server: stopped").Fire()
 }
+
+// advertiseAddress returns the address that other services can connect to.
+func advertiseAddress(advertise string, addr net.Addr) (string, error) {
+	if advertise != "" {
+		return advertise, nil
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return addr.String(), nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return net.JoinHostPort(ipNet.IP.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("no available IP to advertise for address %s", addr.String())
+}
@@ -0,0 +1,54 @@
+package grpcwrap
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+func newTestContext() context.Context {
+	return glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+}
+
+func Test_ServerGracefulStopTwice(t *testing.T) {
+	s, err := NewServer(newTestContext(), &ServerConfig{Address: "127.0.0.1:0"})
+	require.Nil(t, err)
+	s.AddHealthCheck("noop", func(ctx context.Context) error { return nil })
+
+	exitC := make(chan error, 1)
+	go func() { exitC <- s.ListenAndServe() }()
+
+	s.GracefulStop()
+	s.GracefulStop()
+	<-exitC
+}
+
+func Test_HealthCheckNotTraced(t *testing.T) {
+	tracer := mocktracer.New()
+	ctx := gtrace.ContextWithTracer(newTestContext(), tracer)
+
+	s, err := NewServer(ctx, &ServerConfig{Address: "127.0.0.1:0"})
+	require.Nil(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() { _ = s.gRPC.Serve(lis) }()
+	defer s.GracefulStop()
+
+	conn, err := NewConn(ctx, &ClientConfig{Address: lis.Addr().String()})
+	require.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.Nil(t, err)
+	require.Empty(t, tracer.FinishedSpans())
+
+	// The other methods are traced.
+	require.True(t, traceSpanInclusionFunc(nil, "/apiserver.Workspace/Describe", nil, nil))
+}
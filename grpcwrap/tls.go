@@ -0,0 +1,298 @@
+package grpcwrap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// TLSConfig used to enable TLS or mutual TLS for the grpc server and client.
+//
+// The certificate files are checked every ReloadInterval on handshake, and reloaded if any of them
+// has been modified. So the certificates can be rotated without restart.
+type TLSConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" env:"ENABLED"`
+	// The certificate and key. Required by server, optional by client for mutual TLS.
+	CertFile string `json:"cert_file" yaml:"cert_file" env:"CERT_FILE"`
+	KeyFile  string `json:"key_file"  yaml:"key_file"  env:"KEY_FILE"`
+	// The CA certificate to verify the peer.
+	// For the server, it's used to verify the client certificates;
+	// For the client, it's used to verify the server certificate, use the system CA pool if empty.
+	CAFile string `json:"ca_file" yaml:"ca_file" env:"CA_FILE"`
+	// ServerName used by client to verify the hostname of server certificate.
+	// It must be set if the address is a discovery target, eg: "etcd:///apiserver".
+	ServerName string `json:"server_name" yaml:"server_name" env:"SERVER_NAME"`
+	// RequireClientCert controls whether the server requires and verifies the client certificate (mutual TLS).
+	RequireClientCert bool `json:"require_client_cert" yaml:"require_client_cert" env:"REQUIRE_CLIENT_CERT"`
+	// InsecureSkipVerify controls whether the client skips to verify the server certificate, only for testing.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify" env:"INSECURE_SKIP_VERIFY"`
+	// The interval to check whether the certificate files have been modified. 0 means never reload.
+	ReloadInterval time.Duration `json:"reload_interval" yaml:"reload_interval" env:"RELOAD_INTERVAL,default=1m"`
+}
+
+// serverCredentials returns the transport credentials for grpc server, nil if TLS disabled.
+func (cfg *TLSConfig) serverCredentials(lp *glog.Logger) (credentials.TransportCredentials, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("tls: cert_file and key_file are required by server")
+	}
+	if cfg.RequireClientCert && cfg.CAFile == "" {
+		return nil, fmt.Errorf("tls: ca_file is required to verify the client certificate")
+	}
+
+	r, err := newCertReloader(lp, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.NoClientCert
+	if cfg.CAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	if cfg.RequireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Returns a new config for each handshake to use the latest CA pool.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.maybeReload()
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				NextProtos:     []string{"h2"},
+				GetCertificate: r.getCertificate,
+				ClientAuth:     clientAuth,
+				ClientCAs:      r.certPool(),
+			}, nil
+		},
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// clientCredentials returns the transport credentials for grpc client, nil if TLS disabled.
+func (cfg *TLSConfig) clientCredentials(lp *glog.Logger) (credentials.TransportCredentials, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("tls: cert_file and key_file must be set together")
+	}
+
+	r, err := newCertReloader(lp, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.maybeReload()
+			return r.getCertificate(nil)
+		}
+	}
+	if cfg.CAFile != "" && !cfg.InsecureSkipVerify {
+		// The RootCAs can't be changed after the credentials created, so verifies
+		// the server certificate by ourselves with the latest CA pool.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			r.maybeReload()
+			return verifyServerCertificate(cs, r.certPool())
+		}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func verifyServerCertificate(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("tls: server doesn't provide certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// certReloader holds the certificate and CA pool, and reloads them if the files modified.
+type certReloader struct {
+	lp       *glog.Logger
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(lp *glog.Logger, cfg *TLSConfig) (*certReloader, error) {
+	r := &certReloader{
+		lp:       lp,
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+		caFile:   cfg.CAFile,
+		interval: cfg.ReloadInterval,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// load reads the certificate and CA from files.
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		b, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("tls: no valid certificate found in %s", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// maybeReload reloads the files if the interval elapsed and any of them modified.
+// The previous certificates are kept if reload failed.
+func (r *certReloader) maybeReload() {
+	if r.interval <= 0 {
+		return
+	}
+	r.mu.Lock()
+	if time.Since(r.lastCheck) < r.interval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+	modified := false
+	for _, f := range r.files() {
+		if info, err := os.Stat(f); err == nil && !info.ModTime().Equal(r.modTimes[f]) {
+			modified = true
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	if !modified {
+		return
+	}
+	if err := r.load(); err != nil {
+		r.lp.Error().Error("gRPC tls: reload certificates error", err).String("cert_file", r.certFile).String("ca_file", r.caFile).Fire()
+		return
+	}
+	r.lp.Info().Msg("gRPC tls: certificates reloaded").String("cert_file", r.certFile).String("ca_file", r.caFile).Fire()
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		// Sends no certificate to server.
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) certPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// PeerIdentity is the identity of client that extracted from the verified certificate in mutual TLS.
+type PeerIdentity struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	// URIs contains the URI SANs, eg: the SPIFFE ID "spiffe://cluster.local/ns/default/sa/apiserver".
+	URIs        []string
+	Certificate *x509.Certificate
+}
+
+// PeerIdentityFromContext returns the identity of client in the server handler context.
+// Returns false if the connection isn't mutual TLS or the client doesn't provide a verified certificate.
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	// Only trusts the verified certificate.
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := info.State.VerifiedChains[0][0]
+
+	identity := &PeerIdentity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		URIs:         make([]string, len(cert.URIs)),
+		Certificate:  cert,
+	}
+	for i, u := range cert.URIs {
+		identity.URIs[i] = u.String()
+	}
+	return identity, true
+}
@@ -0,0 +1,226 @@
+package grpcwrap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if parent is nil.
+func newTestCert(t *testing.T, serial int64, commonName string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"dataworkbench"}},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer := &testCert{cert: tmpl, key: key}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer.cert, &key.PublicKey, signer.key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+// write writes the certificate and key to "<name>.crt" and "<name>.key" in dir.
+func (c *testCert) write(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.Nil(t, err)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	// Makes sure the modification time is changed on the file systems with low time precision.
+	modTime := time.Now().Add(time.Duration(c.cert.SerialNumber.Int64()) * time.Second)
+	require.Nil(t, os.Chtimes(certFile, modTime, modTime))
+	require.Nil(t, os.Chtimes(keyFile, modTime, modTime))
+	return
+}
+
+// handshake does the TLS handshake over a loopback connection, returns the server certificate seen
+// by client and the context of server handler.
+func handshake(t *testing.T, serverCreds, clientCreds credentials.TransportCredentials) (*x509.Certificate, context.Context, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer func() { _ = ln.Close() }()
+
+	type result struct {
+		info credentials.AuthInfo
+		err  error
+	}
+	serverC := make(chan result, 1)
+	go func() {
+		rawConn, err := ln.Accept()
+		if err != nil {
+			serverC <- result{err: err}
+			return
+		}
+		conn, info, err := serverCreds.ServerHandshake(rawConn)
+		if err != nil {
+			_ = rawConn.Close()
+		} else {
+			_ = conn.Close()
+		}
+		serverC <- result{info: info, err: err}
+	}()
+
+	rawConn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	defer func() { _ = rawConn.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, info, err := clientCreds.ClientHandshake(ctx, "server", rawConn)
+	if err != nil {
+		_ = rawConn.Close()
+		<-serverC
+		return nil, nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	// The client may finish the handshake before the server verifies the client certificate in TLS 1.3.
+	res := <-serverC
+	if res.err != nil {
+		return nil, nil, res.err
+	}
+	serverCert := info.(credentials.TLSInfo).State.PeerCertificates[0]
+	return serverCert, peer.NewContext(context.Background(), &peer.Peer{AuthInfo: res.info}), nil
+}
+
+func Test_TLSMutualAndReload(t *testing.T) {
+	lp := glog.NewDefault().WithLevel(glog.ErrorLevel)
+	dir := t.TempDir()
+
+	ca := newTestCert(t, 1, "ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCertFile, serverKeyFile := newTestCert(t, 2, "server", ca).write(t, dir, "server")
+	clientCertFile, clientKeyFile := newTestCert(t, 3, "client", ca).write(t, dir, "client")
+
+	serverCreds, err := (&TLSConfig{
+		Enabled:           true,
+		CertFile:          serverCertFile,
+		KeyFile:           serverKeyFile,
+		CAFile:            caFile,
+		RequireClientCert: true,
+		ReloadInterval:    time.Millisecond,
+	}).serverCredentials(lp)
+	require.Nil(t, err)
+	newClientCreds := func(withCert bool) credentials.TransportCredentials {
+		cfg := &TLSConfig{Enabled: true, CAFile: caFile, ServerName: "server", ReloadInterval: time.Millisecond}
+		if withCert {
+			cfg.CertFile, cfg.KeyFile = clientCertFile, clientKeyFile
+		}
+		creds, err := cfg.clientCredentials(lp)
+		require.Nil(t, err)
+		return creds
+	}
+	clientCreds := newClientCreds(true)
+
+	serverCert, ctx, err := handshake(t, serverCreds, clientCreds)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), serverCert.SerialNumber.Int64())
+	identity, ok := PeerIdentityFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, "client", identity.CommonName)
+	require.Equal(t, []string{"dataworkbench"}, identity.Organization)
+
+	// The client without certificate is rejected by mutual TLS.
+	_, _, err = handshake(t, serverCreds, newClientCreds(false))
+	require.NotNil(t, err)
+
+	// The rotated server certificate is used without recreating the credentials.
+	newTestCert(t, 4, "server", ca).write(t, dir, "server")
+	time.Sleep(time.Millisecond * 5)
+	serverCert, _, err = handshake(t, serverCreds, clientCreds)
+	require.Nil(t, err)
+	require.Equal(t, int64(4), serverCert.SerialNumber.Int64())
+
+	// The previous certificate is kept if the reload failed.
+	require.Nil(t, ioutil.WriteFile(serverCertFile, []byte("invalid"), 0600))
+	modTime := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(serverCertFile, modTime, modTime))
+	time.Sleep(time.Millisecond * 5)
+	serverCert, _, err = handshake(t, serverCreds, clientCreds)
+	require.Nil(t, err)
+	require.Equal(t, int64(4), serverCert.SerialNumber.Int64())
+
+	// The server signed by an unknown CA is rejected by client.
+	otherCA := newTestCert(t, 5, "other", nil)
+	newTestCert(t, 6, "server", otherCA).write(t, dir, "server")
+	time.Sleep(time.Millisecond * 5)
+	_, _, err = handshake(t, serverCreds, clientCreds)
+	require.NotNil(t, err)
+}
+
+func Test_TLSConfigCredentials(t *testing.T) {
+	lp := glog.NewDefault().WithLevel(glog.ErrorLevel)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, 1, "server", nil).write(t, dir, "server")
+
+	cases := []struct {
+		name     string
+		cfg      *TLSConfig
+		server   bool
+		nilCreds bool
+		err      bool
+	}{
+		{name: "server nil", server: true, nilCreds: true},
+		{name: "server disabled", cfg: &TLSConfig{CertFile: certFile}, server: true, nilCreds: true},
+		{name: "server no cert", cfg: &TLSConfig{Enabled: true}, server: true, err: true},
+		{name: "server mutual no ca", cfg: &TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, RequireClientCert: true}, server: true, err: true},
+		{name: "server file not exist", cfg: &TLSConfig{Enabled: true, CertFile: certFile + ".none", KeyFile: keyFile}, server: true, err: true},
+		{name: "server", cfg: &TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, server: true},
+		{name: "client disabled", cfg: &TLSConfig{}, nilCreds: true},
+		{name: "client cert without key", cfg: &TLSConfig{Enabled: true, CertFile: certFile}, err: true},
+		{name: "client invalid ca", cfg: &TLSConfig{Enabled: true, CAFile: keyFile}, err: true},
+		{name: "client system ca", cfg: &TLSConfig{Enabled: true}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var creds credentials.TransportCredentials
+			var err error
+			if c.server {
+				creds, err = c.cfg.serverCredentials(lp)
+			} else {
+				creds, err = c.cfg.clientCredentials(lp)
+			}
+			if c.err {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, c.nilCreds, creds == nil)
+		})
+	}
+}
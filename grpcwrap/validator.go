@@ -1,11 +1,20 @@
 package grpcwrap
 
 import (
+	"fmt"
+
 	"github.com/DataWorkbench/glog"
 	"github.com/yu31/protoc-plugin/xgo/pkg/protodefaults"
 	"github.com/yu31/protoc-plugin/xgo/pkg/protovalidator"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// skipValidateMethod is the grpc methods that the messages don't implement validator.
+var skipValidateMethod = map[string]bool{
+	fmt.Sprintf("/%s/Check", grpc_health_v1.Health_ServiceDesc.ServiceName): true,
+	fmt.Sprintf("/%s/Watch", grpc_health_v1.Health_ServiceDesc.ServiceName): true,
+}
+
 //// validator defines interface grpc_validator.validator
 //// See https://github.com/grpc-ecosystem/go-grpc-middleware/blob/master/validator/validator.go#L14
 //type validator interface {
@@ -14,7 +23,7 @@ import (
 
 // validateRequestParameters helper for validate the request arguments
 func validateRequestParameters(logger *glog.Logger, method string, req interface{}) error {
-	if excludeTraceMethod[method] {
+	if skipValidateMethod[method] {
 		return nil
 	}
 
@@ -34,7 +43,7 @@ func validateRequestParameters(logger *glog.Logger, method string, req interface
 
 // validateReplyParameters helper for validate the reply arguments
 func validateReplyParameters(logger *glog.Logger, method string, reply interface{}) error {
-	if excludeTraceMethod[method] {
+	if skipValidateMethod[method] {
 		return nil
 	}
 
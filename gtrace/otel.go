@@ -0,0 +1,220 @@
+package gtrace
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/opentracing/opentracing-go"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/DataWorkbench/common/gtrace"
+
+// WithSpanExporter sets the exporter that used instead of the OTLP exporter for backend "otlp",
+// eg: the tracetest.NewInMemoryExporter in tests.
+func WithSpanExporter(exporter sdktrace.SpanExporter) Option {
+	return func(o *options) {
+		o.exporter = exporter
+	}
+}
+
+// WithSyncer exports the spans synchronously when they finished instead of in batches for
+// backend "otlp". It's for tests and debugging only.
+func WithSyncer() Option {
+	return func(o *options) {
+		o.syncer = true
+	}
+}
+
+// OTelTracer is the opentracing.Tracer of backend "otlp", it bridges the OpenTracing API to the
+// OpenTelemetry SDK so that the existing instrumentation continue to work.
+//
+// Both the format opentracing.HTTPHeaders and opentracing.TextMap are supported by any
+// carrier that implements opentracing.TextMapWriter and opentracing.TextMapReader.
+type OTelTracer struct {
+	*otbridge.BridgeTracer
+	provider trace.TracerProvider
+}
+
+var _ opentracing.Tracer = (*OTelTracer)(nil)
+
+func newOTelTracer(cfg *Config, lg *glog.Logger, o *options) (tracer Tracer, closer io.Closer, err error) {
+	exporter := o.exporter
+	if exporter == nil {
+		exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+		}
+		if exporter, err = otlptracegrpc.New(context.Background(), exporterOpts...); err != nil {
+			return
+		}
+	}
+	processor := sdktrace.NewBatchSpanProcessor(exporter)
+	if o.syncer {
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(&errorSpanProcessor{SpanProcessor: processor}),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+		)),
+		sdktrace.WithSampler(&otelSampler{sampler: o.sampler}),
+	)
+
+	bridge, wrapperProvider := otbridge.NewTracerPair(provider.Tracer(instrumentationName))
+	bridge.SetTextMapPropagator(NewPropagator())
+	bridge.SetWarningHandler(func(msg string) {
+		if lg != nil {
+			lg.Warn().RawString("gtrace", strings.TrimSpace(msg)).Fire()
+		}
+	})
+
+	tracer = &OTelTracer{BridgeTracer: bridge, provider: wrapperProvider}
+	closer = &otelCloser{provider: provider}
+	return
+}
+
+// TracerProvider returns the OpenTelemetry TracerProvider that shares the spans with the
+// OpenTracing API, for the instrumentation that uses OpenTelemetry API directly.
+func (t *OTelTracer) TracerProvider() trace.TracerProvider {
+	return t.provider
+}
+
+// Inject for implements opentracing.Tracer.
+func (t *OTelTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	if format != opentracing.HTTPHeaders && format != opentracing.TextMap {
+		return opentracing.ErrUnsupportedFormat
+	}
+	if c, ok := carrier.(opentracing.HTTPHeadersCarrier); ok {
+		return t.BridgeTracer.Inject(sc, opentracing.HTTPHeaders, c)
+	}
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+
+	header := make(http.Header)
+	if err := t.BridgeTracer.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)); err != nil {
+		return err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			writer.Set(strings.ToLower(key), value)
+		}
+	}
+	return nil
+}
+
+// Extract for implements opentracing.Tracer.
+func (t *OTelTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	if format != opentracing.HTTPHeaders && format != opentracing.TextMap {
+		return nil, opentracing.ErrUnsupportedFormat
+	}
+	if c, ok := carrier.(opentracing.HTTPHeadersCarrier); ok {
+		return t.BridgeTracer.Extract(opentracing.HTTPHeaders, c)
+	}
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return nil, opentracing.ErrInvalidCarrier
+	}
+
+	header := make(http.Header)
+	err := reader.ForeachKey(func(key, value string) error {
+		header.Add(key, value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.BridgeTracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+}
+
+// otelSpanContext returns the OpenTelemetry span context of span that created by OTelTracer.
+func otelSpanContext(span opentracing.Span) trace.SpanContext {
+	bridge, ok := span.Tracer().(*otbridge.BridgeTracer)
+	if !ok {
+		return trace.SpanContext{}
+	}
+	// The wrapper tracer puts the OpenTelemetry span into context by the hook.
+	return trace.SpanContextFromContext(bridge.ContextWithSpanHook(context.Background(), span))
+}
+
+// otelSampler implements the sdktrace.Sampler by Sampler.
+type otelSampler struct {
+	sampler *Sampler
+}
+
+// ShouldSample for implements sdktrace.Sampler.
+func (s *otelSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	state := s.sampler.load()
+	parent := trace.SpanContextFromContext(p.ParentContext)
+
+	var sampled bool
+	if parent.IsValid() && (!parent.IsRemote() || !state.ignoreParent) {
+		sampled = parent.IsSampled()
+	} else {
+		sampled = state.sample(p.Name, binary.BigEndian.Uint64(p.TraceID[8:16]))
+	}
+
+	result := sdktrace.SamplingResult{Decision: sdktrace.Drop, Tracestate: parent.TraceState()}
+	if sampled {
+		result.Decision = sdktrace.RecordAndSample
+	} else if state.sampleErrors {
+		// Records the span so that the errorSpanProcessor can export it if it's failed.
+		result.Decision = sdktrace.RecordOnly
+	}
+	return result
+}
+
+// Description for implements sdktrace.Sampler.
+func (s *otelSampler) Description() string {
+	return "gtrace.Sampler"
+}
+
+// errorSpanProcessor exports the failed spans that recorded but not sampled.
+type errorSpanProcessor struct {
+	sdktrace.SpanProcessor
+}
+
+// OnEnd for implements sdktrace.SpanProcessor.
+func (p *errorSpanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if sc := s.SpanContext(); !sc.IsSampled() && s.Status().Code == codes.Error {
+		s = &sampledSpan{ReadOnlySpan: s, sc: sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+// sampledSpan marks the span as sampled.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+	sc trace.SpanContext
+}
+
+// SpanContext for implements sdktrace.ReadOnlySpan.
+func (s *sampledSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+type otelCloser struct {
+	provider *sdktrace.TracerProvider
+}
+
+// Close flushes the pending spans and shutdown the tracer provider.
+func (c *otelCloser) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	return c.provider.Shutdown(ctx)
+}
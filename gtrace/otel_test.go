@@ -0,0 +1,86 @@
+package gtrace
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+func newTestOTelTracer(t *testing.T) (Tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	cfg := &Config{ServiceName: "test", Backend: BackendOTLP}
+	tracer, closer, err := New(cfg, WithSpanExporter(exporter), WithSyncer())
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = closer.Close() })
+	return tracer, exporter
+}
+
+func Test_OTelTracerSpans(t *testing.T) {
+	tracer, exporter := newTestOTelTracer(t)
+
+	parent := tracer.StartSpan("parent")
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	child.SetTag("key", "value")
+	child.Finish()
+	parent.Finish()
+
+	spans := exporter.GetSpans()
+	require.Equal(t, 2, len(spans))
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, "parent", spans[1].Name)
+	require.Equal(t, spans[1].SpanContext.TraceID(), spans[0].SpanContext.TraceID())
+	require.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	require.Equal(t, spans[1].SpanContext.TraceID().String(), TraceIdFromSpan(parent))
+	serviceName, _ := spans[0].Resource.Set().Value(semconv.ServiceNameKey)
+	require.Equal(t, "test", serviceName.AsString())
+}
+
+func Test_OTelTracerPropagation(t *testing.T) {
+	tracer, exporter := newTestOTelTracer(t)
+
+	span := tracer.StartSpan("client")
+	traceId := TraceIdFromSpan(span)
+
+	// HTTP headers carries both W3C and jaeger format.
+	header := make(http.Header)
+	require.Nil(t, tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)))
+	require.Contains(t, header.Get("traceparent"), traceId)
+	require.Contains(t, header.Get(jaegerTraceContextHeader), traceId)
+
+	sc, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	require.Nil(t, err)
+	server := tracer.StartSpan("server", opentracing.ChildOf(sc))
+	require.Equal(t, traceId, TraceIdFromSpan(server))
+	server.Finish()
+
+	// TextMap carrier, eg: kafka headers and gRPC metadata.
+	carrier := opentracing.TextMapCarrier{}
+	require.Nil(t, tracer.Inject(span.Context(), opentracing.TextMap, carrier))
+	require.Contains(t, carrier["traceparent"], traceId)
+	sc, err = tracer.Extract(opentracing.TextMap, carrier)
+	require.Nil(t, err)
+	consumer := tracer.StartSpan("consumer", opentracing.FollowsFrom(sc))
+	require.NotEqual(t, traceId, TraceIdFromSpan(consumer))
+	consumer.Finish()
+	span.Finish()
+
+	// Only the jaeger header from services that use jaeger backend, the trace id without leading zeros.
+	header = http.Header{"X-Trace-Id": {"1a2b3c4d5e6f7081%3A1a2b3c4d5e6f7082%3A0%3A1"}}
+	sc, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+	require.Nil(t, err)
+	server = tracer.StartSpan("server", opentracing.ChildOf(sc))
+	require.Equal(t, "00000000000000001a2b3c4d5e6f7081", TraceIdFromSpan(server))
+	server.Finish()
+
+	spans := exporter.GetSpans()
+	require.Equal(t, 4, len(spans))
+	require.Equal(t, "1a2b3c4d5e6f7082", spans[3].Parent.SpanID().String())
+	require.True(t, spans[3].Parent.IsRemote())
+
+	_, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{}))
+	require.Equal(t, opentracing.ErrSpanContextNotFound, err)
+}
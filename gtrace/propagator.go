@@ -0,0 +1,99 @@
+package gtrace
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// jaegerTraceContextHeader is the header name of trace context that used by jaeger backend.
+const jaegerTraceContextHeader = "x-trace-id"
+
+// NewPropagator returns the propagator that used by the OpenTelemetry tracer.
+//
+// It injects both the W3C "traceparent" and the jaeger format "x-trace-id" headers, so the
+// services that use the jaeger backend can continue the trace. On extract, the "traceparent"
+// takes precedence over the "x-trace-id".
+func NewPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		jaegerPropagator{},
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+}
+
+// jaegerPropagator propagates the span context by header "x-trace-id" in jaeger format
+// "{trace-id}:{span-id}:{parent-span-id}:{flags}".
+type jaegerPropagator struct{}
+
+var _ propagation.TextMapPropagator = jaegerPropagator{}
+
+// Inject for implements propagation.TextMapPropagator.
+func (jaegerPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := 0
+	if sc.IsSampled() {
+		flags = 1
+	}
+	carrier.Set(jaegerTraceContextHeader, fmt.Sprintf("%s:%s:0:%x", sc.TraceID(), sc.SpanID(), flags))
+}
+
+// Extract for implements propagation.TextMapPropagator.
+func (jaegerPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	value := carrier.Get(jaegerTraceContextHeader)
+	if value == "" {
+		return ctx
+	}
+	// The jaeger client escapes the value in http headers.
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		value = unescaped
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 || len(parts[0]) > 32 || len(parts[1]) > 16 {
+		return ctx
+	}
+
+	traceId, err := trace.TraceIDFromHex(leftPadZero(parts[0], 32))
+	if err != nil {
+		return ctx
+	}
+	spanId, err := trace.SpanIDFromHex(leftPadZero(parts[1], 16))
+	if err != nil {
+		return ctx
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return ctx
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.TraceFlags(flags) & trace.FlagsSampled,
+		Remote:     true,
+	})
+	if !sc.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields for implements propagation.TextMapPropagator.
+func (jaegerPropagator) Fields() []string {
+	return []string{jaegerTraceContextHeader}
+}
+
+// leftPadZero pads the hex string with "0", the jaeger client omits the leading zeros.
+func leftPadZero(s string, n int) string {
+	if len(s) >= n {
+		return s
+	}
+	return strings.Repeat("0", n-len(s)) + s
+}
@@ -0,0 +1,204 @@
+package gtrace
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The type of samplers.
+const (
+	// SamplerConst samples all traces if param is not 0, otherwise none.
+	SamplerConst = "const"
+	// SamplerProbabilistic samples the traces by the probability of param between 0 and 1.
+	SamplerProbabilistic = "probabilistic"
+	// SamplerRateLimiting samples at most param traces per second.
+	SamplerRateLimiting = "ratelimiting"
+)
+
+// SamplingConfig for decide whether to sample a trace.
+//
+// The decision of a span is made in order:
+//   - Follows the sampling decision of parent span if present, unless IgnoreParent is true.
+//   - Uses the first rule that matches the operation name.
+//   - Uses the sampler of Type and Param.
+type SamplingConfig struct {
+	// Type is the sampler for the operations that not match any rules. Default is sample all.
+	Type  string  `json:"type"  yaml:"type"  env:"type"  validate:"omitempty,oneof=const probabilistic ratelimiting"`
+	Param float64 `json:"param" yaml:"param" env:"param" validate:"gte=0"`
+
+	// IgnoreParent makes decision for the spans that have a remote parent instead of following it.
+	// It only works with backend "otlp", the jaeger client always follows the remote parent.
+	IgnoreParent bool `json:"ignore_parent" yaml:"ignore_parent" env:"ignore_parent"`
+
+	// SampleErrors samples the trace when a span is tagged with error although it's not sampled
+	// at start. The unsampled spans are recorded until finished so it's more expensive, and the
+	// spans finished before the error are not reported, so the trace may be incomplete.
+	SampleErrors bool `json:"sample_errors" yaml:"sample_errors" env:"sample_errors"`
+
+	// Rules overrides the sampler by operation name, the first matched rule wins.
+	Rules []*SamplingRule `json:"rules" yaml:"rules" env:"-" validate:"dive"`
+}
+
+// SamplingRule is the sampler of operations that name matched.
+type SamplingRule struct {
+	// Operation is the pattern of operation name in syntax of path.Match,
+	// eg: "/grpc.health.v1.Health/*" for all methods of gRPC service.
+	Operation string  `json:"operation" yaml:"operation" validate:"required"`
+	Type      string  `json:"type"      yaml:"type"      validate:"required,oneof=const probabilistic ratelimiting"`
+	Param     float64 `json:"param"     yaml:"param"     validate:"gte=0"`
+}
+
+// Sampler decides whether to sample the traces by the SamplingConfig. It's safe for concurrent use,
+// and the config can be reloaded at runtime by Update.
+type Sampler struct {
+	state atomic.Value // *samplerState
+}
+
+// NewSampler creates a Sampler, nil config samples all traces.
+func NewSampler(cfg *SamplingConfig) (*Sampler, error) {
+	s := &Sampler{}
+	if err := s.Update(cfg); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Update replaces the config of sampler, the current config is kept if the new one is invalid.
+// The state of rate limiters are reset.
+func (s *Sampler) Update(cfg *SamplingConfig) error {
+	if cfg == nil {
+		cfg = &SamplingConfig{}
+	}
+	state := &samplerState{
+		ignoreParent: cfg.IgnoreParent,
+		sampleErrors: cfg.SampleErrors,
+	}
+
+	var err error
+	if cfg.Type == "" {
+		state.root = constDecider(true)
+	} else if state.root, err = newDecider(cfg.Type, cfg.Param); err != nil {
+		return err
+	}
+	for _, rule := range cfg.Rules {
+		if _, err = path.Match(rule.Operation, ""); err != nil {
+			return fmt.Errorf("sampling: invalid operation pattern %q: %w", rule.Operation, err)
+		}
+		var d decider
+		if d, err = newDecider(rule.Type, rule.Param); err != nil {
+			return err
+		}
+		state.rules = append(state.rules, &samplingRule{operation: rule.Operation, decider: d})
+	}
+	s.state.Store(state)
+	return nil
+}
+
+func (s *Sampler) load() *samplerState {
+	return s.state.Load().(*samplerState)
+}
+
+type samplerState struct {
+	ignoreParent bool
+	sampleErrors bool
+	rules        []*samplingRule
+	root         decider
+}
+
+// sample decides whether to sample the trace that starts by the operation.
+func (st *samplerState) sample(operation string, traceId uint64) bool {
+	for _, rule := range st.rules {
+		if matched, _ := path.Match(rule.operation, operation); matched {
+			return rule.decider.sample(traceId)
+		}
+	}
+	return st.root.sample(traceId)
+}
+
+type samplingRule struct {
+	operation string
+	decider   decider
+}
+
+type decider interface {
+	sample(traceId uint64) bool
+}
+
+func newDecider(typ string, param float64) (decider, error) {
+	if param < 0 || math.IsNaN(param) {
+		return nil, fmt.Errorf("sampling: invalid param %v of %s", param, typ)
+	}
+	switch typ {
+	case SamplerConst:
+		return constDecider(param != 0), nil
+	case SamplerProbabilistic:
+		if param > 1 {
+			return nil, fmt.Errorf("sampling: invalid probability %v", param)
+		}
+		return newProbabilisticDecider(param), nil
+	case SamplerRateLimiting:
+		if param == 0 {
+			return constDecider(false), nil
+		}
+		return newRateLimitingDecider(param), nil
+	default:
+		return nil, fmt.Errorf("sampling: unsupported type %q", typ)
+	}
+}
+
+type constDecider bool
+
+func (d constDecider) sample(uint64) bool {
+	return bool(d)
+}
+
+// probabilisticDecider samples by the low 63 bits of trace id as same as the jaeger client,
+// so the decision of a trace is consistent in all services.
+type probabilisticDecider struct {
+	boundary uint64
+}
+
+func newProbabilisticDecider(probability float64) *probabilisticDecider {
+	return &probabilisticDecider{boundary: uint64(float64(math.MaxInt64) * probability)}
+}
+
+func (d *probabilisticDecider) sample(traceId uint64) bool {
+	return traceId&math.MaxInt64 < d.boundary
+}
+
+// rateLimitingDecider is a token bucket that refills the rate of tokens per second,
+// the burst is max(rate, 1).
+type rateLimitingDecider struct {
+	mu      sync.Mutex
+	rate    float64
+	balance float64
+	max     float64
+	last    time.Time
+}
+
+func newRateLimitingDecider(rate float64) *rateLimitingDecider {
+	return &rateLimitingDecider{
+		rate:    rate,
+		balance: math.Max(rate, 1),
+		max:     math.Max(rate, 1),
+		last:    time.Now(),
+	}
+}
+
+func (d *rateLimitingDecider) sample(uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.balance = math.Min(d.max, d.balance+now.Sub(d.last).Seconds()*d.rate)
+	d.last = now
+	if d.balance < 1 {
+		return false
+	}
+	d.balance--
+	return true
+}
@@ -0,0 +1,96 @@
+package gtrace
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_SamplerRules(t *testing.T) {
+	s, err := NewSampler(nil)
+	require.Nil(t, err)
+	require.True(t, s.load().sample("/pb.Service/Method", 1))
+
+	err = s.Update(&SamplingConfig{
+		Type:  SamplerProbabilistic,
+		Param: 0.5,
+		Rules: []*SamplingRule{
+			{Operation: "/pb.Service/*", Type: SamplerConst, Param: 1},
+			{Operation: "/pb.Limited/*", Type: SamplerRateLimiting, Param: 2},
+		},
+	})
+	require.Nil(t, err)
+	require.True(t, s.load().sample("/pb.Service/Method", 1<<62+1))
+	require.True(t, s.load().sample("/pb.Other/Method", 1))
+	require.False(t, s.load().sample("/pb.Other/Method", 1<<62+1))
+	require.True(t, s.load().sample("/pb.Limited/Method", 1))
+	require.True(t, s.load().sample("/pb.Limited/Method", 1))
+	require.False(t, s.load().sample("/pb.Limited/Method", 1))
+
+	// The invalid config is not applied.
+	require.NotNil(t, s.Update(&SamplingConfig{Type: SamplerProbabilistic, Param: 2}))
+	require.NotNil(t, s.Update(&SamplingConfig{Rules: []*SamplingRule{{Operation: "[", Type: SamplerConst}}}))
+	require.True(t, s.load().sample("/pb.Service/Method", 1<<62+1))
+}
+
+func Test_OTelTracerSampling(t *testing.T) {
+	sampler, err := NewSampler(&SamplingConfig{Type: SamplerConst, Param: 0, SampleErrors: true})
+	require.Nil(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, closer, err := New(&Config{ServiceName: "test", Backend: BackendOTLP},
+		WithSampler(sampler), WithSpanExporter(exporter), WithSyncer())
+	require.Nil(t, err)
+	defer func() { _ = closer.Close() }()
+
+	parent := tracer.StartSpan("parent")
+	tracer.StartSpan("ok", opentracing.ChildOf(parent.Context())).Finish()
+	failed := tracer.StartSpan("failed", opentracing.ChildOf(parent.Context()))
+	ext.Error.Set(failed, true)
+	failed.Finish()
+	parent.Finish()
+	require.NotEmpty(t, TraceIdFromSpan(parent))
+
+	spans := exporter.GetSpans()
+	require.Equal(t, 1, len(spans))
+	require.Equal(t, "failed", spans[0].Name)
+	exporter.Reset()
+
+	// Reload at runtime.
+	require.Nil(t, sampler.Update(&SamplingConfig{
+		Type:  SamplerConst,
+		Param: 1,
+		Rules: []*SamplingRule{{Operation: "/grpc.health.v1.Health/*", Type: SamplerConst, Param: 0}},
+	}))
+	tracer.StartSpan("/grpc.health.v1.Health/Check").Finish()
+	tracer.StartSpan("request").Finish()
+	spans = exporter.GetSpans()
+	require.Equal(t, 1, len(spans))
+	require.Equal(t, "request", spans[0].Name)
+}
+
+func Test_JaegerTracerSampling(t *testing.T) {
+	sampler, err := NewSampler(&SamplingConfig{Type: SamplerConst, Param: 0, SampleErrors: true})
+	require.Nil(t, err)
+	tracer, closer, err := New(&Config{ServiceName: "test", LocalAgent: "127.0.0.1:6831"}, WithSampler(sampler))
+	require.Nil(t, err)
+	defer func() { _ = closer.Close() }()
+
+	span := tracer.StartSpan("request")
+	require.False(t, span.Context().(SpanContext).IsSampled())
+	ext.Error.Set(span, true)
+	require.True(t, span.Context().(SpanContext).IsSampled())
+	span.Finish()
+
+	require.Nil(t, sampler.Update(&SamplingConfig{
+		Rules: []*SamplingRule{{Operation: "/grpc.health.v1.Health/*", Type: SamplerConst, Param: 0}},
+	}))
+	span = tracer.StartSpan("request")
+	require.True(t, span.Context().(SpanContext).IsSampled())
+	span.Finish()
+	span = tracer.StartSpan("/grpc.health.v1.Health/Watch")
+	require.False(t, span.Context().(SpanContext).IsSampled())
+	span.Finish()
+}
@@ -5,41 +5,112 @@ import (
 
 	"github.com/DataWorkbench/glog"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/uber/jaeger-client-go"
 	"github.com/uber/jaeger-client-go/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type Tracer = opentracing.Tracer
 type SpanContext = jaeger.SpanContext
 
+const (
+	// BackendJaeger reports spans to jaeger agent by jaeger client, it's deprecated upstream.
+	BackendJaeger = "jaeger"
+	// BackendOTLP exports spans by OpenTelemetry OTLP gRPC exporter.
+	BackendOTLP = "otlp"
+)
+
 // Config for create a new tracer.
 type Config struct {
 	ServiceName string `json:"service_name" yaml:"service_name" env:"service_name" validate:"required"`
-	LocalAgent  string `json:"local_agent"  yaml:"local_agent"  env:"local_agent"  validate:"required"`
+	LocalAgent  string `json:"local_agent"  yaml:"local_agent"  env:"local_agent"  validate:"required_unless=Backend otlp"`
+
+	// Backend is the implementation of tracer, "jaeger" or "otlp". Default is "jaeger".
+	Backend string `json:"backend" yaml:"backend" env:"backend" validate:"omitempty,oneof=jaeger otlp"`
+	// OTLPEndpoint is the address of OTLP gRPC receiver, eg: "127.0.0.1:4317".
+	OTLPEndpoint string `json:"otlp_endpoint" yaml:"otlp_endpoint" env:"otlp_endpoint" validate:"required_if=Backend otlp"`
+	// OTLPInsecure disables the client transport security of OTLP exporter.
+	OTLPInsecure bool `json:"otlp_insecure" yaml:"otlp_insecure" env:"otlp_insecure"`
+
+	// Sampling decides which traces to be sampled. Default is sample all traces.
+	Sampling *SamplingConfig `json:"sampling" yaml:"sampling" env:",prefix=sampling_"`
 }
 
-// New create a new opentracing.Tracer by jaeger.
-func New(cfg *Config) (tracer Tracer, closer io.Closer, err error) {
-	// Config the jaeger
-	jCfg := genJaegerConfig(cfg)
-	tracer, closer, err = jCfg.NewTracer(config.Logger(jaeger.NullLogger))
-	return
+// Option for configure the tracer.
+type Option func(o *options)
+
+type options struct {
+	sampler  *Sampler
+	exporter sdktrace.SpanExporter
+	syncer   bool
+}
+
+// WithSampler sets the sampler that used instead of the one created by Config.Sampling,
+// the caller can reload the sampling config at runtime by Sampler.Update.
+func WithSampler(sampler *Sampler) Option {
+	return func(o *options) {
+		o.sampler = sampler
+	}
 }
 
-func NewWithGLog(cfg *Config, lg *glog.Logger) (tracer Tracer, closer io.Closer, err error) {
+func applyOptions(cfg *Config, opts []Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.sampler == nil {
+		var err error
+		if o.sampler, err = NewSampler(cfg.Sampling); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// New create a new opentracing.Tracer by the backend of config.
+func New(cfg *Config, opts ...Option) (tracer Tracer, closer io.Closer, err error) {
+	return newTracer(cfg, nil, opts)
+}
+
+func NewWithGLog(cfg *Config, lg *glog.Logger, opts ...Option) (tracer Tracer, closer io.Closer, err error) {
+	return newTracer(cfg, lg, opts)
+}
+
+func newTracer(cfg *Config, lg *glog.Logger, opts []Option) (tracer Tracer, closer io.Closer, err error) {
+	var o *options
+	if o, err = applyOptions(cfg, opts); err != nil {
+		return
+	}
+	if cfg.Backend == BackendOTLP {
+		return newOTelTracer(cfg, lg, o)
+	}
+
 	// Config the jaeger
+	var jLogger jaeger.Logger = jaeger.NullLogger
+	if lg != nil {
+		jLogger = &logger{Output: lg}
+	}
 	jCfg := genJaegerConfig(cfg)
-	tracer, closer, err = jCfg.NewTracer(config.Logger(&logger{Output: lg}))
+	tracer, closer, err = jCfg.NewTracer(config.Logger(jLogger), config.Sampler(&jaegerSampler{sampler: o.sampler}))
 	return
 }
 
+// TraceIdFromSpan returns the trace id of span, or empty string if the span is not created
+// by the tracer of this package.
+func TraceIdFromSpan(span opentracing.Span) string {
+	if sc, ok := span.Context().(SpanContext); ok {
+		return sc.TraceID().String()
+	}
+	if sc := otelSpanContext(span); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
 func genJaegerConfig(cfg *Config) config.Configuration {
 	return config.Configuration{
 		ServiceName: cfg.ServiceName,
-		Sampler: &config.SamplerConfig{
-			Type:  "const",
-			Param: 1,
-		},
 		// Set agent collect
 		Reporter: &config.ReporterConfig{
 			// 127.0.0.1:6831
@@ -50,8 +121,51 @@ func genJaegerConfig(cfg *Config) config.Configuration {
 		Headers: &jaeger.HeadersConfig{
 			JaegerDebugHeader:        "x-trace-debug-id",
 			JaegerBaggageHeader:      "x-trace-baggage",
-			TraceContextHeaderName:   "x-trace-id",
+			TraceContextHeaderName:   jaegerTraceContextHeader,
 			TraceBaggageHeaderPrefix: "x-trace-ctx",
 		},
 	}
 }
+
+// jaegerSampler implements the jaeger.SamplerV2 by Sampler.
+//
+// The jaeger client only asks the sampler for the traces that start in this process,
+// the decision of remote parent is always followed.
+type jaegerSampler struct {
+	jaeger.SamplerV2Base
+	sampler *Sampler
+}
+
+// OnCreateSpan for implements jaeger.SamplerV2.
+func (s *jaegerSampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.decide(span, span.OperationName())
+}
+
+// OnSetOperationName for implements jaeger.SamplerV2.
+func (s *jaegerSampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return s.decide(span, operationName)
+}
+
+// OnSetTag for implements jaeger.SamplerV2.
+func (s *jaegerSampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	state := s.sampler.load()
+	if failed, ok := value.(bool); ok && failed && key == string(ext.Error) && state.sampleErrors {
+		return jaeger.SamplingDecision{Sample: true}
+	}
+	return jaeger.SamplingDecision{Retryable: state.sampleErrors}
+}
+
+// OnFinishSpan for implements jaeger.SamplerV2.
+func (s *jaegerSampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	// Keeps the decision open for the error of other spans in the trace.
+	return jaeger.SamplingDecision{Retryable: s.sampler.load().sampleErrors}
+}
+
+func (s *jaegerSampler) decide(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	state := s.sampler.load()
+	if state.sample(operationName, span.SpanContext().TraceID().Low) {
+		return jaeger.SamplingDecision{Sample: true}
+	}
+	// Waits for the error tag if sample errors.
+	return jaeger.SamplingDecision{Retryable: state.sampleErrors}
+}
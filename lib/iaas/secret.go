@@ -0,0 +1,156 @@
+package iaas
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/signer"
+)
+
+// DefaultSecretCacheSize is the default max number of access keys cached by SecretProvider.
+const DefaultSecretCacheSize = 10000
+
+// accessKeyDescriber is implemented by Client, it's replaced by a stub in tests.
+type accessKeyDescriber interface {
+	DescribeAccessKeysById(ctx context.Context, accessKeyId string) (*AccessKey, error)
+}
+
+type SecretOption func(o *secretOptions)
+
+type secretOptions struct {
+	cacheSize int
+}
+
+func applySecretOptions(options ...SecretOption) secretOptions {
+	opts := secretOptions{
+		cacheSize: DefaultSecretCacheSize,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithSecretCacheSize sets the max number of access keys in cache, include the not exists ones.
+// Defaults DefaultSecretCacheSize.
+func WithSecretCacheSize(size int) SecretOption {
+	if size <= 0 {
+		panic("WithSecretCacheSize: size must be greater than 0")
+	}
+	return func(o *secretOptions) {
+		o.cacheSize = size
+	}
+}
+
+// SecretProvider implements the signer.SecretProvider by DescribeAccessKeys, and caches
+// the access keys in memory to reduce the calls to iaas.
+type SecretProvider struct {
+	client accessKeyDescriber
+	ttl    time.Duration
+	opts   secretOptions
+	group  singleflight.Group
+
+	mu        sync.Mutex
+	cache     map[string]*cachedSecret
+	lastSweep time.Time
+}
+
+type cachedSecret struct {
+	accessKey *AccessKey // nil means the access key not exists.
+	expireAt  time.Time
+}
+
+// NewSecretProvider creates a SecretProvider, the access keys are cached for ttl.
+// The not exists access keys are also cached to avoid penetrating to iaas.
+func NewSecretProvider(client *Client, ttl time.Duration, options ...SecretOption) *SecretProvider {
+	return newSecretProvider(client, ttl, options...)
+}
+
+func newSecretProvider(client accessKeyDescriber, ttl time.Duration, options ...SecretOption) *SecretProvider {
+	return &SecretProvider{
+		client:    client,
+		ttl:       ttl,
+		opts:      applySecretOptions(options...),
+		cache:     make(map[string]*cachedSecret),
+		lastSweep: time.Now(),
+	}
+}
+
+// GetSecret for implements signer.SecretProvider.
+func (p *SecretProvider) GetSecret(ctx context.Context, accessKeyId string) (secretAccessKey string, account *signer.Account, err error) {
+	accessKey, err := p.getAccessKey(ctx, accessKeyId)
+	if err != nil {
+		return
+	}
+	if accessKey == nil {
+		err = qerror.AccessKeyNotExists.Format(accessKeyId)
+		return
+	}
+	account = &signer.Account{
+		AccessKeyId: accessKey.AccessKeyId,
+		UserId:      accessKey.Owner,
+		RootUserId:  accessKey.RootUserId,
+	}
+	return accessKey.SecretAccessKey, account, nil
+}
+
+// getAccessKey returns the access key from cache, or from iaas if not cached or expired.
+// The concurrent calls of the same access key are deduplicated.
+func (p *SecretProvider) getAccessKey(ctx context.Context, accessKeyId string) (*AccessKey, error) {
+	p.mu.Lock()
+	cached, ok := p.cache[accessKeyId]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		return cached.accessKey, nil
+	}
+
+	resultC := p.group.DoChan(accessKeyId, func() (interface{}, error) {
+		accessKey, err := p.client.DescribeAccessKeysById(ctx, accessKeyId)
+		if err == ErrAccessKeyNotExists {
+			accessKey, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.set(accessKeyId, accessKey)
+		return accessKey, nil
+	})
+	select {
+	case r := <-resultC:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(*AccessKey), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// set caches the access key. The expired keys are removed at most once per ttl, and an arbitrary
+// key is evicted if the cache is still full.
+func (p *SecretProvider) set(accessKeyId string, accessKey *AccessKey) {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Sub(p.lastSweep) >= p.ttl {
+		for k, v := range p.cache {
+			if now.After(v.expireAt) {
+				delete(p.cache, k)
+			}
+		}
+		p.lastSweep = now
+	}
+	if _, ok := p.cache[accessKeyId]; !ok && len(p.cache) >= p.opts.cacheSize {
+		for k := range p.cache {
+			delete(p.cache, k)
+			break
+		}
+	}
+	p.cache[accessKeyId] = &cachedSecret{accessKey: accessKey, expireAt: now.Add(p.ttl)}
+}
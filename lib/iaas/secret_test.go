@@ -0,0 +1,139 @@
+package iaas
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+type stubDescriber struct {
+	calls   int32
+	delay   time.Duration
+	err     error
+	secrets map[string]string
+}
+
+func (s *stubDescriber) DescribeAccessKeysById(ctx context.Context, accessKeyId string) (*AccessKey, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if s.delay > 0 {
+		time.Sleep(s.delay)
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	secret, ok := s.secrets[accessKeyId]
+	if !ok {
+		return nil, ErrAccessKeyNotExists
+	}
+	return &AccessKey{AccessKeyId: accessKeyId, SecretAccessKey: secret, Owner: "usr-1", RootUserId: "usr-0"}, nil
+}
+
+func (s *stubDescriber) Calls() int {
+	return int(atomic.LoadInt32(&s.calls))
+}
+
+func Test_SecretProviderCache(t *testing.T) {
+	stub := &stubDescriber{secrets: map[string]string{"ak-1": "sk-1"}}
+	p := newSecretProvider(stub, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		secret, account, err := p.GetSecret(ctx, "ak-1")
+		require.Nil(t, err)
+		require.Equal(t, "sk-1", secret)
+		require.Equal(t, "ak-1", account.AccessKeyId)
+		require.Equal(t, "usr-1", account.UserId)
+		require.Equal(t, "usr-0", account.RootUserId)
+	}
+	require.Equal(t, 1, stub.Calls())
+
+	// The not exists access key is cached too.
+	for i := 0; i < 3; i++ {
+		_, _, err := p.GetSecret(ctx, "ak-2")
+		require.NotNil(t, err)
+		require.Equal(t, qerror.AccessKeyNotExists.Code(), err.(*qerror.Error).Code())
+	}
+	require.Equal(t, 2, stub.Calls())
+}
+
+func Test_SecretProviderError(t *testing.T) {
+	stub := &stubDescriber{err: errors.New("iaas unavailable")}
+	p := newSecretProvider(stub, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := p.GetSecret(context.Background(), "ak-1")
+		require.Equal(t, stub.err, err)
+	}
+	// The errors are not cached.
+	require.Equal(t, 2, stub.Calls())
+	require.Len(t, p.cache, 0)
+}
+
+func Test_SecretProviderExpire(t *testing.T) {
+	stub := &stubDescriber{secrets: map[string]string{"ak-1": "sk-1"}}
+	p := newSecretProvider(stub, time.Millisecond*50)
+	ctx := context.Background()
+
+	_, _, err := p.GetSecret(ctx, "ak-1")
+	require.Nil(t, err)
+	_, _, err = p.GetSecret(ctx, "ak-2")
+	require.NotNil(t, err)
+	require.Equal(t, 2, stub.Calls())
+
+	time.Sleep(time.Millisecond * 60)
+
+	// The expired ak-1 is reloaded, and the expired ak-2 is removed by the sweep.
+	_, _, err = p.GetSecret(ctx, "ak-1")
+	require.Nil(t, err)
+	require.Equal(t, 3, stub.Calls())
+	require.Len(t, p.cache, 1)
+	require.Contains(t, p.cache, "ak-1")
+}
+
+func Test_SecretProviderCacheSize(t *testing.T) {
+	stub := &stubDescriber{}
+	p := newSecretProvider(stub, time.Hour, WithSecretCacheSize(10))
+
+	for i := 0; i < 100; i++ {
+		_, _, err := p.GetSecret(context.Background(), "ak-"+strconv.Itoa(i))
+		require.NotNil(t, err)
+	}
+	require.Equal(t, 100, stub.Calls())
+	require.Len(t, p.cache, 10)
+	require.Contains(t, p.cache, "ak-99")
+
+	require.Panics(t, func() { WithSecretCacheSize(0) })
+}
+
+func Test_SecretProviderSingleflight(t *testing.T) {
+	stub := &stubDescriber{delay: time.Millisecond * 100, secrets: map[string]string{"ak-1": "sk-1"}}
+	p := newSecretProvider(stub, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			secret, _, err := p.GetSecret(context.Background(), "ak-1")
+			require.Nil(t, err)
+			require.Equal(t, "sk-1", secret)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, stub.Calls())
+
+	// The waiting caller returns when its context is done.
+	stub.secrets["ak-2"] = "sk-2"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, _, err := p.GetSecret(ctx, "ak-2")
+	require.Equal(t, context.DeadlineExceeded, err)
+}
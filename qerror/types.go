@@ -72,6 +72,38 @@ var (
 		enUS:   "The used resource [%s %s] has been deleted.",
 		zhCN:   "依赖的资源 [%s %s] 已经被删除",
 	}
+
+	// ResourceConflict render message if the resource has been modified by others since it was read.
+	ResourceConflict = &Error{
+		code:   "ResourceConflict",
+		status: 409,
+		enUS:   "The resource [%s] has been modified by others, please reload and try again.",
+		zhCN:   "资源 [%s] 已被他人修改, 请刷新后重试.",
+	}
+
+	// TooManyRequests render message if the request exceeds the rate or concurrency limit.
+	TooManyRequests = &Error{
+		code:   "TooManyRequests",
+		status: 429,
+		enUS:   "The requests of [%s] exceed the limit, please try again later.",
+		zhCN:   "[%s] 的请求超出限制, 请稍后重试.",
+	}
+
+	// RequestEntityTooLarge render message if the request body exceeds the limit.
+	RequestEntityTooLarge = &Error{
+		code:   "RequestEntityTooLarge",
+		status: 413,
+		enUS:   "The request body exceeds the limit of [%d] bytes.",
+		zhCN:   "请求体超出 [%d] 字节的限制.",
+	}
+
+	// WebSocketHandshakeFailed render message if upgrade the request to websocket failed.
+	WebSocketHandshakeFailed = &Error{
+		code:   "WebSocketHandshakeFailed",
+		status: 400,
+		enUS:   "The websocket handshake failed: %s.",
+		zhCN:   "WebSocket 握手失败: %s.",
+	}
 )
 
 // parameters error
@@ -215,6 +247,18 @@ var (
 		enUS:   "The request signature server calculated does not match the signature you provided.",
 		zhCN:   "",
 	}
+	InvalidContentMD5 = &Error{
+		code:   "InvalidContentMD5",
+		status: 400,
+		enUS:   "The Content-MD5 you provided does not match the request body.",
+		zhCN:   "",
+	}
+	ReplayedSignature = &Error{
+		code:   "ReplayedSignature",
+		status: 401,
+		enUS:   "The signature has been used, the request can not be replayed.",
+		zhCN:   "",
+	}
 )
 
 // general error.
@@ -0,0 +1,354 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned by Cache.GetOrLoad if the value does not exist.
+// The loader should return it to make the negative result be cached.
+var ErrNotFound = errors.New("rediswrap: cache not found")
+
+// The first byte of the cached data, used to distinguish negative results.
+const (
+	cacheFlagValue    byte = 1
+	cacheFlagNotFound byte = 0
+)
+
+// Loader loads the value from source (eg: mysql) when cache missed.
+// Returns ErrNotFound if the value does not exist.
+type Loader func(ctx context.Context) (interface{}, error)
+
+type CacheOption func(o *cacheOptions)
+
+type cacheOptions struct {
+	codec       Codec
+	negativeTTL time.Duration
+	jitter      float64
+	loadTimeout time.Duration
+
+	localSize    int
+	localTTL     time.Duration
+	localChannel string
+}
+
+func applyCacheOptions(options ...CacheOption) cacheOptions {
+	opts := cacheOptions{
+		codec:       JSONCodec,
+		negativeTTL: time.Minute,
+		jitter:      0.1,
+		loadTimeout: time.Second * 10,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithCacheCodec sets the codec of the cached values. Defaults JSONCodec.
+func WithCacheCodec(codec Codec) CacheOption {
+	return func(o *cacheOptions) {
+		o.codec = codec
+	}
+}
+
+// WithNegativeTTL sets the ttl of the negative result that loader returns ErrNotFound.
+// 0 means the negative results are not cached. Defaults 1m.
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithTTLJitter sets the max fraction of random ttl added to each key to avoid cache stampede.
+// eg: 0.1 means the actual ttl is in [ttl, ttl*1.1). Defaults 0.1.
+func WithTTLJitter(fraction float64) CacheOption {
+	return func(o *cacheOptions) {
+		o.jitter = fraction
+	}
+}
+
+// WithLoadTimeout sets the timeout of loader and set the result to cache. The loader runs with a
+// context detached from the caller, because its result is shared by the concurrent callers. Defaults 10s.
+func WithLoadTimeout(timeout time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.loadTimeout = timeout
+	}
+}
+
+// WithLocalCache enables the local in-process L1 cache with at most size keys and ttl, 0 ttl means the
+// keys never expire until evicted or invalidated. The local keys are invalidated on all instances through
+// redis pub/sub channel when Cache.Delete called.
+//
+// The client must support Subscribe, eg: *redis.Client, *redis.ClusterClient and *redis.Ring.
+func WithLocalCache(size int, ttl time.Duration, channel string) CacheOption {
+	return func(o *cacheOptions) {
+		o.localSize = size
+		o.localTTL = ttl
+		o.localChannel = channel
+	}
+}
+
+// Cache is a cache-aside helper on redis.
+type Cache struct {
+	lp     *glog.Logger
+	client Client
+	prefix string
+	opts   cacheOptions
+	group  singleflight.Group
+
+	local  *localCache
+	pubsub *redis.PubSub
+	exitC  chan struct{}
+}
+
+// NewCache creates a Cache, the keys in redis are "<prefix><key>".
+func NewCache(ctx context.Context, client Client, prefix string, options ...CacheOption) *Cache {
+	c := &Cache{
+		lp:     glog.FromContext(ctx),
+		client: client,
+		prefix: prefix,
+		opts:   applyCacheOptions(options...),
+	}
+
+	if c.opts.localSize > 0 {
+		sub, ok := client.(subscriber)
+		if !ok {
+			panic("Cache: the client must support Subscribe to use local cache")
+		}
+		c.local = newLocalCache(c.opts.localSize, c.opts.localTTL)
+		c.pubsub = sub.Subscribe(ctx, c.opts.localChannel)
+		c.exitC = make(chan struct{})
+		go c.subscribe()
+	}
+	return c
+}
+
+// GetOrLoad gets the value of key from cache into value, calls the loader and sets it to cache if not exists.
+// The concurrent loads of the same key are deduplicated, each caller waits for the result until its ctx done.
+// value must be a pointer.
+// Returns ErrNotFound if the loader returns ErrNotFound or it has been cached.
+func (c *Cache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, value interface{}, loader Loader) error {
+	if c.local != nil {
+		if data, ok := c.local.get(key); ok {
+			return c.decode(data, value)
+		}
+	}
+
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil && err != redis.Nil {
+		c.lp.Warn().Msg("rediswrap: get cache error, fallback to loader").String("key", key).Error("error", err).Fire()
+	}
+	if err == nil && len(data) > 0 {
+		if c.local != nil {
+			c.local.set(key, data)
+		}
+		return c.decode(data, value)
+	}
+
+	resultC := c.group.DoChan(key, func() (interface{}, error) {
+		lctx, cancel := context.WithTimeout(detachedContext{ctx}, c.opts.loadTimeout)
+		defer cancel()
+		return c.load(lctx, key, ttl, loader)
+	})
+	select {
+	case r := <-resultC:
+		if r.Err != nil {
+			return r.Err
+		}
+		return c.decode(r.Val.([]byte), value)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// load calls the loader and sets the result to cache.
+func (c *Cache) load(ctx context.Context, key string, ttl time.Duration, loader Loader) ([]byte, error) {
+	v, err := loader(ctx)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	var data []byte
+	if err == ErrNotFound {
+		if c.opts.negativeTTL <= 0 {
+			return nil, ErrNotFound
+		}
+		data = []byte{cacheFlagNotFound}
+		ttl = c.opts.negativeTTL
+	} else {
+		if data, err = c.encode(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = c.client.Set(ctx, c.prefix+key, data, c.jitter(ttl)).Err(); err != nil {
+		c.lp.Warn().Msg("rediswrap: set cache error").String("key", key).Error("error", err).Fire()
+	}
+	if c.local != nil {
+		c.local.set(key, data)
+	}
+	return data, nil
+}
+
+// Set sets the value of key to cache.
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+	if err = c.client.Set(ctx, c.prefix+key, data, c.jitter(ttl)).Err(); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, key)
+}
+
+// Delete deletes the keys from cache, and invalidates the local cache of all instances.
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	fullKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = c.prefix + key
+	}
+	if err := c.client.Del(ctx, fullKeys...).Err(); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, keys...)
+}
+
+// Close stops the subscription of local cache invalidation.
+func (c *Cache) Close() error {
+	if c == nil || c.pubsub == nil {
+		return nil
+	}
+	err := c.pubsub.Close()
+	<-c.exitC
+	return err
+}
+
+func (c *Cache) invalidate(ctx context.Context, keys ...string) error {
+	if c.local == nil {
+		return nil
+	}
+	for _, key := range keys {
+		c.local.delete(key)
+		if err := c.client.Publish(ctx, c.opts.localChannel, key).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) subscribe() {
+	defer close(c.exitC)
+	for msg := range c.pubsub.Channel() {
+		c.local.delete(msg.Payload)
+	}
+}
+
+func (c *Cache) encode(value interface{}) ([]byte, error) {
+	b, err := c.opts.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, len(b)+1)
+	data[0] = cacheFlagValue
+	copy(data[1:], b)
+	return data, nil
+}
+
+func (c *Cache) decode(data []byte, value interface{}) error {
+	if data[0] == cacheFlagNotFound {
+		return ErrNotFound
+	}
+	return c.opts.codec.Unmarshal(data[1:], value)
+}
+
+func (c *Cache) jitter(ttl time.Duration) time.Duration {
+	if ttl <= 0 || c.opts.jitter <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(int64(float64(ttl)*c.opts.jitter)+1))
+}
+
+// subscriber is implemented by the redis clients that support pub/sub.
+type subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// detachedContext keeps the values of parent, eg: the logger and span, but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// localCache is a simple in-process cache with size limit and ttl.
+type localCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*localItem
+}
+
+type localItem struct {
+	data     []byte
+	expireAt time.Time
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	return &localCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*localItem, size),
+	}
+}
+
+func (l *localCache) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	item, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+		delete(l.items, key)
+		return nil, false
+	}
+	return item.data, true
+}
+
+func (l *localCache) set(key string, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.items[key]; !ok && len(l.items) >= l.size {
+		// Evict an arbitrary key to make room.
+		for k := range l.items {
+			delete(l.items, k)
+			break
+		}
+	}
+	item := &localItem{data: data}
+	if l.ttl > 0 {
+		item.expireAt = time.Now().Add(l.ttl)
+	}
+	l.items[key] = item
+}
+
+func (l *localCache) delete(key string) {
+	l.mu.Lock()
+	delete(l.items, key)
+	l.mu.Unlock()
+}
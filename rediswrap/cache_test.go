@@ -0,0 +1,88 @@
+package rediswrap
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CacheGetOrLoad(t *testing.T) {
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	client, mr := newTestClient(t)
+	c := NewCache(ctx, client, "cache:", WithTTLJitter(0))
+	defer func() { _ = c.Close() }()
+
+	var loads int32
+	startC := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-startC
+		return "value", ctx.Err()
+	}
+
+	// The first caller canceled doesn't fail the other callers waiting for the same load.
+	cctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	values := make([]string, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lctx := ctx
+			if i == 0 {
+				lctx = cctx
+			}
+			errs[i] = c.GetOrLoad(lctx, "key", time.Minute, &values[i], loader)
+		}(i)
+	}
+	for atomic.LoadInt32(&loads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	time.Sleep(time.Millisecond * 10)
+	close(startC)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	require.Equal(t, context.Canceled, errs[0])
+	for i := 1; i < len(errs); i++ {
+		require.Nil(t, errs[i])
+		require.Equal(t, "value", values[i])
+	}
+	require.Equal(t, time.Minute, mr.TTL("cache:key"))
+
+	// The negative result is cached.
+	notFound := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return nil, ErrNotFound
+	}
+	var value string
+	require.Equal(t, ErrNotFound, c.GetOrLoad(ctx, "missing", time.Minute, &value, notFound))
+	require.Equal(t, ErrNotFound, c.GetOrLoad(ctx, "missing", time.Minute, &value, notFound))
+	require.Equal(t, int32(2), atomic.LoadInt32(&loads))
+}
+
+func Test_LocalCacheTTL(t *testing.T) {
+	l := newLocalCache(2, 0)
+	l.set("a", []byte("1"))
+	l.set("b", []byte("2"))
+	data, ok := l.get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), data)
+
+	// Evicted by size.
+	l.set("c", []byte("3"))
+	require.Equal(t, 2, len(l.items))
+
+	l = newLocalCache(2, time.Millisecond)
+	l.set("a", []byte("1"))
+	time.Sleep(time.Millisecond * 2)
+	_, ok = l.get("a")
+	require.False(t, ok)
+}
@@ -2,8 +2,12 @@ package rediswrap
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/DataWorkbench/common/gtrace"
 	"github.com/go-redis/redis/v8"
@@ -21,6 +25,17 @@ type Client interface {
 	Close() error
 }
 
+// statsClient is the client created by NewRedisConn, it stops exporting the pool stats when closed.
+type statsClient struct {
+	redis.UniversalClient
+	name string
+}
+
+func (c *statsClient) Close() error {
+	poolCollector.remove(c.name, c)
+	return c.UniversalClient.Close()
+}
+
 type RedisConfig struct {
 	// Optional Value: "standalone/sentinel/cluster".
 	Mode       string `json:"mode"                yaml:"mode"            env:"MODE"            validate:"required"`
@@ -31,38 +46,157 @@ type RedisConfig struct {
 	ClusterAddr string `json:"cluster_addr"       yaml:"cluster_addr"    env:"CLUSTER_ADDR"`
 	// eg: "127.0.0.1:7000,127.0.0.1:7001,127.0.0.1:7002,127.0.0.1:7003,127.0.0.1:7004,127.0.0.1:7005".
 	SentinelAddr string `json:"sentinel_addr"     yaml:"sentinel_addr"   env:"SENTINEL_ADDR"`
-	UserName     string `json:"user_name"         yaml:"user_name"       env:"UER_NAME"`
+	UserName     string `json:"user_name"         yaml:"user_name"       env:"USER_NAME"`
 	Password     string `json:"password"          yaml:"password"        env:"PASSWORD"`
-	Database     int    `json:"database"          yaml:"database"        env:"DATABASE"`
+	// Database is ignored in cluster mode, and must be 0 in sentinel mode with ReadOnly or RouteByLatency.
+	Database int `json:"database"                 yaml:"database"        env:"DATABASE"`
+
+	DialTimeout  time.Duration `json:"dial_timeout"  yaml:"dial_timeout"  env:"DIAL_TIMEOUT,default=5s"`
+	ReadTimeout  time.Duration `json:"read_timeout"  yaml:"read_timeout"  env:"READ_TIMEOUT,default=3s"`
+	WriteTimeout time.Duration `json:"write_timeout" yaml:"write_timeout" env:"WRITE_TIMEOUT,default=3s"`
+	// Maximum number of socket connections. 0 means 10 connections per every available CPU.
+	PoolSize int `json:"pool_size"         yaml:"pool_size"      env:"POOL_SIZE"`
+	// Minimum number of idle connections.
+	MinIdleConns int `json:"min_idle_conns" yaml:"min_idle_conns" env:"MIN_IDLE_CONNS"`
+	// Maximum number of retries before giving up. 0 means the default 3 retries, -1 disables retries.
+	MaxRetries int `json:"max_retries"      yaml:"max_retries"    env:"MAX_RETRIES"`
+
+	// ReadOnly enables read-only commands on replica nodes in cluster and sentinel mode.
+	// In sentinel mode the read-only commands are routed randomly between master and replicas.
+	ReadOnly bool `json:"read_only"               yaml:"read_only"        env:"READ_ONLY"`
+	// RouteByLatency routes read-only commands to the closest master or replica node
+	// in cluster and sentinel mode. It implies ReadOnly.
+	RouteByLatency bool `json:"route_by_latency" yaml:"route_by_latency" env:"ROUTE_BY_LATENCY"`
+
+	TLS *TLSConfig `json:"tls" yaml:"tls" env:",prefix=TLS_"`
+}
+
+// TLSConfig used to connect to redis with TLS.
+type TLSConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" env:"ENABLED"`
+	// The client certificate and key for mutual TLS, optional.
+	CertFile string `json:"cert_file" yaml:"cert_file" env:"CERT_FILE"`
+	KeyFile  string `json:"key_file"  yaml:"key_file"  env:"KEY_FILE"`
+	// The CA certificate to verify the server, use the system CA pool if empty.
+	CAFile             string `json:"ca_file"              yaml:"ca_file"              env:"CA_FILE"`
+	ServerName         string `json:"server_name"          yaml:"server_name"          env:"SERVER_NAME"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify" env:"INSECURE_SKIP_VERIFY"`
+}
+
+func (cfg *TLSConfig) convert() (*tls.Config, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		b, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no valid certificate found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
 }
 
 func NewRedisConn(ctx context.Context, cfg *RedisConfig) (Client, error) {
-	var rdb Client
+	tlsConfig, err := cfg.TLS.convert()
+	if err != nil {
+		return nil, err
+	}
+
+	var rdb redis.UniversalClient
+	var name string
 	switch cfg.Mode {
 	case StandaloneMode:
+		name = cfg.StandaloneAddr
 		rdb = redis.NewClient(&redis.Options{
-			Addr:     cfg.StandaloneAddr,
-			Username: cfg.UserName,
-			Password: cfg.Password,
+			Addr:         cfg.StandaloneAddr,
+			Username:     cfg.UserName,
+			Password:     cfg.Password,
+			DB:           cfg.Database,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			TLSConfig:    tlsConfig,
 		})
 	case SentinelMode:
-		rdb = redis.NewFailoverClusterClient(&redis.FailoverOptions{
-			MasterName:    cfg.MasterName,
-			Username:      cfg.UserName,
-			SentinelAddrs: strings.Split(cfg.SentinelAddr, ","),
-			Password:      cfg.Password,
-			DB:            cfg.Database,
-		})
+		name = cfg.MasterName
+		opts := &redis.FailoverOptions{
+			MasterName:     cfg.MasterName,
+			Username:       cfg.UserName,
+			SentinelAddrs:  strings.Split(cfg.SentinelAddr, ","),
+			Password:       cfg.Password,
+			DB:             cfg.Database,
+			RouteByLatency: cfg.RouteByLatency,
+			RouteRandomly:  cfg.ReadOnly && !cfg.RouteByLatency,
+			MaxRetries:     cfg.MaxRetries,
+			DialTimeout:    cfg.DialTimeout,
+			ReadTimeout:    cfg.ReadTimeout,
+			WriteTimeout:   cfg.WriteTimeout,
+			PoolSize:       cfg.PoolSize,
+			MinIdleConns:   cfg.MinIdleConns,
+			TLSConfig:      tlsConfig,
+		}
+		if !opts.RouteByLatency && !opts.RouteRandomly {
+			rdb = redis.NewFailoverClient(opts)
+			break
+		}
+		// The failover cluster client that routes the read-only commands to replicas
+		// always uses database 0.
+		if cfg.Database != 0 {
+			return nil, fmt.Errorf("database %d is not supported with read_only or route_by_latency in sentinel mode", cfg.Database)
+		}
+		rdb = redis.NewFailoverClusterClient(opts)
 	case ClusterMode:
+		name = cfg.ClusterAddr
 		rdb = redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs:    strings.Split(cfg.ClusterAddr, ","),
-			Username: cfg.UserName,
-			Password: cfg.Password,
+			Addrs:          strings.Split(cfg.ClusterAddr, ","),
+			Username:       cfg.UserName,
+			Password:       cfg.Password,
+			ReadOnly:       cfg.ReadOnly,
+			RouteByLatency: cfg.RouteByLatency,
+			MaxRetries:     cfg.MaxRetries,
+			DialTimeout:    cfg.DialTimeout,
+			ReadTimeout:    cfg.ReadTimeout,
+			WriteTimeout:   cfg.WriteTimeout,
+			PoolSize:       cfg.PoolSize,
+			MinIdleConns:   cfg.MinIdleConns,
+			TLSConfig:      tlsConfig,
 		})
 	default:
 		return nil, fmt.Errorf("unsupported mode: %s", cfg.Mode)
 	}
 
 	rdb.AddHook(&hookTrace{tracer: gtrace.TracerFromContext(ctx)})
-	return rdb, nil
+	rdb.AddHook(&hookMetrics{})
+
+	// Export the pool stats with label client "<mode>://<address>", the address is master name in sentinel mode.
+	client := &statsClient{UniversalClient: rdb, name: cfg.Mode + "://" + name}
+	poolCollector.add(client.name, client)
+	return client, nil
+}
+
+// Ping checks the connectivity of redis. It can be used as a health check, eg:
+//
+//	server.AddHealthCheck("redis", func(ctx context.Context) error { return rediswrap.Ping(ctx, rdb) })
+func Ping(ctx context.Context, client Client) error {
+	return client.Ping(ctx).Err()
 }
@@ -0,0 +1,195 @@
+package rediswrap
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestConfig returns a RedisConfig that all optional fields are set.
+func newTestConfig(mode string) *RedisConfig {
+	return &RedisConfig{
+		Mode:           mode,
+		MasterName:     "mymaster",
+		StandaloneAddr: "127.0.0.1:1",
+		ClusterAddr:    "127.0.0.1:1,127.0.0.1:2",
+		SentinelAddr:   "127.0.0.1:1,127.0.0.1:2",
+		UserName:       "user",
+		Password:       "password",
+		DialTimeout:    time.Second,
+		ReadTimeout:    2 * time.Second,
+		WriteTimeout:   4 * time.Second,
+		PoolSize:       16,
+		MinIdleConns:   2,
+		MaxRetries:     5,
+		TLS:            &TLSConfig{Enabled: true, ServerName: "redis.local", InsecureSkipVerify: true},
+	}
+}
+
+func requireTLSConfig(t *testing.T, tlsConfig *tls.Config) {
+	require.NotNil(t, tlsConfig)
+	require.Equal(t, "redis.local", tlsConfig.ServerName)
+	require.True(t, tlsConfig.InsecureSkipVerify)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+}
+
+func Test_NewRedisConnStandaloneOptions(t *testing.T) {
+	cfg := newTestConfig(StandaloneMode)
+	cfg.Database = 3
+
+	client, err := NewRedisConn(context.Background(), cfg)
+	require.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	opts := client.(*statsClient).UniversalClient.(*redis.Client).Options()
+	require.Equal(t, "127.0.0.1:1", opts.Addr)
+	require.Equal(t, "user", opts.Username)
+	require.Equal(t, "password", opts.Password)
+	require.Equal(t, 3, opts.DB)
+	require.Equal(t, time.Second, opts.DialTimeout)
+	require.Equal(t, 2*time.Second, opts.ReadTimeout)
+	require.Equal(t, 4*time.Second, opts.WriteTimeout)
+	require.Equal(t, 16, opts.PoolSize)
+	require.Equal(t, 2, opts.MinIdleConns)
+	require.Equal(t, 5, opts.MaxRetries)
+	requireTLSConfig(t, opts.TLSConfig)
+}
+
+func Test_NewRedisConnSentinelOptions(t *testing.T) {
+	cfg := newTestConfig(SentinelMode)
+	cfg.Database = 3
+
+	client, err := NewRedisConn(context.Background(), cfg)
+	require.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	// The failover client is used if no read-only routing, it supports database.
+	opts := client.(*statsClient).UniversalClient.(*redis.Client).Options()
+	require.Equal(t, "user", opts.Username)
+	require.Equal(t, "password", opts.Password)
+	require.Equal(t, 3, opts.DB)
+	require.Equal(t, time.Second, opts.DialTimeout)
+	require.Equal(t, 2*time.Second, opts.ReadTimeout)
+	require.Equal(t, 4*time.Second, opts.WriteTimeout)
+	require.Equal(t, 16, opts.PoolSize)
+	require.Equal(t, 2, opts.MinIdleConns)
+	require.Equal(t, 5, opts.MaxRetries)
+	requireTLSConfig(t, opts.TLSConfig)
+
+	// The read-only commands are routed by the failover cluster client.
+	for _, c := range []struct {
+		readOnly       bool
+		routeByLatency bool
+	}{{true, false}, {false, true}, {true, true}} {
+		cfg.ReadOnly, cfg.RouteByLatency = c.readOnly, c.routeByLatency
+
+		cfg.Database = 3
+		_, err = NewRedisConn(context.Background(), cfg)
+		require.NotNil(t, err, "%+v", c)
+
+		cfg.Database = 0
+		client, err := NewRedisConn(context.Background(), cfg)
+		require.Nil(t, err)
+
+		copts := client.(*statsClient).UniversalClient.(*redis.ClusterClient).Options()
+		require.True(t, copts.ReadOnly)
+		require.Equal(t, c.routeByLatency, copts.RouteByLatency)
+		require.Equal(t, !c.routeByLatency, copts.RouteRandomly)
+		require.Equal(t, "user", copts.Username)
+		require.Equal(t, "password", copts.Password)
+		require.Equal(t, time.Second, copts.DialTimeout)
+		require.Equal(t, 2*time.Second, copts.ReadTimeout)
+		require.Equal(t, 4*time.Second, copts.WriteTimeout)
+		require.Equal(t, 16, copts.PoolSize)
+		require.Equal(t, 2, copts.MinIdleConns)
+		requireTLSConfig(t, copts.TLSConfig)
+		require.Nil(t, client.Close())
+	}
+}
+
+func Test_NewRedisConnClusterOptions(t *testing.T) {
+	for _, c := range []struct {
+		readOnly       bool
+		routeByLatency bool
+	}{{false, false}, {true, false}, {false, true}} {
+		cfg := newTestConfig(ClusterMode)
+		cfg.Database = 3 // ignored
+		cfg.ReadOnly, cfg.RouteByLatency = c.readOnly, c.routeByLatency
+
+		client, err := NewRedisConn(context.Background(), cfg)
+		require.Nil(t, err)
+
+		opts := client.(*statsClient).UniversalClient.(*redis.ClusterClient).Options()
+		require.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, opts.Addrs)
+		require.Equal(t, c.readOnly || c.routeByLatency, opts.ReadOnly)
+		require.Equal(t, c.routeByLatency, opts.RouteByLatency)
+		require.Equal(t, "user", opts.Username)
+		require.Equal(t, "password", opts.Password)
+		require.Equal(t, time.Second, opts.DialTimeout)
+		require.Equal(t, 2*time.Second, opts.ReadTimeout)
+		require.Equal(t, 4*time.Second, opts.WriteTimeout)
+		require.Equal(t, 16, opts.PoolSize)
+		require.Equal(t, 2, opts.MinIdleConns)
+		require.Equal(t, 5, opts.MaxRetries)
+		requireTLSConfig(t, opts.TLSConfig)
+		require.Nil(t, client.Close())
+	}
+}
+
+func Test_NewRedisConnInvalidConfig(t *testing.T) {
+	_, err := NewRedisConn(context.Background(), &RedisConfig{Mode: "unknown"})
+	require.NotNil(t, err)
+
+	dir := t.TempDir()
+	cfg := newTestConfig(StandaloneMode)
+	cfg.TLS.CAFile = filepath.Join(dir, "not-exists.pem")
+	_, err = NewRedisConn(context.Background(), cfg)
+	require.NotNil(t, err)
+
+	cfg.TLS.CAFile = filepath.Join(dir, "ca.pem")
+	require.Nil(t, ioutil.WriteFile(cfg.TLS.CAFile, []byte("not a certificate"), 0600))
+	_, err = NewRedisConn(context.Background(), cfg)
+	require.NotNil(t, err)
+
+	// The TLS config is ignored if not enabled.
+	cfg.TLS.Enabled = false
+	client, err := NewRedisConn(context.Background(), cfg)
+	require.Nil(t, err)
+	require.Nil(t, client.(*statsClient).UniversalClient.(*redis.Client).Options().TLSConfig)
+	require.Nil(t, client.Close())
+}
+
+func Test_NewRedisConnDatabase(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	client, err := NewRedisConn(ctx, &RedisConfig{Mode: StandaloneMode, StandaloneAddr: mr.Addr(), Database: 2})
+	require.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	require.Nil(t, client.Set(ctx, "k", "v", 0).Err())
+	v, err := mr.DB(2).Get("k")
+	require.Nil(t, err)
+	require.Equal(t, "v", v)
+	require.False(t, mr.Exists("k"))
+}
+
+func Test_Ping(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	client, err := NewRedisConn(ctx, &RedisConfig{Mode: StandaloneMode, StandaloneAddr: mr.Addr(), MaxRetries: -1})
+	require.Nil(t, err)
+	defer func() { _ = client.Close() }()
+
+	require.Nil(t, Ping(ctx, client))
+	mr.Close()
+	require.NotNil(t, Ping(ctx, client))
+}
@@ -0,0 +1,54 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrFencingTokenMissing is returned by FencedSet if the fencing token is not set.
+	ErrFencingTokenMissing = errors.New("rediswrap: fencing token missing")
+	// ErrStaleFencingToken is returned by FencedSet if a newer token has been seen.
+	ErrStaleFencingToken = errors.New("rediswrap: stale fencing token")
+)
+
+// fencedSetScript sets the key only if the token is not less than the max token remembered in the fence key.
+var fencedSetScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local token = tonumber(ARGV[1])
+if token < current then
+  return 0
+end
+if token > current then
+  redis.call("SET", KEYS[1], ARGV[1])
+end
+if tonumber(ARGV[3]) > 0 then
+  redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+else
+  redis.call("SET", KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// FencedSet sets the key with the fencing token of leader, eg: the token from getcd.FencingTokenFromContext.
+// The max token seen is remembered in fenceKey, and the write with a smaller token is rejected by
+// ErrStaleFencingToken, so that a stale leader can't overwrite the value written by the newer one.
+//
+// Zero expiration means the key has no expiration. In cluster mode, the fenceKey and key must be in
+// the same hash slot, eg: "{job:1}:fence" and "{job:1}:status".
+func FencedSet(ctx context.Context, client Client, fenceKey string, token int64, key string, value interface{}, expiration time.Duration) error {
+	if token <= 0 {
+		return ErrFencingTokenMissing
+	}
+	n, err := fencedSetScript.Run(ctx, client, []string{fenceKey, key}, token, value, expiration.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
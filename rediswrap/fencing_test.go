@@ -0,0 +1,36 @@
+package rediswrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) (Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client, mr
+}
+
+func Test_FencedSet(t *testing.T) {
+	ctx := context.Background()
+	client, mr := newTestClient(t)
+
+	require.Nil(t, FencedSet(ctx, client, "{job}:fence", 5, "{job}:status", "running", 0))
+	require.Equal(t, ErrStaleFencingToken, FencedSet(ctx, client, "{job}:fence", 3, "{job}:status", "failed", 0))
+	require.Nil(t, FencedSet(ctx, client, "{job}:fence", 5, "{job}:status", "succeed", time.Minute))
+	require.Equal(t, ErrFencingTokenMissing, FencedSet(ctx, client, "{job}:fence", 0, "{job}:status", "failed", 0))
+
+	v, err := mr.Get("{job}:status")
+	require.Nil(t, err)
+	require.Equal(t, "succeed", v)
+	require.Equal(t, time.Minute, mr.TTL("{job}:status"))
+	v, err = mr.Get("{job}:fence")
+	require.Nil(t, err)
+	require.Equal(t, "5", v)
+}
@@ -0,0 +1,45 @@
+package rediswrap
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type hookMetricsStartKey struct{}
+
+// hookMetrics implements redis.Hook to records the latency and errors of commands to prometheus.
+type hookMetrics struct{}
+
+func (h *hookMetrics) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookMetricsStartKey{}, time.Now()), nil
+}
+
+func (h *hookMetrics) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	name := strings.ToLower(cmd.Name())
+	if start, ok := ctx.Value(hookMetricsStartKey{}).(time.Time); ok {
+		commandDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		commandErrors.WithLabelValues(name).Inc()
+	}
+	return nil
+}
+
+func (h *hookMetrics) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, hookMetricsStartKey{}, time.Now()), nil
+}
+
+func (h *hookMetrics) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(hookMetricsStartKey{}).(time.Time); ok {
+		commandDuration.WithLabelValues("pipeline").Observe(time.Since(start).Seconds())
+	}
+	for i := 0; i < len(cmds); i++ {
+		if err := cmds[i].Err(); err != nil && err != redis.Nil {
+			commandErrors.WithLabelValues(strings.ToLower(cmds[i].Name())).Inc()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package rediswrap
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/limiter"
+)
+
+// tokenBucketScript refills the bucket by elapsed time and takes a token.
+//
+// KEYS[1]: the bucket key.
+// ARGV[1]: the rate of tokens refilled per second.
+// ARGV[2]: the burst, the capacity of bucket.
+// Returns {allowed, retry_after_us}
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local state = redis.call("HMGET", key, "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000000)
+
+local allowed = 0
+local retry_after = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry_after = math.ceil((1 - tokens) * 1000000 / rate)
+end
+
+redis.call("HMSET", key, "tokens", tokens, "ts", now)
+redis.call("PEXPIRE", key, math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, retry_after}
+`)
+
+// slidingWindowScript records the events in a sorted set and counts them in the window.
+//
+// KEYS[1]: the window key.
+// ARGV[1]: the max events in window.
+// ARGV[2]: the window size in microseconds.
+// ARGV[3]: the unique member of this event.
+// Returns {allowed, retry_after_us}
+var slidingWindowScript = redis.NewScript(`
+redis.replicate_commands()
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+if redis.call("ZCARD", key) < limit then
+  redis.call("ZADD", key, now, ARGV[3])
+  redis.call("PEXPIRE", key, math.ceil(window / 1000))
+  return {1, 0}
+end
+
+local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+return {0, tonumber(oldest[2]) + window - now}
+`)
+
+var (
+	_ limiter.Limiter = (*TokenBucketLimiter)(nil)
+	_ limiter.Limiter = (*SlidingWindowLimiter)(nil)
+)
+
+// TokenBucketLimiter is a token bucket rate limiter on redis.
+// It allows rate events per second with bursts of at most burst events for each key.
+type TokenBucketLimiter struct {
+	client Client
+	prefix string
+	rate   float64
+	burst  int
+}
+
+// NewTokenBucketLimiter creates a TokenBucketLimiter, the keys in redis are "<prefix><key>".
+func NewTokenBucketLimiter(client Client, prefix string, rate float64, burst int) *TokenBucketLimiter {
+	if rate <= 0 || burst <= 0 {
+		panic("TokenBucketLimiter: rate and burst must be greater than 0")
+	}
+	return &TokenBucketLimiter{client: client, prefix: prefix, rate: rate, burst: burst}
+}
+
+// Allow reports whether an event of key may happen now, and the duration to wait if not.
+func (l *TokenBucketLimiter) Allow(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, err error) {
+	return runLimiterScript(ctx, l.client, tokenBucketScript, l.prefix+key, l.rate, l.burst)
+}
+
+// Acquire implements limiter.Limiter.
+func (l *TokenBucketLimiter) Acquire(ctx context.Context, key string) (release func(), err error) {
+	return acquireByAllow(ctx, key, l.Allow)
+}
+
+// SlidingWindowLimiter is a sliding window log rate limiter on redis.
+// It allows at most limit events in any window for each key.
+type SlidingWindowLimiter struct {
+	client Client
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindowLimiter creates a SlidingWindowLimiter, the keys in redis are "<prefix><key>".
+func NewSlidingWindowLimiter(client Client, prefix string, limit int, window time.Duration) *SlidingWindowLimiter {
+	if limit <= 0 || window <= 0 {
+		panic("SlidingWindowLimiter: limit and window must be greater than 0")
+	}
+	return &SlidingWindowLimiter{client: client, prefix: prefix, limit: limit, window: window}
+}
+
+// Allow reports whether an event of key may happen now, and the duration to wait if not.
+func (l *SlidingWindowLimiter) Allow(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, err error) {
+	return runLimiterScript(ctx, l.client, slidingWindowScript, l.prefix+key,
+		l.limit, l.window.Microseconds(), uuid.NewV4().String())
+}
+
+// Acquire implements limiter.Limiter.
+func (l *SlidingWindowLimiter) Acquire(ctx context.Context, key string) (release func(), err error) {
+	return acquireByAllow(ctx, key, l.Allow)
+}
+
+func runLimiterScript(ctx context.Context, client Client, script *redis.Script, key string, args ...interface{}) (allowed bool, retryAfter time.Duration, err error) {
+	var v interface{}
+	v, err = script.Run(ctx, client, []string{key}, args...).Result()
+	if err != nil {
+		return
+	}
+	values, ok := v.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected result of limiter script: %v", v)
+	}
+	n, _ := values[0].(int64)
+	us, _ := values[1].(int64)
+	return n == 1, time.Duration(us) * time.Microsecond, nil
+}
+
+func acquireByAllow(ctx context.Context, key string, allow func(ctx context.Context, key string) (bool, time.Duration, error)) (func(), error) {
+	allowed, _, err := allow(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, qerror.TooManyRequests.Format(key)
+	}
+	return limiter.Noop, nil
+}
@@ -0,0 +1,83 @@
+package rediswrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+func Test_TokenBucketLimiter(t *testing.T) {
+	ctx := context.Background()
+	client, mr := newTestClient(t)
+	now := time.Now()
+	mr.SetTime(now)
+
+	l := NewTokenBucketLimiter(client, "limiter:", 2, 3)
+	cases := []struct {
+		elapsed    time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		// The bucket is full at first.
+		{0, true, 0},
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, time.Millisecond * 500},
+		{time.Millisecond * 250, false, time.Millisecond * 250},
+		{time.Millisecond * 500, true, 0},
+		{time.Millisecond * 500, false, time.Millisecond * 500},
+		// Refilled up to burst only.
+		{time.Second * 10, true, 0},
+		{time.Second * 10, true, 0},
+		{time.Second * 10, true, 0},
+		{time.Second * 10, false, time.Millisecond * 500},
+	}
+	for i, c := range cases {
+		mr.SetTime(now.Add(c.elapsed))
+		allowed, retryAfter, err := l.Allow(ctx, "user")
+		require.Nil(t, err, i)
+		require.Equal(t, c.allowed, allowed, i)
+		require.Equal(t, c.retryAfter, retryAfter, i)
+	}
+
+	// The keys are independent.
+	allowed, _, err := l.Allow(ctx, "other")
+	require.Nil(t, err)
+	require.True(t, allowed)
+}
+
+func Test_SlidingWindowLimiter(t *testing.T) {
+	ctx := context.Background()
+	client, mr := newTestClient(t)
+	now := time.Now()
+
+	l := NewSlidingWindowLimiter(client, "limiter:", 2, time.Second)
+	cases := []struct {
+		elapsed    time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{0, true, 0},
+		{time.Millisecond * 100, true, 0},
+		{time.Millisecond * 200, false, time.Millisecond * 800},
+		{time.Millisecond * 999, false, time.Millisecond},
+		{time.Millisecond * 1000, true, 0},
+		{time.Millisecond * 1050, false, time.Millisecond * 50},
+		{time.Millisecond * 1100, true, 0},
+	}
+	for i, c := range cases {
+		mr.SetTime(now.Add(c.elapsed))
+		allowed, retryAfter, err := l.Allow(ctx, "user")
+		require.Nil(t, err, i)
+		require.Equal(t, c.allowed, allowed, i)
+		require.Equal(t, c.retryAfter, retryAfter, i)
+	}
+
+	release, err := l.Acquire(ctx, "user")
+	require.Nil(t, release)
+	require.Equal(t, qerror.TooManyRequests.Code(), err.(*qerror.Error).Code())
+}
@@ -0,0 +1,285 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+var (
+	// ErrLockNotObtained is returned by Lock.Acquire if the lock is held by others until timeout.
+	ErrLockNotObtained = errors.New("rediswrap: lock not obtained")
+	// ErrLockNotHeld is returned by Lock.Release if the lock is not held by the current owner.
+	ErrLockNotHeld = errors.New("rediswrap: lock not held")
+)
+
+// releaseScript deletes the key only if it is held by the owner token.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// extendScript resets the ttl of key only if it is held by the owner token.
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+type LockOption func(o *lockOptions)
+
+type lockOptions struct {
+	ttl           time.Duration
+	waitTimeout   time.Duration
+	retryInterval time.Duration
+	watchdog      bool
+}
+
+func applyLockOptions(options ...LockOption) lockOptions {
+	opts := lockOptions{
+		ttl:           time.Second * 30,
+		waitTimeout:   time.Second * 10,
+		retryInterval: time.Millisecond * 100,
+		watchdog:      true,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// minLockTTL is the min ttl of lock key, the precision of "SET PX" is millisecond.
+const minLockTTL = time.Millisecond
+
+// WithLockTTL sets the ttl of lock key, it must be at least 1ms. Defaults 30s.
+func WithLockTTL(d time.Duration) LockOption {
+	if d < minLockTTL {
+		panic("WithLockTTL: ttl must be at least 1ms")
+	}
+	return func(o *lockOptions) {
+		o.ttl = d
+	}
+}
+
+// WithLockWaitTimeout sets the max duration to wait for acquire the lock, 0 means try once. Defaults 10s.
+func WithLockWaitTimeout(d time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.waitTimeout = d
+	}
+}
+
+// WithLockRetryInterval sets the interval time to retry acquire the lock. Defaults 100ms.
+func WithLockRetryInterval(d time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.retryInterval = d
+	}
+}
+
+// WithLockWatchdog controls whether to extend the ttl in background while the lock is held. Defaults true.
+func WithLockWatchdog(ok bool) LockOption {
+	return func(o *lockOptions) {
+		o.watchdog = ok
+	}
+}
+
+// Lock is a distributed lock on redis with owner token and auto-renewal.
+//
+// The lock is set by "SET key token NX PX ttl", and released by a Lua compare-and-delete,
+// so that a lock can only be released by its owner. When the watchdog is enabled, the ttl is
+// extended every ttl/3 until Release, thus the lock won't expire while the holder is alive.
+//
+// A Lock can't be acquired again before released.
+type Lock struct {
+	client Client
+	key    string
+	opts   lockOptions
+
+	mu        sync.Mutex
+	acquiring bool
+	token     string
+	stopC     chan struct{}
+	exitC     chan struct{}
+	lostC     chan struct{}
+}
+
+// NewLock creates a Lock on the key.
+func NewLock(client Client, key string, options ...LockOption) *Lock {
+	return &Lock{
+		client: client,
+		key:    key,
+		opts:   applyLockOptions(options...),
+	}
+}
+
+// Key returns the key of lock.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the owner token of lock, empty if not held.
+func (l *Lock) Token() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.token
+}
+
+// Lost returns a channel that closed when the watchdog found the lock is not held anymore,
+// eg: the key expired due to network partition. Returns nil if the lock not acquired.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lostC
+}
+
+// Acquire acquires the lock, retry until the wait timeout or ctx done.
+// Returns ErrLockNotObtained if timeout.
+func (l *Lock) Acquire(ctx context.Context) (err error) {
+	// The mu is not held while retrying, so that Token and Lost won't be blocked.
+	l.mu.Lock()
+	if l.token != "" || l.acquiring {
+		// The lock is already held or being acquired by itself.
+		l.mu.Unlock()
+		return ErrLockNotObtained
+	}
+	l.acquiring = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.acquiring = false
+		l.mu.Unlock()
+	}()
+
+	tracer := gtrace.TracerFromContext(ctx)
+	span, ctx := l.startSpan(ctx, tracer, "RedisLockAcquire")
+	defer func() { finishSpan(span, err) }()
+
+	token := uuid.NewV4().String()
+	deadline := time.Now().Add(l.opts.waitTimeout)
+	for {
+		var ok bool
+		ok, err = l.client.SetNX(ctx, l.key, token, l.opts.ttl).Result()
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLockNotObtained
+		}
+		select {
+		case <-time.After(l.opts.retryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.token = token
+	l.lostC = make(chan struct{})
+	if l.opts.watchdog {
+		l.stopC = make(chan struct{})
+		l.exitC = make(chan struct{})
+		go l.watchdog(glog.FromContext(ctx), tracer, token, l.stopC, l.exitC, l.lostC)
+	}
+	return nil
+}
+
+// Release releases the lock by compare-and-delete. Returns ErrLockNotHeld if the lock is not held
+// by the current owner, eg: it has been expired.
+func (l *Lock) Release(ctx context.Context) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.token == "" {
+		return ErrLockNotHeld
+	}
+
+	span, ctx := l.startSpan(ctx, gtrace.TracerFromContext(ctx), "RedisLockRelease")
+	defer func() { finishSpan(span, err) }()
+
+	if l.stopC != nil {
+		close(l.stopC)
+		<-l.exitC
+		l.stopC = nil
+		l.exitC = nil
+	}
+
+	token := l.token
+	l.token = ""
+
+	var n int64
+	if n, err = releaseScript.Run(ctx, l.client, []string{l.key}, token).Int64(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) watchdog(lg *glog.Logger, tracer gtrace.Tracer, token string, stopC, exitC, lostC chan struct{}) {
+	defer close(exitC)
+
+	ticker := time.NewTicker(l.opts.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopC:
+			return
+		}
+
+		span, ctx := l.startSpan(context.Background(), tracer, "RedisLockExtend")
+		n, err := extendScript.Run(ctx, l.client, []string{l.key}, token, l.opts.ttl.Milliseconds()).Int64()
+		finishSpan(span, err)
+		if err != nil {
+			// Retry at next tick, the lock is still valid until ttl.
+			lg.Warn().Msg("rediswrap: extend lock error").String("key", l.key).Error("error", err).Fire()
+			continue
+		}
+		if n == 0 {
+			lg.Error().Msg("rediswrap: lock lost, it is not held by current owner").String("key", l.key).Fire()
+			close(lostC)
+			return
+		}
+	}
+}
+
+func (l *Lock) startSpan(ctx context.Context, tracer gtrace.Tracer, operationName string) (opentracing.Span, context.Context) {
+	var parentCtx opentracing.SpanContext
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		parentCtx = parent.Context()
+	}
+	span := tracer.StartSpan(
+		operationName,
+		opentracing.ChildOf(parentCtx),
+		ext.SpanKindRPCClient,
+		traceComponentTag,
+	)
+	span.LogFields(tracerLog.String("key", l.key))
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(tracerLog.Error(err))
+	}
+	span.Finish()
+}
@@ -0,0 +1,65 @@
+package rediswrap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LockAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	client, mr := newTestClient(t)
+
+	l1 := NewLock(client, "lock", WithLockWaitTimeout(0), WithLockWatchdog(false))
+	require.Nil(t, l1.Acquire(ctx))
+	require.NotEmpty(t, l1.Token())
+	require.Equal(t, ErrLockNotObtained, l1.Acquire(ctx))
+
+	l2 := NewLock(client, "lock", WithLockWaitTimeout(0), WithLockWatchdog(false))
+	require.Equal(t, ErrLockNotObtained, l2.Acquire(ctx))
+	require.Equal(t, ErrLockNotHeld, l2.Release(ctx))
+
+	require.Nil(t, l1.Release(ctx))
+	require.Empty(t, l1.Token())
+	require.False(t, mr.Exists("lock"))
+
+	require.Nil(t, l2.Acquire(ctx))
+	require.Nil(t, l2.Release(ctx))
+}
+
+func Test_LockAcquireNotBlockToken(t *testing.T) {
+	ctx := context.Background()
+	client, mr := newTestClient(t)
+	require.Nil(t, mr.Set("lock", "other"))
+
+	l := NewLock(client, "lock", WithLockWaitTimeout(time.Second), WithLockRetryInterval(time.Millisecond*10))
+	errC := make(chan error, 1)
+	go func() { errC <- l.Acquire(ctx) }()
+
+	time.Sleep(time.Millisecond * 50)
+	done := make(chan struct{})
+	go func() {
+		_ = l.Token()
+		_ = l.Lost()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Millisecond * 200):
+		t.Fatal("Token and Lost are blocked by Acquire")
+	}
+	require.Equal(t, ErrLockNotObtained, l.Acquire(ctx))
+
+	mr.Del("lock")
+	require.Nil(t, <-errC)
+	require.NotEmpty(t, l.Token())
+	require.Nil(t, l.Release(ctx))
+}
+
+func Test_WithLockTTL(t *testing.T) {
+	require.Panics(t, func() { WithLockTTL(time.Nanosecond) })
+	require.Panics(t, func() { WithLockTTL(0) })
+	require.NotPanics(t, func() { WithLockTTL(time.Millisecond) })
+}
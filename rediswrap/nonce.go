@@ -0,0 +1,22 @@
+package rediswrap
+
+import (
+	"context"
+	"time"
+)
+
+// NonceStore records the used nonces in redis, it implements the signer.NonceStore.
+type NonceStore struct {
+	client Client
+	prefix string
+}
+
+// NewNonceStore creates a NonceStore, the keys are stored with the prefix, eg: "nonce:apiserver:".
+func NewNonceStore(client Client, prefix string) *NonceStore {
+	return &NonceStore{client: client, prefix: prefix}
+}
+
+// SetIfAbsent records the nonce with ttl by SETNX, returns false if it already exists.
+func (s *NonceStore) SetIfAbsent(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+nonce, 1, ttl).Result()
+}
@@ -0,0 +1,124 @@
+package rediswrap
+
+import (
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const subsystem = "redis"
+
+var (
+	commandDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subsystem,
+			Name:      "command_duration_seconds",
+			Help:      "Histogram of latency of redis commands, pipelines are observed as cmd \"pipeline\".",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		},
+		[]string{"cmd"},
+	)
+	commandErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "command_errors_total",
+			Help:      "How many redis commands returns error except redis.Nil, including the commands in pipelines.",
+		},
+		[]string{"cmd"},
+	)
+
+	poolCollector = &poolStatsCollector{clients: make(map[string]Client)}
+)
+
+func init() {
+	prometheus.MustRegister(commandDuration)
+	prometheus.MustRegister(commandErrors)
+	prometheus.MustRegister(poolCollector)
+}
+
+var (
+	poolHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "pool_hits_total"),
+		"Number of times free connection was found in the pool.",
+		[]string{"client"}, nil,
+	)
+	poolMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "pool_misses_total"),
+		"Number of times free connection was NOT found in the pool.",
+		[]string{"client"}, nil,
+	)
+	poolTimeoutsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "pool_timeouts_total"),
+		"Number of times a wait timeout occurred.",
+		[]string{"client"}, nil,
+	)
+	poolTotalConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "pool_total_conns"),
+		"Number of total connections in the pool.",
+		[]string{"client"}, nil,
+	)
+	poolIdleConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "pool_idle_conns"),
+		"Number of idle connections in the pool.",
+		[]string{"client"}, nil,
+	)
+	poolStaleConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "pool_stale_conns_total"),
+		"Number of stale connections removed from the pool.",
+		[]string{"client"}, nil,
+	)
+)
+
+type poolStatser interface {
+	PoolStats() *redis.PoolStats
+}
+
+// poolStatsCollector implements prometheus.Collector to export the PoolStats of clients.
+type poolStatsCollector struct {
+	mu      sync.Mutex
+	clients map[string]Client
+}
+
+// add adds a client with name, the client of same name will be replaced.
+func (c *poolStatsCollector) add(name string, client Client) {
+	c.mu.Lock()
+	c.clients[name] = client
+	c.mu.Unlock()
+}
+
+// remove removes the client with name, it does nothing if the client has been replaced.
+func (c *poolStatsCollector) remove(name string, client Client) {
+	c.mu.Lock()
+	if c.clients[name] == client {
+		delete(c.clients, name)
+	}
+	c.mu.Unlock()
+}
+
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolHitsDesc
+	ch <- poolMissesDesc
+	ch <- poolTimeoutsDesc
+	ch <- poolTotalConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolStaleConnsDesc
+}
+
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, client := range c.clients {
+		p, ok := client.(poolStatser)
+		if !ok {
+			continue
+		}
+		stats := p.PoolStats()
+		ch <- prometheus.MustNewConstMetric(poolHitsDesc, prometheus.CounterValue, float64(stats.Hits), name)
+		ch <- prometheus.MustNewConstMetric(poolMissesDesc, prometheus.CounterValue, float64(stats.Misses), name)
+		ch <- prometheus.MustNewConstMetric(poolTimeoutsDesc, prometheus.CounterValue, float64(stats.Timeouts), name)
+		ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(stats.TotalConns), name)
+		ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stats.IdleConns), name)
+		ch <- prometheus.MustNewConstMetric(poolStaleConnsDesc, prometheus.CounterValue, float64(stats.StaleConns), name)
+	}
+}
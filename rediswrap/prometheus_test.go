@@ -0,0 +1,36 @@
+package rediswrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PoolCollectorRemove(t *testing.T) {
+	mr := miniredis.RunT(t)
+	name := StandaloneMode + "://" + mr.Addr()
+
+	client, err := NewRedisConn(context.Background(), &RedisConfig{Mode: StandaloneMode, StandaloneAddr: mr.Addr()})
+	require.Nil(t, err)
+	require.Nil(t, Ping(context.Background(), client))
+
+	poolCollector.mu.Lock()
+	require.Equal(t, client, poolCollector.clients[name])
+	poolCollector.mu.Unlock()
+
+	// The client of same name replaces the old one, closing the old one won't remove it.
+	client2, err := NewRedisConn(context.Background(), &RedisConfig{Mode: StandaloneMode, StandaloneAddr: mr.Addr()})
+	require.Nil(t, err)
+	require.Nil(t, client.Close())
+	poolCollector.mu.Lock()
+	require.Equal(t, client2, poolCollector.clients[name])
+	poolCollector.mu.Unlock()
+
+	require.Nil(t, client2.Close())
+	poolCollector.mu.Lock()
+	_, ok := poolCollector.clients[name]
+	poolCollector.mu.Unlock()
+	require.False(t, ok)
+}
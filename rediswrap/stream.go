@@ -0,0 +1,173 @@
+package rediswrap
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
+
+	"github.com/DataWorkbench/common/gtrace"
+)
+
+// streamTracePrefix is the prefix of fields that used to pass the trace span in stream messages.
+const streamTracePrefix = "_trace_"
+
+// StreamMessage type helpful for caller reference.
+type StreamMessage = redis.XMessage
+
+// StreamMessageHandler callback the consumed messages, these messages are come from the same stream in every calls.
+//
+// The function parameters:
+//   - ctx: The value includes traceId, glog.Logger and opentracing.Span(if tracer is enabled).
+//     And you can use the `<- ctx.Done()` to monitor whether StreamConsumerGroup is closed.
+//   - stream: The stream name of messages.
+//   - messages:
+//   - The `messages` always at least one message.
+//   - If `BatchMode` is false, the `messages` contains only one message.
+//   - If `BatchMode` is true, consumer will try to consume as many as possible at once.
+//
+// If non-nil error was returns, the consumer will be block and retry until successful.
+// The messages are acknowledged after the handler succeeds.
+type StreamMessageHandler func(ctx context.Context, stream string, messages []*StreamMessage) (err error)
+
+type StreamOption func(o *streamOptions)
+
+type streamOptions struct {
+	batchMode     bool
+	batchMax      int
+	retryInterval time.Duration
+	block         time.Duration
+	claimMinIdle  time.Duration
+	claimInterval time.Duration
+}
+
+func applyStreamOptions(options ...StreamOption) streamOptions {
+	opts := streamOptions{
+		batchMode:     false,
+		batchMax:      256,
+		retryInterval: time.Second * 5,
+		block:         time.Second * 2,
+		claimMinIdle:  time.Minute,
+		claimInterval: time.Second * 30,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithStreamBatchMode controls the consumer whether enable the `batchMode`. Same as kafka.WithBatchMode.
+func WithStreamBatchMode(ok bool) StreamOption {
+	return func(o *streamOptions) {
+		o.batchMode = ok
+	}
+}
+
+// WithStreamBatchMax sets the maximum messages of consumed at once if `batchMode` is enabled.
+// Defaults 256.
+func WithStreamBatchMax(max int) StreamOption {
+	return func(o *streamOptions) {
+		o.batchMax = max
+	}
+}
+
+// WithStreamRetryInterval sets the retry interval time when handler returns error.
+// Defaults 5s.
+func WithStreamRetryInterval(d time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.retryInterval = d
+	}
+}
+
+// WithStreamBlock sets the max duration of XREADGROUP blocking.
+// Defaults 2s.
+func WithStreamBlock(d time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.block = d
+	}
+}
+
+// WithStreamClaim sets the min idle time of pending messages to be reclaimed from dead consumers,
+// and the interval to check them. Defaults 1m and 30s. The interval <= 0 disables reclaiming.
+func WithStreamClaim(minIdle time.Duration, interval time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.claimMinIdle = minIdle
+		o.claimInterval = interval
+	}
+}
+
+// streamCarrier used to inject and extract span in stream message values.
+type streamCarrier map[string]interface{}
+
+// Set conforms to the opentracing.TextMapWriter interface.
+func (c streamCarrier) Set(key, val string) {
+	c[streamTracePrefix+key] = val
+}
+
+// ForeachKey conforms to the opentracing.TextMapReader interface.
+func (c streamCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, v := range c {
+		if !strings.HasPrefix(k, streamTracePrefix) {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if err := handler(strings.TrimPrefix(k, streamTracePrefix), s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamAdd appends a message to stream by XADD with the trace id and span.
+// The maxLen > 0 to trims the stream to approximately maxLen entries.
+func StreamAdd(ctx context.Context, client Client, stream string, values map[string]interface{}, maxLen int64) (id string, err error) {
+	tracer := gtrace.TracerFromContext(ctx)
+
+	var parentCtx opentracing.SpanContext
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		parentCtx = parent.Context()
+	}
+	span := tracer.StartSpan(
+		"StreamAdd",
+		opentracing.ChildOf(parentCtx),
+		ext.SpanKindProducer,
+		traceComponentTag,
+		opentracing.Tag{Key: "stream", Value: stream},
+	)
+	defer func() {
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogFields(tracerLog.Error(err))
+		}
+		span.Finish()
+	}()
+
+	carrier := make(streamCarrier, len(values)+4)
+	for k, v := range values {
+		carrier[k] = v
+	}
+	if tid := gtrace.IdFromContext(ctx); tid != "" {
+		carrier[gtrace.IdKey] = tid
+	}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		glog.FromContext(ctx).Error().Error("StreamAdd: tracer inject error", err).Fire()
+	}
+
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}(carrier),
+	}
+	if maxLen > 0 {
+		args.MaxLen = maxLen
+		args.Approx = true
+	}
+	return client.XAdd(opentracing.ContextWithSpan(ctx, span), args).Result()
+}
@@ -0,0 +1,410 @@
+package rediswrap
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/idgenerator"
+)
+
+// StreamConsumerGroup is a consumer of redis streams consumer group, it's API same as kafka.ConsumerGroup.
+//
+// The messages are acknowledged by XACK after the handler succeeds. The pending messages of the
+// dead consumers that idle more than the `claimMinIdle` are reclaimed by XPENDING and XCLAIM.
+type StreamConsumerGroup struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lp       *glog.Logger
+	client   Client
+	group    string
+	consumer string
+	handler  StreamMessageHandler
+	tracer   opentracing.Tracer
+	opts     streamOptions
+
+	// Initialize by inside.
+	idGen     *idgenerator.IDGenerator
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        *sync.WaitGroup
+}
+
+// NewStreamConsumerGroup creates a new StreamConsumerGroup. The consumer is the unique name of
+// the consumer in the group, eg: the hostname.
+func NewStreamConsumerGroup(ctx context.Context, client Client, group string, consumer string,
+	handler StreamMessageHandler, options ...StreamOption) *StreamConsumerGroup {
+	if group == "" {
+		panic("StreamConsumerGroup: group can not be empty")
+	}
+	if consumer == "" {
+		panic("StreamConsumerGroup: consumer can not be empty")
+	}
+	if handler == nil {
+		panic("StreamConsumerGroup: handler can not be nil")
+	}
+
+	// Create a new logger objects.
+	lp := glog.FromContext(ctx).Clone()
+	lp.WithFields().AddString("group", group)
+	lp.WithFields().AddString("consumer", consumer)
+
+	opts := applyStreamOptions(options...)
+	if !opts.batchMode {
+		opts.batchMax = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &StreamConsumerGroup{
+		ctx:      ctx,
+		cancel:   cancel,
+		lp:       lp,
+		client:   client,
+		group:    group,
+		consumer: consumer,
+		handler:  handler,
+		tracer:   gtrace.TracerFromContext(ctx),
+		opts:     opts,
+		idGen:    idgenerator.New(""),
+		closed:   make(chan struct{}),
+		wg:       new(sync.WaitGroup),
+	}
+}
+
+// Consume for start consumer in a loop, the group will be created if not exists.
+//
+// The loop will stop if the consumer closed or any unexpected errors happen. The errors of
+// reclaiming pending messages are logged and retried in the next claim interval.
+//
+// This function does not allow concurrent calls.
+func (c *StreamConsumerGroup) Consume(streams []string) (err error) {
+	if len(streams) == 0 {
+		panic("StreamConsumerGroup: must specified at least one stream")
+	}
+
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	lg := c.lp
+	lg.Debug().Msg("StreamConsumerGroup: loop up and running").Strings("streams", streams).Fire()
+
+	for _, stream := range streams {
+		if err = c.createGroup(stream); err != nil {
+			return
+		}
+	}
+
+	args := &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.consumer,
+		Streams:  make([]string, 0, len(streams)*2),
+		Count:    int64(c.opts.batchMax),
+		Block:    c.opts.block,
+	}
+	args.Streams = append(args.Streams, streams...)
+	for range streams {
+		args.Streams = append(args.Streams, ">")
+	}
+
+	// Reclaim the pending messages at startup and then every claimInterval.
+	lastClaim := time.Time{}
+
+LOOP:
+	for {
+		// Check if the consumer group was closed.
+		select {
+		case <-c.closed:
+			break LOOP
+		default:
+		}
+
+		if c.opts.claimInterval > 0 && time.Since(lastClaim) >= c.opts.claimInterval {
+			for _, stream := range streams {
+				if err = c.claim(stream); err != nil {
+					if c.ctx.Err() != nil {
+						break LOOP
+					}
+					// The unclaimed messages are still pending, retry in the next interval.
+					err = nil
+				}
+			}
+			lastClaim = time.Now()
+		}
+
+		var results []redis.XStream
+		results, err = c.client.XReadGroup(c.ctx, args).Result()
+		if err == redis.Nil {
+			// No messages in block time.
+			err = nil
+			continue LOOP
+		}
+		if err != nil {
+			break LOOP
+		}
+
+		for i := range results {
+			if err = c.dispatch(results[i].Stream, results[i].Messages); err != nil {
+				break LOOP
+			}
+		}
+	}
+
+	if err != nil && err != context.Canceled {
+		lg.Error().Error("StreamConsumerGroup: consumer exited with error", err).Strings("streams", streams).Fire()
+		return
+	}
+	lg.Debug().Msg("StreamConsumerGroup: consumer was closed, stops").Fire()
+	return nil
+}
+
+// Close stops the consumer and wait for the consume loop exits, Calls before exit the app.
+// It's safe to call Close many times.
+func (c *StreamConsumerGroup) Close() (err error) {
+	if c == nil {
+		return
+	}
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.cancel()
+
+		c.lp.Debug().Msg("StreamConsumerGroup: wait for the consumer to close").Fire()
+		c.wg.Wait()
+
+		c.lp.Debug().Msg("StreamConsumerGroup: consumer successful closed").Fire()
+		_ = c.lp.Close()
+	})
+	return
+}
+
+// createGroup creates the consumer group of stream from the beginning, ignore if it already exists.
+func (c *StreamConsumerGroup) createGroup(stream string) error {
+	err := c.client.XGroupCreateMkStream(c.ctx, stream, c.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		c.lp.Error().Error("StreamConsumerGroup: create group error", err).String("stream", stream).Fire()
+		return err
+	}
+	return nil
+}
+
+// claim transfers the pending messages that idle more than claimMinIdle to the current consumer and process them.
+//
+// It uses XPENDING and XCLAIM instead of XAUTOCLAIM, the go-redis v8 can't parse the reply of
+// XAUTOCLAIM since redis 7.0.
+func (c *StreamConsumerGroup) claim(stream string) (err error) {
+	args := &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  c.group,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(c.opts.batchMax),
+	}
+	for {
+		var pending []redis.XPendingExt
+		if pending, err = c.client.XPendingExt(c.ctx, args).Result(); err != nil {
+			c.lp.Error().Error("StreamConsumerGroup: list pending messages error", err).String("stream", stream).Fire()
+			return
+		}
+
+		ids := make([]string, 0, len(pending))
+		for i := range pending {
+			if pending[i].Idle >= c.opts.claimMinIdle {
+				ids = append(ids, pending[i].ID)
+			}
+		}
+		if len(ids) > 0 {
+			var messages []redis.XMessage
+			messages, err = c.client.XClaim(c.ctx, &redis.XClaimArgs{
+				Stream:   stream,
+				Group:    c.group,
+				Consumer: c.consumer,
+				MinIdle:  c.opts.claimMinIdle,
+				Messages: ids,
+			}).Result()
+			if err != nil {
+				c.lp.Error().Error("StreamConsumerGroup: claim pending messages error", err).String("stream", stream).Fire()
+				return
+			}
+			// The messages that claimed by others in the meantime are not returned.
+			if len(messages) > 0 {
+				c.lp.Info().Msg("StreamConsumerGroup: reclaimed pending messages").String("stream", stream).Int("num", len(messages)).Fire()
+				if err = c.dispatch(stream, messages); err != nil {
+					return
+				}
+			}
+		}
+
+		if len(pending) < c.opts.batchMax {
+			return
+		}
+		args.Start = nextStreamID(pending[len(pending)-1].ID)
+	}
+}
+
+// nextStreamID returns the smallest stream id that greater than id, it used as the inclusive start
+// of range since the exclusive range "(id" requires redis >= 6.2.
+func nextStreamID(id string) string {
+	ms, seq := id, "0"
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		ms, seq = id[:i], id[i+1:]
+	}
+	n, _ := strconv.ParseUint(seq, 10, 64)
+	if n == math.MaxUint64 {
+		m, _ := strconv.ParseUint(ms, 10, 64)
+		return strconv.FormatUint(m+1, 10) + "-0"
+	}
+	return ms + "-" + strconv.FormatUint(n+1, 10)
+}
+
+// dispatch splits the messages into batches by batchMax, process and acknowledge them.
+func (c *StreamConsumerGroup) dispatch(stream string, messages []redis.XMessage) (err error) {
+	batch := make([]*StreamMessage, 0, c.opts.batchMax)
+	for i := 0; i < len(messages); i += len(batch) {
+		batch = batch[:0]
+		for j := i; j < len(messages) && len(batch) < c.opts.batchMax; j++ {
+			batch = append(batch, &messages[j])
+		}
+
+		if err = c.process(c.ctx, stream, batch); err != nil {
+			return
+		}
+
+		ids := make([]string, len(batch))
+		for j, msg := range batch {
+			ids[j] = msg.ID
+		}
+		if err = c.client.XAck(c.ctx, stream, c.group, ids...).Err(); err != nil {
+			c.lp.Error().Error("StreamConsumerGroup: ack messages error", err).String("stream", stream).Fire()
+			return
+		}
+	}
+	return
+}
+
+// process the received messages, with logger, trace id, retry and span.
+func (c *StreamConsumerGroup) process(ctx context.Context, stream string, messages []*StreamMessage) (err error) {
+	// create a new logger object.
+	nl := c.lp.Clone()
+	nl.WithFields().AddString("stream", stream)
+	nl.WithFields().AddString("handler", runtime.FuncForPC(reflect.ValueOf(c.handler).Pointer()).Name())
+
+	tid := c.getTraceId(ctx, messages)
+	if tid != "" {
+		ctx = gtrace.ContextWithId(ctx, tid)
+		nl.WithFields().AddString("tid", tid)
+	}
+	ctx = glog.WithContext(ctx, nl)
+
+	err = c.retryHandler(ctx, stream, messages)
+
+	_ = nl.Close()
+	return
+}
+
+// Retry until the messages handle successes.
+func (c *StreamConsumerGroup) retryHandler(ctx context.Context, stream string, messages []*StreamMessage) (err error) {
+	lg := glog.FromContext(ctx)
+
+	lg.Debug().Msg("StreamConsumerGroup: received messages").
+		String("first_id", messages[0].ID).
+		String("last_id", messages[len(messages)-1].ID).
+		Int("num", len(messages)).
+		Fire()
+
+	err = c.spanHandler(ctx, stream, messages)
+	if err != nil && err != context.Canceled {
+		// Retry until callback successful.
+		retries := 0
+		ticker := time.NewTicker(c.opts.retryInterval)
+
+	LOOP:
+		for {
+			lg.Error().Error("StreamConsumerGroup: handle messages error", err).Int("retrying", retries).Fire()
+
+			retries++
+			select {
+			case <-ticker.C:
+				err = c.spanHandler(ctx, stream, messages)
+				if err != nil && err != context.Canceled {
+					continue LOOP
+				}
+				break LOOP
+			case <-ctx.Done():
+				err = ctx.Err()
+				break LOOP
+			}
+		}
+
+		ticker.Stop()
+	}
+	return
+}
+
+// Open a trace span. References from message values and `context.Context`.
+func (c *StreamConsumerGroup) spanHandler(ctx context.Context, stream string, messages []*StreamMessage) (err error) {
+	var parentSpan opentracing.SpanContext
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		parentSpan = span.Context()
+	}
+
+	span := c.tracer.StartSpan(
+		"ConsumeStreamMessage",
+		opentracing.ChildOf(c.spanFromMessage(ctx, messages)),
+		opentracing.ChildOf(parentSpan),
+		ext.SpanKindConsumer,
+		traceComponentTag,
+		opentracing.Tags{"stream": stream, "id": messages[len(messages)-1].ID, "message.num": len(messages)},
+	)
+
+	// handler.
+	if err = c.handler(opentracing.ContextWithSpan(ctx, span), stream, messages); err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(tracerLog.Error(err))
+	}
+
+	// finish span.
+	span.Finish()
+	return
+}
+
+func (c *StreamConsumerGroup) getTraceId(ctx context.Context, messages []*StreamMessage) (tid string) {
+	// Only get the trace id in first message.
+	if v, ok := messages[0].Values[gtrace.IdKey].(string); ok && v != "" {
+		return v
+	}
+
+	// No trace id in message values, check whether contains in Context.
+	tid = gtrace.IdFromContext(ctx)
+
+	// Generate a new trace id if not found in any where.
+	if tid == "" {
+		tid, _ = c.idGen.Take()
+	}
+	return
+}
+
+// Returns the parent span of producer from the first message.
+func (c *StreamConsumerGroup) spanFromMessage(ctx context.Context, messages []*StreamMessage) opentracing.SpanContext {
+	producerSpan, err := c.tracer.Extract(opentracing.TextMap, streamCarrier(messages[0].Values))
+	if err != nil {
+		lg := glog.FromContext(ctx)
+		// No parent span in message values. We can ignore this error.
+		if err == opentracing.ErrSpanContextNotFound {
+			lg.Debug().Msg("StreamConsumerGroup: SpanContext not found in message values").Fire()
+		} else {
+			lg.Error().Error("StreamConsumerGroup: extract SpanContext from message values error", err).Fire()
+		}
+	}
+	return producerSpan
+}
@@ -0,0 +1,241 @@
+package rediswrap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+// streamRecorder records the messages received by handler.
+type streamRecorder struct {
+	mu      sync.Mutex
+	batches [][]string
+	notify  chan struct{}
+}
+
+func newStreamRecorder() *streamRecorder {
+	return &streamRecorder{notify: make(chan struct{}, 1024)}
+}
+
+func (r *streamRecorder) handler(ctx context.Context, stream string, messages []*StreamMessage) error {
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.Values["v"].(string)
+	}
+	r.mu.Lock()
+	r.batches = append(r.batches, ids)
+	r.mu.Unlock()
+	r.notify <- struct{}{}
+	return nil
+}
+
+func (r *streamRecorder) wait(t *testing.T, n int) [][]string {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.notify:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d batches received", i, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches
+}
+
+func newStreamTestContext() context.Context {
+	return glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+}
+
+// startConsumer runs the consume loop in background, it is closed when test finished.
+func startConsumer(t *testing.T, c *StreamConsumerGroup, streams ...string) {
+	exitC := make(chan error, 1)
+	go func() { exitC <- c.Consume(streams) }()
+	t.Cleanup(func() {
+		require.Nil(t, c.Close())
+		require.Nil(t, <-exitC)
+	})
+}
+
+func addTestMessages(t *testing.T, ctx context.Context, client Client, stream string, values ...string) {
+	for _, v := range values {
+		_, err := StreamAdd(ctx, client, stream, map[string]interface{}{"v": v}, 0)
+		require.Nil(t, err)
+	}
+}
+
+func pendingCount(t *testing.T, ctx context.Context, client Client, stream string, group string) int64 {
+	pending, err := client.XPending(ctx, stream, group).Result()
+	require.Nil(t, err)
+	return pending.Count
+}
+
+func Test_StreamConsumerAckAfterSuccess(t *testing.T) {
+	ctx := newStreamTestContext()
+	client, _ := newTestClient(t)
+
+	var pendingInHandler int64 = -1
+	done := make(chan struct{})
+	c := NewStreamConsumerGroup(ctx, client, "g1", "c1", func(ctx context.Context, stream string, messages []*StreamMessage) error {
+		atomic.StoreInt64(&pendingInHandler, pendingCount(t, ctx, client, stream, "g1"))
+		close(done)
+		return nil
+	}, WithStreamBlock(50*time.Millisecond))
+	startConsumer(t, c, "s1")
+
+	addTestMessages(t, ctx, client, "s1", "m1")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	// The message is pending until the handler returns.
+	require.Equal(t, int64(1), atomic.LoadInt64(&pendingInHandler))
+	require.Eventually(t, func() bool { return pendingCount(t, ctx, client, "s1", "g1") == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_StreamConsumerRetry(t *testing.T) {
+	ctx := newStreamTestContext()
+	client, _ := newTestClient(t)
+
+	var calls int32
+	done := make(chan struct{})
+	c := NewStreamConsumerGroup(ctx, client, "g1", "c1", func(ctx context.Context, stream string, messages []*StreamMessage) error {
+		require.Equal(t, "m1", messages[0].Values["v"])
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("handler failed")
+		}
+		close(done)
+		return nil
+	}, WithStreamBlock(50*time.Millisecond), WithStreamRetryInterval(10*time.Millisecond))
+	startConsumer(t, c, "s1")
+
+	addTestMessages(t, ctx, client, "s1", "m1")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not retried")
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	require.Eventually(t, func() bool { return pendingCount(t, ctx, client, "s1", "g1") == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_StreamConsumerBatchMode(t *testing.T) {
+	ctx := newStreamTestContext()
+	client, _ := newTestClient(t)
+
+	require.Nil(t, client.XGroupCreateMkStream(ctx, "s1", "g1", "0").Err())
+	addTestMessages(t, ctx, client, "s1", "m1", "m2", "m3", "m4", "m5")
+
+	r := newStreamRecorder()
+	c := NewStreamConsumerGroup(ctx, client, "g1", "c1", r.handler,
+		WithStreamBlock(50*time.Millisecond), WithStreamBatchMode(true), WithStreamBatchMax(2))
+	startConsumer(t, c, "s1")
+
+	require.Equal(t, [][]string{{"m1", "m2"}, {"m3", "m4"}, {"m5"}}, r.wait(t, 3))
+	require.Eventually(t, func() bool { return pendingCount(t, ctx, client, "s1", "g1") == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_StreamConsumerReclaim(t *testing.T) {
+	ctx := newStreamTestContext()
+	client, mr := newTestClient(t)
+	now := time.Now()
+	mr.SetTime(now)
+
+	// The dead consumer reads the messages but never acknowledges them.
+	require.Nil(t, client.XGroupCreateMkStream(ctx, "s1", "g1", "0").Err())
+	addTestMessages(t, ctx, client, "s1", "m1", "m2", "m3")
+	_, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g1", Consumer: "dead", Streams: []string{"s1", ">"}}).Result()
+	require.Nil(t, err)
+
+	// The message that not idle long enough is not reclaimed.
+	mr.SetTime(now.Add(2 * time.Minute))
+	addTestMessages(t, ctx, client, "s1", "m4")
+	_, err = client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g1", Consumer: "dead", Streams: []string{"s1", ">"}}).Result()
+	require.Nil(t, err)
+
+	r := newStreamRecorder()
+	c := NewStreamConsumerGroup(ctx, client, "g1", "c1", r.handler,
+		WithStreamBlock(50*time.Millisecond), WithStreamClaim(time.Minute, time.Hour))
+	startConsumer(t, c, "s1")
+
+	require.Equal(t, [][]string{{"m1"}, {"m2"}, {"m3"}}, r.wait(t, 3))
+	require.Eventually(t, func() bool { return pendingCount(t, ctx, client, "s1", "g1") == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	pending, err := client.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: "s1", Group: "g1", Start: "-", End: "+", Count: 10}).Result()
+	require.Nil(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, "dead", pending[0].Consumer)
+}
+
+// failHook fails the first n commands with the name.
+type failHook struct {
+	name string
+	n    int32
+}
+
+func (h *failHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == h.name && atomic.AddInt32(&h.n, -1) >= 0 {
+		return ctx, errors.New("injected error")
+	}
+	return ctx, nil
+}
+
+func (h *failHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error { return nil }
+
+func (h *failHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *failHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error { return nil }
+
+func Test_StreamConsumerClaimErrorNotFatal(t *testing.T) {
+	ctx := newStreamTestContext()
+	client, _ := newTestClient(t)
+
+	require.Nil(t, client.XGroupCreateMkStream(ctx, "s1", "g1", "0").Err())
+	addTestMessages(t, ctx, client, "s1", "m1")
+	_, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g1", Consumer: "dead", Streams: []string{"s1", ">"}}).Result()
+	require.Nil(t, err)
+
+	for _, name := range []string{"xpending", "xclaim"} {
+		client.AddHook(&failHook{name: name, n: 1})
+	}
+
+	r := newStreamRecorder()
+	c := NewStreamConsumerGroup(ctx, client, "g1", "c1", r.handler,
+		WithStreamBlock(20*time.Millisecond), WithStreamClaim(0, 20*time.Millisecond))
+	startConsumer(t, c, "s1")
+
+	// The claim fails twice and the consumer still running, then the message reclaimed.
+	require.Equal(t, [][]string{{"m1"}}, r.wait(t, 1))
+	addTestMessages(t, ctx, client, "s1", "m2")
+	require.Equal(t, [][]string{{"m1"}, {"m2"}}, r.wait(t, 1))
+}
+
+func Test_StreamConsumerCloseTwice(t *testing.T) {
+	client, _ := newTestClient(t)
+	c := NewStreamConsumerGroup(newStreamTestContext(), client, "g1", "c1", newStreamRecorder().handler,
+		WithStreamBlock(20*time.Millisecond))
+
+	exitC := make(chan error, 1)
+	go func() { exitC <- c.Consume([]string{"s1"}) }()
+	require.Eventually(t, func() bool { return client.Exists(context.Background(), "s1").Val() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	require.Nil(t, c.Close())
+	require.Nil(t, c.Close())
+	require.Nil(t, <-exitC)
+}
+
+func Test_NextStreamID(t *testing.T) {
+	require.Equal(t, "1526919030474-56", nextStreamID("1526919030474-55"))
+	require.Equal(t, "1526919030475-0", nextStreamID("1526919030474-18446744073709551615"))
+	require.Equal(t, "1526919030474-1", nextStreamID("1526919030474"))
+}
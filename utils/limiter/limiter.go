@@ -0,0 +1,18 @@
+package limiter
+
+import (
+	"context"
+)
+
+// Limiter limits the rate or concurrency of requests by key, eg: workspace id or user id.
+//
+// It is implemented by Local, getcd.Semaphore, rediswrap.TokenBucketLimiter and rediswrap.SlidingWindowLimiter.
+type Limiter interface {
+	// Acquire tries to take a permit for key without blocking.
+	// It returns qerror.TooManyRequests if the limit is exceeded.
+	// The release must be called after the request done if err is nil; it's a no-op for rate limiters.
+	Acquire(ctx context.Context, key string) (release func(), err error)
+}
+
+// Noop is a release func that do nothing.
+func Noop() {}
@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+var _ Limiter = (*Local)(nil)
+
+// Local limits the number of concurrent holders of a key in the current process.
+// It's useful for tests or a service that runs a single instance.
+type Local struct {
+	mu      sync.Mutex
+	limit   int
+	holders map[string]int
+}
+
+// NewLocal creates a Local that allows at most limit holders for each key.
+func NewLocal(limit int) *Local {
+	if limit <= 0 {
+		panic("Local: limit must be greater than 0")
+	}
+	return &Local{
+		limit:   limit,
+		holders: make(map[string]int),
+	}
+}
+
+// Acquire tries to take a permit of key without blocking. It implements Limiter.
+// Returns qerror.TooManyRequests if there are already limit holders.
+func (l *Local) Acquire(ctx context.Context, key string) (release func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders[key] >= l.limit {
+		return nil, qerror.TooManyRequests.Format(key)
+	}
+	l.holders[key]++
+
+	var once sync.Once
+	return func() { once.Do(func() { l.release(key) }) }, nil
+}
+
+func (l *Local) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders[key]--; l.holders[key] <= 0 {
+		delete(l.holders, key)
+	}
+}
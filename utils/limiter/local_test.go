@@ -0,0 +1,46 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+func Test_LocalAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(2)
+
+	release1, err := l.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	release2, err := l.Acquire(ctx, "w1")
+	require.Nil(t, err)
+
+	release, err := l.Acquire(ctx, "w1")
+	require.Nil(t, release)
+	require.NotNil(t, err)
+	require.Equal(t, qerror.TooManyRequests.Code(), err.(*qerror.Error).Code())
+
+	// The keys are limited separately.
+	release3, err := l.Acquire(ctx, "w2")
+	require.Nil(t, err)
+	release3()
+
+	// Release more than once must not free another permit.
+	release1()
+	release1()
+	release1, err = l.Acquire(ctx, "w1")
+	require.Nil(t, err)
+	_, err = l.Acquire(ctx, "w1")
+	require.NotNil(t, err)
+
+	release1()
+	release2()
+	require.Len(t, l.holders, 0)
+}
+
+func Test_NewLocalInvalidLimit(t *testing.T) {
+	require.PanicsWithValue(t, "Local: limit must be greater than 0", func() { NewLocal(0) })
+}
@@ -1,112 +1,126 @@
 package signer
 
-// FIXME: remove this file
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 
-//import (
-//	"bytes"
-//	"crypto/hmac"
-//	"crypto/md5"
-//	"crypto/sha256"
-//	"encoding/base64"
-//	"encoding/hex"
-//	"io/ioutil"
-//	"net/http"
-//	"net/url"
-//	"sort"
-//	"strings"
-//
-//	"github.com/DataWorkbench/gproto/xgo/types/pbrequest"
-//)
-//
-//type ConsoleSigner struct {
-//	AccessKeyId     string
-//	SecretAccessKey string
-//	Zone            string
-//}
-//
-//func (s *ConsoleSigner) Init(accessKeyID string, secretAccessKey string, zone string) {
-//	s.AccessKeyId = accessKeyID
-//	s.SecretAccessKey = secretAccessKey
-//	s.Zone = zone
-//}
-//
-//func (s *ConsoleSigner) CalculateSignature(req *pbrequest.ValidateRequestSignature) string {
-//	m := md5.New()
-//	m.Write([]byte(req.ReqBody))
-//	stringToSign := strings.ToUpper(req.ReqMethod) + "\n" + req.ReqPath + "\n" + req.ReqQueryString + "\n" + hex.EncodeToString(m.Sum(nil))
-//	h := hmac.New(sha256.New, []byte(s.SecretAccessKey))
-//	h.Write([]byte(stringToSign))
-//	signature := strings.TrimSpace(base64.StdEncoding.EncodeToString(h.Sum(nil)))
-//	signature = strings.Replace(signature, " ", "+", -1)
-//	signature = url.QueryEscape(signature)
-//	return signature
-//}
-//
-//func (s *ConsoleSigner) buildReqBody(request *http.Request) string {
-//	var data string
-//	if request.Method == "GET" || request.Method == "HEAD" || request.Method == "DELETE" {
-//		data = "null"
-//	} else {
-//		var bodyBytes []byte
-//		if request.Body != nil {
-//			bodyBytes, _ = ioutil.ReadAll(request.Body)
-//		}
-//		request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-//		data = string(bodyBytes)
-//	}
-//	return data
-//}
-//func (s *ConsoleSigner) buildReqUrl(request *http.Request) string {
-//	if s.Zone != "" {
-//		return "/" + s.Zone + request.URL.Path + "/"
-//	}
-//	return request.URL.Path + "/"
-//}
-//
-//func (s *ConsoleSigner) BuildValidateSignatureRequest(request *http.Request) *pbrequest.ValidateRequestSignature {
-//	reqBody := s.buildReqBody(request)
-//	params := request.URL.Query()
-//	keys := []string{}
-//	for key := range params {
-//		keys = append(keys, key)
-//	}
-//	sort.Strings(keys)
-//	parts := []string{}
-//	var signature string
-//	var accessKeyID string
-//	for _, key := range keys {
-//		values := params[key]
-//		if len(values) > 0 {
+	"github.com/DataWorkbench/common/qerror"
+)
+
+const (
+	// ConsoleUserAgent is the User-Agent of requests sent by web console.
+	ConsoleUserAgent = "QingCloud-Web-Console"
+	// ConsoleScheme is the scheme name of ConsoleSigner, the console doesn't send it in requests.
+	ConsoleScheme = "Console-HMAC-SHA256"
+)
+
+// ConsoleSigner implements the Signer that compatible with the web console.
 //
-//			if key == "signature" {
-//				signature = values[0]
-//				continue
-//			}
-//			if key == "access_key_id" {
-//				accessKeyID = values[0]
-//			}
-//			if values[0] != "" {
-//				value := strings.TrimSpace(strings.Join(values, ""))
-//				value = url.QueryEscape(value)
-//				value = strings.Replace(value, "+", "%20", -1)
-//				parts = append(parts, key+"="+value)
-//			} else {
-//				parts = append(parts, key+"=")
-//			}
-//		} else {
-//			parts = append(parts, key+"=")
-//		}
-//	}
+// The access key id and signature are sent in query "access_key_id" and "signature", and the
+// string to sign is:
 //
-//	urlParams := strings.Join(parts, "&")
+//	METHOD\n
+//	/<zone>/<path>/\n
+//	sorted and escaped query without signature\n
+//	hex md5 of body, the body is "null" for GET, HEAD and DELETE
 //
-//	return &pbrequest.ValidateRequestSignature{
-//		ReqMethod:      request.Method,
-//		ReqPath:        s.buildReqUrl(request),
-//		ReqSignature:   signature,
-//		ReqQueryString: urlParams,
-//		ReqAccessKeyId: accessKeyID,
-//		ReqBody:        reqBody,
-//		ReqUserAgent:   ConsoleUserAgent,
-//	}
-//}
+// NOTICE: The console requests don't have date, so they never expire and the replays can't be
+// detected. It's not in DefaultRegistry, register it explicitly for the trusted console gateway.
+type ConsoleSigner struct {
+	// Zone is the prefix of the signed path, optional.
+	Zone string
+}
+
+// Scheme for implements Signer.
+func (s *ConsoleSigner) Scheme() string {
+	return ConsoleScheme
+}
+
+// Sign for implements Signer.
+func (s *ConsoleSigner) Sign(req *Request, accessKeyId string, secretAccessKey string) error {
+	req.Header.Set("User-Agent", ConsoleUserAgent)
+	req.Query.Del("signature")
+	req.Query.Set("access_key_id", accessKeyId)
+	req.Query.Set("signature", s.signature(req, secretAccessKey))
+	return nil
+}
+
+// Parse for implements Signer.
+func (s *ConsoleSigner) Parse(req *Request) (accessKeyId string, signature string, err error) {
+	accessKeyId = req.Query.Get("access_key_id")
+	signature = req.Query.Get("signature")
+	if accessKeyId == "" || signature == "" {
+		err = qerror.MissingAuthorizationHeader
+		return
+	}
+	return
+}
+
+// Verify for implements Signer.
+func (s *ConsoleSigner) Verify(req *Request, secretAccessKey string, expires int) (err error) {
+	var signature string
+	if _, signature, err = s.Parse(req); err != nil {
+		return
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(req, secretAccessKey))) {
+		return qerror.SignatureNotMatch
+	}
+	return nil
+}
+
+func (s *ConsoleSigner) signature(req *Request, secretAccessKey string) string {
+	stringToSign := strings.ToUpper(req.Method) + "\n" + s.signPath(req) + "\n" + s.canonicalQuery(req.Query) + "\n" + s.bodyMD5(req)
+	h := hmac.New(sha256.New, []byte(secretAccessKey))
+	h.Write([]byte(stringToSign))
+	signature := strings.TrimSpace(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	signature = strings.Replace(signature, " ", "+", -1)
+	return url.QueryEscape(signature)
+}
+
+func (s *ConsoleSigner) signPath(req *Request) string {
+	if s.Zone != "" {
+		return "/" + s.Zone + req.Path + "/"
+	}
+	return req.Path + "/"
+}
+
+func (s *ConsoleSigner) canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if key != "signature" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		if len(values) == 0 || values[0] == "" {
+			parts = append(parts, key+"=")
+			continue
+		}
+		value := strings.TrimSpace(strings.Join(values, ""))
+		value = url.QueryEscape(value)
+		value = strings.Replace(value, "+", "%20", -1)
+		parts = append(parts, key+"="+value)
+	}
+	return strings.Join(parts, "&")
+}
+
+func (s *ConsoleSigner) bodyMD5(req *Request) string {
+	body := req.Body
+	switch strings.ToUpper(req.Method) {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		body = []byte("null")
+	}
+	sum := md5.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -7,10 +7,42 @@ import (
 	"github.com/DataWorkbench/common/qerror"
 )
 
+// DateFormat is the format of header "Date" or "X-Date".
+const DateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 // CheckRequestExpired check the header "Date" or "X-Date" is valid.
 // And check the signature whether expired;
 // expires is the expire time seconds
 func CheckRequestExpired(headers http.Header, expires int) (err error) {
+	var reqTime time.Time
+	if reqTime, err = parseRequestDate(headers); err != nil {
+		return
+	}
+	// To prevent replay attack, each signature is just valid within expires
+	if time.Now().UTC().Sub(reqTime).Seconds() > float64(expires) {
+		err = qerror.ExpiredSignature
+		return
+	}
+	return
+}
+
+// checkRequestDate rejects the date that is out of the expires, both in the past and in the future.
+func checkRequestDate(headers http.Header, expires int) error {
+	reqTime, err := parseRequestDate(headers)
+	if err != nil {
+		return err
+	}
+	diff := time.Now().UTC().Sub(reqTime)
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > time.Duration(expires)*time.Second {
+		return qerror.ExpiredSignature
+	}
+	return nil
+}
+
+func parseRequestDate(headers http.Header) (reqTime time.Time, err error) {
 	date := headers.Get("X-Date")
 	if date == "" {
 		date = headers.Get("Date")
@@ -19,16 +51,10 @@ func CheckRequestExpired(headers http.Header, expires int) (err error) {
 		err = qerror.MissingDateHeader
 		return
 	}
-	var reqTime time.Time
-	reqTime, err = time.ParseInLocation("Mon, 02 Jan 2006 15:04:05 GMT", date, time.UTC)
+	reqTime, err = time.ParseInLocation(DateFormat, date, time.UTC)
 	if err != nil {
 		err = qerror.InvalidDateHeader
 		return
 	}
-	// To prevent replay attack, each signature is just valid within expires
-	if time.Now().UTC().Sub(reqTime).Seconds() > float64(expires) {
-		err = qerror.ExpiredSignature
-		return
-	}
 	return
 }
@@ -1,35 +1,128 @@
 package signer
 
-// FIXME: remove this file
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 
-//import (
-//	"net/http"
-//
-//	"github.com/DataWorkbench/gproto/xgo/types/pbrequest"
-//)
-//
-//const (
-//	ConsoleUserAgent = "QingCloud-Web-Console"
-//	AppUserAgent     = "App"
-//	SdkUserAgent     = "SDK"
-//)
-//
-//type Signer interface {
-//	CalculateSignature(req *pbrequest.ValidateRequestSignature) string
-//	BuildValidateSignatureRequest(request *http.Request) *pbrequest.ValidateRequestSignature
-//	Init(accessKeyID string, secretAccessKey string, zone string)
-//}
-//
-//func CreateSigner(userAgent string) Signer {
-//	switch userAgent {
-//	case ConsoleUserAgent:
-//		return &ConsoleSigner{}
-//	case AppUserAgent:
-//		return &AppSigner{}
-//	case SdkUserAgent:
-//		return &SdkSigner{}
-//	default:
-//		return &ConsoleSigner{}
-//	}
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// Request is the parts of a request that used to sign, it's shared by HTTP and gRPC.
+// The Sign modifies the Header and Query in place.
+type Request struct {
+	Method string
+	Host   string
+	Path   string
+	Header http.Header
+	Query  url.Values
+	Body   []byte
+}
+
+// NewRequest creates a Request from http.Request, the body must be read by caller.
+// The Header is shared with the http.Request, and the Query is a copy of URL.RawQuery.
+func NewRequest(r *http.Request, body []byte) *Request {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return &Request{
+		Method: r.Method,
+		Host:   host,
+		Path:   r.URL.Path,
+		Header: r.Header,
+		Query:  r.URL.Query(),
+		Body:   body,
+	}
+}
+
+// Signer signs and verifies the requests of an authentication scheme.
+type Signer interface {
+	// Scheme returns the unique name of the authentication scheme, eg: "SV1-HMAC-SHA256".
+	// It's the prefix of header "Authorization" if the signer uses it.
+	Scheme() string
+	// Sign signs the request in place with the access key.
+	Sign(req *Request, accessKeyId string, secretAccessKey string) error
+	// Parse returns the access key id and signature of request.
+	Parse(req *Request) (accessKeyId string, signature string, err error)
+	// Verify checks the request is signed by the secret and not expired, the expires is in seconds.
+	Verify(req *Request, secretAccessKey string, expires int) error
+}
+
+// Registry finds the Signer of request by the scheme of header "Authorization" or by the User-Agent.
+type Registry struct {
+	mu         sync.RWMutex
+	schemes    map[string]Signer
+	userAgents map[string]Signer
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		schemes:    make(map[string]Signer),
+		userAgents: make(map[string]Signer),
+	}
+}
+
+// Register adds the signer by its scheme, and by the user agents for the clients that
+// don't send the header "Authorization". The signer with the same scheme is replaced.
+func (r *Registry) Register(s Signer, userAgents ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemes[s.Scheme()] = s
+	for _, ua := range userAgents {
+		r.userAgents[ua] = s
+	}
+}
+
+// Get returns the signer by scheme.
+func (r *Registry) Get(scheme string) (Signer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.schemes[scheme]
+	return s, ok
+}
+
+// Lookup returns the signer of request. The scheme of header "Authorization" takes precedence
+// over the User-Agent. Returns qerror.UnsupportedSignatureVersion if the scheme is unknown.
+func (r *Registry) Lookup(req *Request) (Signer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		scheme := authorization
+		if i := strings.Index(authorization, " "); i > 0 {
+			scheme = authorization[:i]
+		}
+		if s, ok := r.schemes[scheme]; ok {
+			return s, nil
+		}
+		return nil, qerror.UnsupportedSignatureVersion
+	}
+	if s, ok := r.userAgents[req.Header.Get("User-Agent")]; ok {
+		return s, nil
+	}
+	return nil, qerror.MissingAuthorizationHeader
+}
+
+// DefaultRegistry contains the SV1Signer and SV4Signer.
 //
-//}
+// The ConsoleSigner is not in it because the console requests never expire and can be replayed,
+// register it into a new Registry and set by WithRegistry if the console requests are trusted.
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	DefaultRegistry.Register(&SV1Signer{})
+	DefaultRegistry.Register(NewSV4Signer())
+}
+
+// Register adds the signer into DefaultRegistry.
+func Register(s Signer, userAgents ...string) {
+	DefaultRegistry.Register(s, userAgents...)
+}
+
+// Lookup returns the signer of request from DefaultRegistry.
+func Lookup(req *Request) (Signer, error) {
+	return DefaultRegistry.Lookup(req)
+}
@@ -0,0 +1,118 @@
+package signer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+func Test_RegistryLookup(t *testing.T) {
+	lookup := func(header http.Header) (string, error) {
+		s, err := Lookup(&Request{Header: header})
+		if err != nil {
+			return "", err
+		}
+		return s.Scheme(), nil
+	}
+
+	scheme, err := lookup(http.Header{"Authorization": {"SV1-HMAC-SHA256 ak:sig"}})
+	require.Nil(t, err)
+	require.Equal(t, VersionSV1HmacSha256, scheme)
+
+	scheme, err = lookup(http.Header{"Authorization": {"SV4-HMAC-SHA256 Credential=ak, SignedHeaders=x-date, Signature=sig"}})
+	require.Nil(t, err)
+	require.Equal(t, VersionSV4HmacSha256, scheme)
+
+	// The ConsoleSigner must be registered explicitly.
+	_, err = lookup(http.Header{"User-Agent": {ConsoleUserAgent}})
+	require.Equal(t, qerror.MissingAuthorizationHeader, err)
+
+	registry := NewRegistry()
+	registry.Register(&ConsoleSigner{}, ConsoleUserAgent)
+	s, err := registry.Lookup(&Request{Header: http.Header{"User-Agent": {ConsoleUserAgent}}})
+	require.Nil(t, err)
+	require.Equal(t, ConsoleScheme, s.Scheme())
+
+	_, err = lookup(http.Header{"Authorization": {"SV9 ak:sig"}})
+	require.Equal(t, qerror.UnsupportedSignatureVersion, err)
+
+	_, err = lookup(http.Header{})
+	require.Equal(t, qerror.MissingAuthorizationHeader, err)
+}
+
+func Test_SignersRoundTrip(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(&ConsoleSigner{}, ConsoleUserAgent)
+	registry.Register(&SV1Signer{})
+	registry.Register(NewSV4Signer())
+	v := NewVerifier(testSecretProvider{"ak1": "sk1"}, WithRegistry(registry), WithNonceStore(testNonceStore{}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		account, err := v.Verify(r.Context(), NewRequest(r, body))
+		if err != nil {
+			w.WriteHeader(err.(*qerror.Error).Status())
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		_, _ = w.Write([]byte(account.AccessKeyId))
+	}))
+	defer server.Close()
+
+	for _, s := range []Signer{&ConsoleSigner{}, &SV1Signer{}, NewSV4Signer()} {
+		client := &http.Client{Transport: NewTransport(s, "ak1", "sk1", nil)}
+
+		resp, err := client.Get(server.URL + "/v1/workspace?limit=10&search=a b&search=c+d")
+		require.Nil(t, err)
+		b, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, s.Scheme()+": "+string(b))
+
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/workspace/w 1", bytes.NewBufferString(`{"name": "w1"}`))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = client.Do(req)
+		require.Nil(t, err)
+		b, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, s.Scheme()+": "+string(b))
+
+		// Wrong secret.
+		client = &http.Client{Transport: NewTransport(s, "ak1", "sk2", nil)}
+		resp, err = client.Get(server.URL + "/v1/workspace")
+		require.Nil(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, s.Scheme())
+	}
+}
+
+func Test_SV4SignerTampered(t *testing.T) {
+	s := NewSV4Signer()
+	req := &Request{
+		Method: http.MethodPost,
+		Host:   "api.example.com",
+		Path:   "/v1/workspace",
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Query:  map[string][]string{"limit": {"10"}},
+		Body:   []byte(`{"name": "w1"}`),
+	}
+	require.Nil(t, s.Sign(req, "ak1", "sk1"))
+	require.Nil(t, s.Verify(req, "sk1", DefaultSignatureExpires))
+
+	req.Body = []byte(`{"name": "w2"}`)
+	require.Equal(t, qerror.SignatureNotMatch, s.Verify(req, "sk1", DefaultSignatureExpires))
+	req.Body = []byte(`{"name": "w1"}`)
+
+	req.Host = "evil.example.com"
+	require.Equal(t, qerror.SignatureNotMatch, s.Verify(req, "sk1", DefaultSignatureExpires))
+	req.Host = "api.example.com"
+
+	req.Header.Set("Content-Type", "text/plain")
+	require.Equal(t, qerror.SignatureNotMatch, s.Verify(req, "sk1", DefaultSignatureExpires))
+}
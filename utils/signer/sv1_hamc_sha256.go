@@ -2,12 +2,15 @@ package signer
 
 import (
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/DataWorkbench/common/qerror"
 )
@@ -86,6 +89,12 @@ func CalculateSV1Hmac256Signature(secretAccessKey string, stringToSign string) (
 	return
 }
 
+// BuildSV1ContentMD5 returns the value of header "Content-MD5", the base64 of hex encoded md5 of body.
+func BuildSV1ContentMD5(body []byte) string {
+	sum := md5.Sum(body)
+	return base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(sum[:])))
+}
+
 func BuildSV1Authorization(accessKeyId string, signature string) (authorization string) {
 	return VersionSV1HmacSha256 + " " + accessKeyId + ":" + signature
 }
@@ -116,3 +125,50 @@ func ParseSV1Authorization(authorization string) (accessKeyId string, signature
 	signature = signParts[1]
 	return
 }
+
+// SV1Signer implements the Signer by SV1-HMAC-SHA256. The "X-Date" and "Content-MD5"
+// are set by Sign, the "Content-Type" is signed if present.
+type SV1Signer struct{}
+
+// Scheme for implements Signer.
+func (s *SV1Signer) Scheme() string {
+	return VersionSV1HmacSha256
+}
+
+// Sign for implements Signer.
+func (s *SV1Signer) Sign(req *Request, accessKeyId string, secretAccessKey string) error {
+	req.Header.Set("X-Date", time.Now().UTC().Format(DateFormat))
+	req.Header.Del("Content-MD5")
+	if len(req.Body) > 0 {
+		req.Header.Set("Content-MD5", BuildSV1ContentMD5(req.Body))
+	}
+	stringToSign := BuildSV1StringToSignature(req.Method, req.Path, req.Header, req.Query)
+	req.Header.Set("Authorization", BuildSV1Authorization(accessKeyId, CalculateSV1Hmac256Signature(secretAccessKey, stringToSign)))
+	return nil
+}
+
+// Parse for implements Signer.
+func (s *SV1Signer) Parse(req *Request) (accessKeyId string, signature string, err error) {
+	return ParseSV1Authorization(req.Header.Get("Authorization"))
+}
+
+// Verify for implements Signer. The body is verified by the "Content-MD5", which is required if the body not empty.
+func (s *SV1Signer) Verify(req *Request, secretAccessKey string, expires int) (err error) {
+	var signature string
+	if _, signature, err = s.Parse(req); err != nil {
+		return
+	}
+	if err = checkRequestDate(req.Header, expires); err != nil {
+		return
+	}
+	if len(req.Body) > 0 || req.Header.Get("Content-MD5") != "" {
+		if !hmac.Equal([]byte(req.Header.Get("Content-MD5")), []byte(BuildSV1ContentMD5(req.Body))) {
+			return qerror.InvalidContentMD5
+		}
+	}
+	expected := CalculateSV1Hmac256Signature(secretAccessKey, BuildSV1StringToSignature(req.Method, req.Path, req.Header, req.Query))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return qerror.SignatureNotMatch
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+const (
+	VersionSV4HmacSha256 = "SV4-HMAC-SHA256"
+)
+
+// SV4Signer implements the Signer by SV4-HMAC-SHA256, a canonical request signer like AWS Signature V4.
+//
+// The canonical request is:
+//
+//	METHOD\n
+//	/escaped/path\n
+//	sorted and escaped query\n
+//	lowercase signed header name:trimmed value\n (one line per signed header)\n
+//	signed header names joined by ";"\n
+//	hex sha256 of body
+//
+// The string to sign is "SV4-HMAC-SHA256\n<X-Date>\n<hex sha256 of canonical request>", and the signature is
+// the hex of HMAC-SHA256 by secret. The header "Authorization" is:
+//
+//	SV4-HMAC-SHA256 Credential=<access_key_id>, SignedHeaders=content-type;host;x-date, Signature=<signature>
+type SV4Signer struct {
+	// SignedHeaders are the headers to sign if present, the "x-date" is always signed.
+	SignedHeaders []string
+}
+
+// NewSV4Signer creates a SV4Signer that signs the headers "Host", "Content-Type" and "X-Date".
+func NewSV4Signer() *SV4Signer {
+	return &SV4Signer{SignedHeaders: []string{"content-type", "host", "x-date"}}
+}
+
+// Scheme for implements Signer.
+func (s *SV4Signer) Scheme() string {
+	return VersionSV4HmacSha256
+}
+
+// Sign for implements Signer.
+func (s *SV4Signer) Sign(req *Request, accessKeyId string, secretAccessKey string) error {
+	req.Header.Set("X-Date", time.Now().UTC().Format(DateFormat))
+
+	var signedHeaders []string
+	for _, name := range append([]string{"x-date"}, s.SignedHeaders...) {
+		name = strings.ToLower(name)
+		if (name == "host" && req.Host != "") || req.Header.Get(name) != "" {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	signedHeaders = uniqueSortedStrings(signedHeaders)
+
+	signature := s.signature(req, signedHeaders, secretAccessKey)
+	req.Header.Set("Authorization", VersionSV4HmacSha256+" Credential="+accessKeyId+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+	return nil
+}
+
+// Parse for implements Signer.
+func (s *SV4Signer) Parse(req *Request) (accessKeyId string, signature string, err error) {
+	accessKeyId, _, signature, err = s.parse(req)
+	return
+}
+
+// Verify for implements Signer.
+func (s *SV4Signer) Verify(req *Request, secretAccessKey string, expires int) (err error) {
+	var signature string
+	var signedHeaders []string
+	if _, signedHeaders, signature, err = s.parse(req); err != nil {
+		return
+	}
+	if i := sort.SearchStrings(signedHeaders, "x-date"); i == len(signedHeaders) || signedHeaders[i] != "x-date" {
+		// The date must be signed to prevent replay.
+		return qerror.InvalidAuthorizationHeader
+	}
+	if err = checkRequestDate(req.Header, expires); err != nil {
+		return
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(req, signedHeaders, secretAccessKey))) {
+		return qerror.SignatureNotMatch
+	}
+	return nil
+}
+
+// parse the header "Authorization", the signed headers are sorted.
+func (s *SV4Signer) parse(req *Request) (accessKeyId string, signedHeaders []string, signature string, err error) {
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		err = qerror.MissingAuthorizationHeader
+		return
+	}
+	if !strings.HasPrefix(authorization, VersionSV4HmacSha256+" ") {
+		err = qerror.UnsupportedSignatureVersion
+		return
+	}
+	for _, part := range strings.Split(authorization[len(VersionSV4HmacSha256)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			err = qerror.InvalidAuthorizationHeader
+			return
+		}
+		switch kv[0] {
+		case "Credential":
+			accessKeyId = kv[1]
+		case "SignedHeaders":
+			signedHeaders = uniqueSortedStrings(strings.Split(kv[1], ";"))
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	if accessKeyId == "" || signature == "" || len(signedHeaders) == 0 {
+		err = qerror.InvalidAuthorizationHeader
+		return
+	}
+	return
+}
+
+func (s *SV4Signer) signature(req *Request, signedHeaders []string, secretAccessKey string) string {
+	bodyHash := sha256.Sum256(req.Body)
+
+	var headerLines strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+		}
+		headerLines.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		strings.ToUpper(req.Method),
+		escapePath(req.Path),
+		canonicalQuery(req.Query),
+		headerLines.String(),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := VersionSV4HmacSha256 + "\n" + req.Header.Get("X-Date") + "\n" + hex.EncodeToString(requestHash[:])
+	h := hmac.New(sha256.New, []byte(secretAccessKey))
+	h.Write([]byte(stringToSign))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// escapePath escapes each segment of path by RFC 3986.
+func escapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escapeRFC3986(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts the query by key and value, and escapes them by RFC 3986.
+func canonicalQuery(query url.Values) string {
+	var parts []string
+	for key, values := range query {
+		if len(values) == 0 {
+			parts = append(parts, escapeRFC3986(key)+"=")
+			continue
+		}
+		for _, value := range values {
+			parts = append(parts, escapeRFC3986(key)+"="+escapeRFC3986(value))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+func escapeRFC3986(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func uniqueSortedStrings(ss []string) []string {
+	sort.Strings(ss)
+	n := 0
+	for i, s := range ss {
+		if s == "" || (i > 0 && s == ss[i-1]) {
+			continue
+		}
+		ss[n] = s
+		n++
+	}
+	return ss[:n]
+}
@@ -0,0 +1,92 @@
+package signer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Transport is a http.RoundTripper that signs each request by the Signer.
+//
+// The signature is set on a clone of request, the original request is never modified.
+// The body is read into memory to compute the hash.
+type Transport struct {
+	Signer          Signer
+	AccessKeyId     string
+	SecretAccessKey string
+	// Base is the underlying RoundTripper, http.DefaultTransport is used if nil.
+	Base http.RoundTripper
+}
+
+// NewTransport creates a Transport that wraps the base.
+func NewTransport(s Signer, accessKeyId string, secretAccessKey string, base http.RoundTripper) *Transport {
+	return &Transport{
+		Signer:          s,
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		Base:            base,
+	}
+}
+
+// NewSV1Transport creates a Transport that signs by SV1-HMAC-SHA256, it sets the headers
+// "X-Date", "Content-MD5" and "Authorization".
+func NewSV1Transport(accessKeyId string, secretAccessKey string, base http.RoundTripper) *Transport {
+	return NewTransport(&SV1Signer{}, accessKeyId, secretAccessKey, base)
+}
+
+// RoundTrip for implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed, err := t.sign(req)
+	if err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+func (t *Transport) sign(req *http.Request) (*http.Request, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := req.Clone(req.Context())
+	if body != nil {
+		signed.Body = ioutil.NopCloser(bytes.NewReader(body))
+		signed.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		signed.ContentLength = int64(len(body))
+	}
+
+	r := NewRequest(signed, body)
+	if err = t.Signer.Sign(r, t.AccessKeyId, t.SecretAccessKey); err != nil {
+		return nil, err
+	}
+	// The signer may add the query, eg: ConsoleSigner.
+	signed.URL.RawQuery = r.Query.Encode()
+	return signed, nil
+}
+
+// readBody returns the content of body, it uses the GetBody if possible to get a fresh copy.
+// The original body is always closed as required by http.RoundTripper.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer func() { _ = req.Body.Close() }()
+
+	rc := req.Body
+	if req.GetBody != nil {
+		var err error
+		if rc, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+		defer func() { _ = rc.Close() }()
+	}
+	return ioutil.ReadAll(rc)
+}
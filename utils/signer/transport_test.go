@@ -0,0 +1,74 @@
+package signer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+func newTestVerifyServer(t *testing.T, v *Verifier) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		account, err := v.VerifySV1(r.Context(), r.Method, r.URL.Path, r.Header, r.URL.Query(), body)
+		if err != nil {
+			w.WriteHeader(err.(*qerror.Error).Status())
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		_, _ = w.Write([]byte(account.AccessKeyId + ":" + string(body)))
+	}))
+}
+
+func Test_SV1TransportRoundTrip(t *testing.T) {
+	v := NewVerifier(testSecretProvider{"ak1": "sk1"}, WithNonceStore(testNonceStore{}))
+	server := newTestVerifyServer(t, v)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewSV1Transport("ak1", "sk1", nil)}
+
+	// GET with query.
+	resp, err := client.Get(server.URL + "/v1/workspace?limit=10&search=name2&search=name1")
+	require.Nil(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	require.Equal(t, "ak1:", string(b))
+
+	// POST with body, the original request should not be modified.
+	body := `{"name": "w1"}`
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/workspace", bytes.NewBufferString(body))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	resp, err = client.Do(req)
+	require.Nil(t, err)
+	b, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	require.Equal(t, "ak1:"+body, string(b))
+	require.Empty(t, req.Header.Get("Authorization"))
+
+	// Body without GetBody.
+	req, err = http.NewRequest(http.MethodPut, server.URL+"/v1/workspace/w1", ioutil.NopCloser(strings.NewReader(body)))
+	require.Nil(t, err)
+	resp, err = client.Do(req)
+	require.Nil(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Wrong secret.
+	client = &http.Client{Transport: NewSV1Transport("ak1", "sk2", nil)}
+	resp, err = client.Get(server.URL + "/v1/workspace")
+	require.Nil(t, err)
+	b, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, qerror.SignatureNotMatch.Code(), string(b))
+}
@@ -0,0 +1,155 @@
+package signer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+// DefaultSignatureExpires is the default seconds that a signature is valid before and after its date.
+const DefaultSignatureExpires = 300
+
+// Account is the authenticated account of a signed request.
+type Account struct {
+	AccessKeyId string
+	UserId      string
+	RootUserId  string
+}
+
+type accountKey struct{}
+
+// ContextWithAccount returns a new context that carries the authenticated account.
+func ContextWithAccount(ctx context.Context, account *Account) context.Context {
+	return context.WithValue(ctx, accountKey{}, account)
+}
+
+// AccountFromContext returns the authenticated account set by the signature auth middleware,
+// nil if not found.
+func AccountFromContext(ctx context.Context) *Account {
+	account, _ := ctx.Value(accountKey{}).(*Account)
+	return account
+}
+
+// SecretProvider returns the secret access key and account by access key id.
+type SecretProvider interface {
+	// GetSecret returns qerror.AccessKeyNotExists if the access key not found.
+	GetSecret(ctx context.Context, accessKeyId string) (secretAccessKey string, account *Account, err error)
+}
+
+// NonceStore records the used signatures to reject the replayed requests, eg: *rediswrap.NonceStore.
+type NonceStore interface {
+	// SetIfAbsent records the nonce with ttl, returns false if it already exists.
+	SetIfAbsent(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+type VerifierOption func(o *verifierOptions)
+
+type verifierOptions struct {
+	registry *Registry
+	nonces   NonceStore
+	expires  int
+}
+
+func applyVerifierOptions(options ...VerifierOption) verifierOptions {
+	opts := verifierOptions{
+		registry: DefaultRegistry,
+		nonces:   nil,
+		expires:  DefaultSignatureExpires,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithRegistry sets the Registry to find the Signer of requests. Defaults DefaultRegistry.
+func WithRegistry(registry *Registry) VerifierOption {
+	return func(o *verifierOptions) {
+		o.registry = registry
+	}
+}
+
+// WithNonceStore sets the NonceStore to reject the replayed requests, disabled by default.
+//
+// The signature is used as nonce, so the identical requests with the same date are treated
+// as replayed. The clients should set the "X-Date" for each request.
+func WithNonceStore(nonces NonceStore) VerifierOption {
+	return func(o *verifierOptions) {
+		o.nonces = nonces
+	}
+}
+
+// WithSignatureExpires sets the seconds that a signature is valid. Defaults DefaultSignatureExpires.
+func WithSignatureExpires(seconds int) VerifierOption {
+	return func(o *verifierOptions) {
+		o.expires = seconds
+	}
+}
+
+// Verifier verifies the signature of the incoming requests by the Signer found in Registry.
+type Verifier struct {
+	secrets SecretProvider
+	opts    verifierOptions
+}
+
+// NewVerifier creates a Verifier with the SecretProvider.
+func NewVerifier(secrets SecretProvider, options ...VerifierOption) *Verifier {
+	if secrets == nil {
+		panic("Verifier: secrets can not be nil")
+	}
+	return &Verifier{
+		secrets: secrets,
+		opts:    applyVerifierOptions(options...),
+	}
+}
+
+// Verify finds the Signer of request and verifies the signature, returns the authenticated account.
+func (v *Verifier) Verify(ctx context.Context, req *Request) (*Account, error) {
+	s, err := v.opts.registry.Lookup(req)
+	if err != nil {
+		return nil, err
+	}
+	return v.verify(ctx, s, req)
+}
+
+// VerifySV1 verifies the SV1-HMAC-SHA256 signature of request and returns the authenticated account.
+// The body is verified by the header "Content-MD5", which is required if the body not empty.
+func (v *Verifier) VerifySV1(ctx context.Context, method string, signPath string, headers http.Header,
+	query url.Values, body []byte) (account *Account, err error) {
+	req := &Request{Method: method, Path: signPath, Header: headers, Query: query, Body: body}
+	return v.verify(ctx, &SV1Signer{}, req)
+}
+
+func (v *Verifier) verify(ctx context.Context, s Signer, req *Request) (account *Account, err error) {
+	var accessKeyId, signature string
+	if accessKeyId, signature, err = s.Parse(req); err != nil {
+		return
+	}
+
+	var secretAccessKey string
+	if secretAccessKey, account, err = v.secrets.GetSecret(ctx, accessKeyId); err != nil {
+		return
+	}
+	if err = s.Verify(req, secretAccessKey, v.opts.expires); err != nil {
+		return
+	}
+
+	// The requests without date can't be distinguished from replays, so skip them. Only the
+	// ConsoleSigner accepts such requests, and it must be registered explicitly by WithRegistry.
+	if v.opts.nonces != nil && (req.Header.Get("X-Date") != "" || req.Header.Get("Date") != "") {
+		// The signature is valid in [date - expires, date + expires].
+		var ok bool
+		ttl := time.Duration(v.opts.expires) * time.Second * 2
+		if ok, err = v.opts.nonces.SetIfAbsent(ctx, s.Scheme()+":"+accessKeyId+":"+signature, ttl); err != nil {
+			return
+		}
+		if !ok {
+			err = qerror.ReplayedSignature
+			return
+		}
+	}
+	return account, nil
+}
@@ -0,0 +1,116 @@
+package signer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+)
+
+type testSecretProvider map[string]string
+
+func (p testSecretProvider) GetSecret(ctx context.Context, accessKeyId string) (string, *Account, error) {
+	sk, ok := p[accessKeyId]
+	if !ok {
+		return "", nil, qerror.AccessKeyNotExists.Format(accessKeyId)
+	}
+	return sk, &Account{AccessKeyId: accessKeyId, UserId: "usr-" + accessKeyId}, nil
+}
+
+type testNonceStore map[string]bool
+
+func (s testNonceStore) SetIfAbsent(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	if s[nonce] {
+		return false, nil
+	}
+	s[nonce] = true
+	return true, nil
+}
+
+func signTestRequest(ak, sk string, date time.Time, body []byte) http.Header {
+	headers := make(http.Header)
+	headers.Set("X-Date", date.UTC().Format(DateFormat))
+	headers.Set("Content-Type", "application/json")
+	if len(body) > 0 {
+		headers.Set("Content-MD5", BuildSV1ContentMD5(body))
+	}
+	query := url.Values{"limit": {"10"}}
+	signature := CalculateSV1Hmac256Signature(sk, BuildSV1StringToSignature(http.MethodPost, "/v1/workspace", headers, query))
+	headers.Set("Authorization", BuildSV1Authorization(ak, signature))
+	return headers
+}
+
+func Test_VerifySV1(t *testing.T) {
+	ctx := context.Background()
+	v := NewVerifier(testSecretProvider{"ak1": "sk1"}, WithNonceStore(testNonceStore{}))
+	query := url.Values{"limit": {"10"}}
+	body := []byte(`{"name": "w1"}`)
+
+	headers := signTestRequest("ak1", "sk1", time.Now(), body)
+	account, err := v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, body)
+	require.Nil(t, err)
+	require.Equal(t, "usr-ak1", account.UserId)
+
+	// Replayed.
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, body)
+	require.Equal(t, qerror.ReplayedSignature, err)
+
+	// Body modified.
+	headers = signTestRequest("ak1", "sk1", time.Now().Add(-time.Second), body)
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, []byte(`{"name": "w2"}`))
+	require.Equal(t, qerror.InvalidContentMD5, err)
+
+	// Query modified.
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, url.Values{"limit": {"100"}}, body)
+	require.Equal(t, qerror.SignatureNotMatch, err)
+
+	// Wrong secret.
+	headers = signTestRequest("ak1", "sk2", time.Now(), body)
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, body)
+	require.Equal(t, qerror.SignatureNotMatch, err)
+
+	// Expired and future date.
+	headers = signTestRequest("ak1", "sk1", time.Now().Add(-time.Hour), body)
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, body)
+	require.Equal(t, qerror.ExpiredSignature, err)
+	headers = signTestRequest("ak1", "sk1", time.Now().Add(time.Hour), body)
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, body)
+	require.Equal(t, qerror.ExpiredSignature, err)
+
+	// Unknown access key.
+	headers = signTestRequest("ak2", "sk1", time.Now(), body)
+	_, err = v.VerifySV1(ctx, http.MethodPost, "/v1/workspace", headers, query, body)
+	require.NotNil(t, err)
+	require.Equal(t, qerror.AccessKeyNotExists.Code(), err.(*qerror.Error).Code())
+}
+
+func Test_VerifyConsoleReplayed(t *testing.T) {
+	ctx := context.Background()
+	req := &Request{
+		Method: http.MethodGet,
+		Path:   "/v1/workspace",
+		Header: make(http.Header),
+		Query:  url.Values{"limit": {"10"}},
+	}
+	require.Nil(t, (&ConsoleSigner{}).Sign(req, "ak1", "sk1"))
+
+	// The console requests can't be checked for replay, so they are rejected by default.
+	v := NewVerifier(testSecretProvider{"ak1": "sk1"}, WithNonceStore(testNonceStore{}))
+	for i := 0; i < 2; i++ {
+		_, err := v.Verify(ctx, req)
+		require.Equal(t, qerror.MissingAuthorizationHeader, err)
+	}
+
+	// Accepted only if the ConsoleSigner is registered explicitly.
+	registry := NewRegistry()
+	registry.Register(&ConsoleSigner{}, ConsoleUserAgent)
+	v = NewVerifier(testSecretProvider{"ak1": "sk1"}, WithRegistry(registry))
+	account, err := v.Verify(ctx, req)
+	require.Nil(t, err)
+	require.Equal(t, "usr-ak1", account.UserId)
+}
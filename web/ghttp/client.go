@@ -11,6 +11,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 
 	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/signer"
 )
 
 // ClientConfig for configuration http transport
@@ -42,8 +43,43 @@ type Client struct {
 	tracer opentracing.Tracer
 }
 
+// ClientOption used to customize the Client created by NewClient.
+type ClientOption func(o *clientOptions)
+
+type clientOptions struct {
+	wrappers []func(http.RoundTripper) http.RoundTripper
+}
+
+func applyClientOptions(options ...ClientOption) clientOptions {
+	opts := clientOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithTransportWrapper wraps the http.Transport, the wrappers are applied in order,
+// so the last one is the outermost.
+func WithTransportWrapper(wrapper func(http.RoundTripper) http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.wrappers = append(o.wrappers, wrapper)
+	}
+}
+
+// WithSigning signs each request by the signer with the access key, eg: &signer.SV1Signer{}.
+func WithSigning(s signer.Signer, accessKeyId string, secretAccessKey string) ClientOption {
+	return WithTransportWrapper(func(rt http.RoundTripper) http.RoundTripper {
+		return signer.NewTransport(s, accessKeyId, secretAccessKey, rt)
+	})
+}
+
+// WithSV1Signing signs each request by SV1-HMAC-SHA256 with the access key.
+func WithSV1Signing(accessKeyId string, secretAccessKey string) ClientOption {
+	return WithSigning(&signer.SV1Signer{}, accessKeyId, secretAccessKey)
+}
+
 // NewClient creating a new http.Client using the provided NetDialer
-func NewClient(ctx context.Context, cfg *ClientConfig) *Client {
+func NewClient(ctx context.Context, cfg *ClientConfig, options ...ClientOption) *Client {
 	if cfg == nil {
 		cfg = NewClientConfig()
 	}
@@ -73,9 +109,14 @@ func NewClient(ctx context.Context, cfg *ClientConfig) *Client {
 		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
 	}
 
+	var rt http.RoundTripper = transport
+	for _, wrap := range applyClientOptions(options...).wrappers {
+		rt = wrap(rt)
+	}
+
 	cli := &Client{
 		Client: &http.Client{
-			Transport: transport,
+			Transport: rt,
 			Timeout:   0,
 		},
 		tracer: gtrace.TracerFromContext(ctx),
@@ -0,0 +1,96 @@
+package ginmiddle
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/signer"
+)
+
+// DefaultAuthMaxBodySize is the default max bytes of request body read by SignatureAuth.
+const DefaultAuthMaxBodySize = 10 << 20
+
+type AuthOption func(o *authOptions)
+
+type authOptions struct {
+	maxBodySize int64
+}
+
+func applyAuthOptions(options ...AuthOption) authOptions {
+	opts := authOptions{
+		maxBodySize: DefaultAuthMaxBodySize,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+// WithAuthMaxBodySize sets the max bytes of request body, the larger requests are rejected
+// with qerror.RequestEntityTooLarge before verifying. Defaults DefaultAuthMaxBodySize.
+func WithAuthMaxBodySize(size int64) AuthOption {
+	if size <= 0 {
+		panic("WithAuthMaxBodySize: size must be greater than 0")
+	}
+	return func(o *authOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// SignatureAuth returns a middleware that verifies the signature of requests, the signer is found
+// in the Registry of Verifier by the header "Authorization" or "User-Agent".
+//
+// The authenticated account is stored into the std context, get it by signer.AccountFromContext.
+// The errors are handled by ErrorHandler, so it must be used after Trace and ErrorHandler.
+//
+// The body is read into memory to compute the signature, its size is limited by WithAuthMaxBodySize.
+func SignatureAuth(v *signer.Verifier, options ...AuthOption) gin.HandlerFunc {
+	opts := applyAuthOptions(options...)
+
+	return func(c *gin.Context) {
+		ctx := GetStdContext(c)
+		lg := glog.FromContext(ctx)
+
+		var body []byte
+		if c.Request.Body != nil {
+			if c.Request.ContentLength > opts.maxBodySize {
+				_ = c.Error(qerror.RequestEntityTooLarge.Format(opts.maxBodySize))
+				c.Abort()
+				return
+			}
+			// Reads one more byte to find out the body without Content-Length exceeds the limit.
+			var err error
+			if body, err = ioutil.ReadAll(io.LimitReader(c.Request.Body, opts.maxBodySize+1)); err != nil {
+				lg.Error().Error("read request body error", err).Fire()
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+			if int64(len(body)) > opts.maxBodySize {
+				_ = c.Error(qerror.RequestEntityTooLarge.Format(opts.maxBodySize))
+				c.Abort()
+				return
+			}
+			// Restores the body for the handlers.
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		account, err := v.Verify(ctx, signer.NewRequest(c.Request, body))
+		if err != nil {
+			lg.Warn().Msg("request rejected by signature auth").Error("error", err).Fire()
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+
+		lg.Debug().String("authenticated access key", account.AccessKeyId).String("user_id", account.UserId).Fire()
+		SetStdContext(c, signer.ContextWithAccount(ctx, account))
+
+		c.Next()
+	}
+}
@@ -0,0 +1,74 @@
+package ginmiddle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/signer"
+)
+
+type testSecretProvider map[string]string
+
+func (p testSecretProvider) GetSecret(ctx context.Context, accessKeyId string) (string, *signer.Account, error) {
+	sk, ok := p[accessKeyId]
+	if !ok {
+		return "", nil, qerror.AccessKeyNotExists.Format(accessKeyId)
+	}
+	return sk, &signer.Account{AccessKeyId: accessKeyId, UserId: "usr-" + accessKeyId}, nil
+}
+
+func Test_SignatureAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	v := signer.NewVerifier(testSecretProvider{"ak1": "sk1"})
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { SetStdContext(c, ctx) })
+	engine.Use(ErrorHandler())
+	engine.Use(SignatureAuth(v, WithAuthMaxBodySize(16)))
+	engine.POST("/v1/workspace", func(c *gin.Context) {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		require.Nil(t, err)
+		account := signer.AccountFromContext(GetStdContext(c))
+		c.String(http.StatusOK, account.UserId+":"+string(body))
+	})
+
+	do := func(body []byte, contentLength int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/workspace", bytes.NewReader(body))
+		req.ContentLength = contentLength
+		require.Nil(t, (&signer.SV1Signer{}).Sign(signer.NewRequest(req, body), "ak1", "sk1"))
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	requireTooLarge := func(w *httptest.ResponseRecorder) {
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		var resp qerror.Response
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Equal(t, qerror.RequestEntityTooLarge.Code(), resp.Code)
+	}
+
+	// The body is restored for the handlers.
+	w := do([]byte(`{"name": "w1"}`), -1)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `usr-ak1:{"name": "w1"}`, w.Body.String())
+
+	w = do([]byte(`0123456789abcdef`), 16)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Rejected by the Content-Length, and by reading if the Content-Length is unknown.
+	requireTooLarge(do([]byte(`0123456789abcdefg`), 17))
+	requireTooLarge(do([]byte(`0123456789abcdefg`), -1))
+
+	require.Panics(t, func() { WithAuthMaxBodySize(0) })
+}
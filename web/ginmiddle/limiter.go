@@ -0,0 +1,34 @@
+package ginmiddle
+
+import (
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+
+	"github.com/DataWorkbench/common/utils/limiter"
+)
+
+// Limit returns a middleware that enforce the limiter on requests.
+//
+// The keyFunc returns the key to limit, eg: workspace id or user id. The request will
+// not be limited if the key is empty. The error of limiter is handled by ErrorHandler.
+func Limit(l limiter.Limiter, keyFunc func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		ctx := GetStdContext(c)
+		release, err := l.Acquire(ctx, key)
+		if err != nil {
+			glog.FromContext(ctx).Warn().Msg("request rejected by limiter").String("key", key).Error("error", err).Fire()
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
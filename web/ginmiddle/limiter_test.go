@@ -0,0 +1,56 @@
+package ginmiddle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/limiter"
+)
+
+func Test_Limit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	l := limiter.NewLocal(1)
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) { SetStdContext(c, ctx) })
+	engine.Use(ErrorHandler())
+	engine.Use(Limit(l, func(c *gin.Context) string { return c.GetHeader("X-Space-Id") }))
+	engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		if key != "" {
+			req.Header.Set("X-Space-Id", key)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	release, err := l.Acquire(ctx, "w1")
+	require.Nil(t, err)
+
+	w := do("w1")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	var resp qerror.Response
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, qerror.TooManyRequests.Code(), resp.Code)
+
+	// The other keys and the requests without key are not limited.
+	require.Equal(t, http.StatusOK, do("w2").Code)
+	require.Equal(t, http.StatusOK, do("").Code)
+
+	// The permit is released after the request done.
+	release()
+	require.Equal(t, http.StatusOK, do("w1").Code)
+	require.Equal(t, http.StatusOK, do("w1").Code)
+}
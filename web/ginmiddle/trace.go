@@ -66,10 +66,8 @@ func Trace(ctx context.Context) gin.HandlerFunc {
 		tid = c.Request.Header.Get(gtrace.HeaderKey)
 		if tid == "" {
 			// Try to use trace id as the request id.
-			sp, ok := span.Context().(gtrace.SpanContext)
-			if ok {
-				tid = sp.TraceID().String()
-			} else {
+			tid = gtrace.TraceIdFromSpan(span)
+			if tid == "" {
 				tid, err = idGen.Take()
 				if err != nil {
 					nl.Error().Error("generate new trace id error", err).Fire()
@@ -0,0 +1,638 @@
+package gws
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/gorilla/websocket"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/idgenerator"
+)
+
+// ConnIdKey is the key of connection id in logger fields.
+const ConnIdKey = "cid"
+
+const (
+	sideServer = "server"
+	sideClient = "client"
+)
+
+// closeGracePeriod is the max time to wait for the close frame of peer after sent the close frame.
+const closeGracePeriod = time.Second
+
+var (
+	// ErrConnClosed is returned by writes after the connection closed or closing.
+	ErrConnClosed = errors.New("gws: connection closed")
+	// ErrSendQueueFull is returned by writes if the send queue is full and the message is dropped.
+	ErrSendQueueFull = errors.New("gws: send queue full")
+)
+
+var (
+	// Morally a const:
+	wsComponentTag = opentracing.Tag{Key: string(ext.Component), Value: "websocket"}
+)
+
+// OverflowPolicy decides what to do with the message that written when the send queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the write until the queue has space or the connection closed.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the message that being written and returns ErrSendQueueFull.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest messages in queue to make space for the new one.
+	OverflowDropOldest
+	// OverflowClose closes the connection with websocket.CloseTryAgainLater and returns ErrSendQueueFull,
+	// for the peer that can't lose any message, eg: the log streaming.
+	OverflowClose
+)
+
+// Option for configure the Upgrader and Dialer.
+type Option func(o *options)
+
+type options struct {
+	messageSampling float64
+	pingInterval    time.Duration
+	pongTimeout     time.Duration
+	writeTimeout    time.Duration
+	sendQueueSize   int
+	overflowPolicy  OverflowPolicy
+	readLimit       int64
+}
+
+// WithMessageSampling sets the probability between 0 and 1 to create a child span for per
+// message read and write. Default is 0 that no span created for messages.
+func WithMessageSampling(probability float64) Option {
+	return func(o *options) {
+		o.messageSampling = probability
+	}
+}
+
+// WithHeartbeat sets the interval to send ping to peer, and the timeout to wait for the pong
+// or ping of peer before the connection considered dead. Default is 30s and 60s. The pingInterval
+// 0 disables the heartbeat. The pongTimeout is set to 2 * pingInterval if it's not greater.
+func WithHeartbeat(pingInterval, pongTimeout time.Duration) Option {
+	return func(o *options) {
+		o.pingInterval = pingInterval
+		o.pongTimeout = pongTimeout
+	}
+}
+
+// WithWriteTimeout sets the deadline of per message write. Default is 10s.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithSendQueue sets the size of send queue and the policy when it's full. Default is 256 and OverflowBlock.
+func WithSendQueue(size int, policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.sendQueueSize = size
+		o.overflowPolicy = policy
+	}
+}
+
+// WithReadLimit sets the max size in bytes of message read from peer. Default is 0 that no limit.
+func WithReadLimit(limit int64) Option {
+	return func(o *options) {
+		o.readLimit = limit
+	}
+}
+
+func applyOptions(opts []Option) *options {
+	o := &options{
+		pingInterval:   time.Second * 30,
+		pongTimeout:    time.Second * 60,
+		writeTimeout:   time.Second * 10,
+		sendQueueSize:  256,
+		overflowPolicy: OverflowBlock,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.pingInterval > 0 && o.pongTimeout <= o.pingInterval {
+		o.pongTimeout = o.pingInterval * 2
+	}
+	if o.sendQueueSize < 1 {
+		o.sendQueueSize = 1
+	}
+	return o
+}
+
+// message is the item of send queue.
+type message struct {
+	messageType int
+	data        []byte
+	span        opentracing.Span
+}
+
+// finish finishes the span of message if it's sampled.
+func (m *message) finish(err error) {
+	if m.span == nil {
+		return
+	}
+	if err != nil {
+		ext.Error.Set(m.span, true)
+		m.span.LogFields(tracerLog.Error(err))
+	}
+	m.span.Finish()
+}
+
+// Conn is the managed wrapper of websocket.Conn that carries the trace context of connection.
+//
+// All writes are sent by a single writer goroutine from a bounded send queue, so it's safe to
+// call the write methods concurrently. The writer goroutine also sends ping to peer periodically,
+// and the connection is closed if no pong or ping received in the pong timeout. The pong is
+// handled in read, so the application must keep reading the connection.
+//
+// As same as websocket.Conn, it supports one concurrent reader.
+//
+// The span of connection starts at handshake and finishes at Close, the spans of messages
+// are children of it. The logger in Context have the trace id and connection id.
+type Conn struct {
+	// Statistics of connection, accessed atomically. They are at the beginning of struct
+	// for the 64-bit alignment on 32-bit platforms.
+	messagesSent     uint64
+	messagesReceived uint64
+	messagesDropped  uint64
+	bytesSent        uint64
+	bytesReceived    uint64
+	pingRTT          int64
+
+	raw     *websocket.Conn
+	id      string
+	side    string
+	metrics *connMetrics
+	ctx     context.Context
+	lg      *glog.Logger
+	tracer  opentracing.Tracer
+	span    opentracing.Span
+	opts    *options
+
+	send chan *message
+
+	// closing is closed by CloseWithCode to ask the writer to send the close frame.
+	closing     chan struct{}
+	closingOnce sync.Once
+	closeCode   int
+	closeText   string
+
+	// done is closed when the connection is failed or closed, err is the cause.
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error
+
+	// writerDone is closed after the writer goroutine exited and the connection released.
+	writerDone chan struct{}
+	closeErr   error
+
+	// sendMu guards the sends to queue against the drain in release, the sendClosed is set
+	// by release so that no message is put into queue after drained.
+	sendMu     sync.RWMutex
+	sendClosed bool
+}
+
+// newConn creates the Conn and starts the writer goroutine, the ctx must be returned by
+// contextWithTraceId and have the span of connection.
+func newConn(ctx context.Context, raw *websocket.Conn, tracer opentracing.Tracer, idGen *idgenerator.IDGenerator, side string, opts *options) *Conn {
+	nl := glog.FromContext(ctx)
+	id, err := idGen.Take()
+	if err != nil {
+		nl.Error().Error("generate new connection id error", err).Fire()
+	}
+	nl.WithFields().AddString(ConnIdKey, id)
+
+	c := &Conn{
+		raw:        raw,
+		id:         id,
+		side:       side,
+		metrics:    sideMetrics[side],
+		ctx:        ctx,
+		lg:         nl,
+		tracer:     tracer,
+		span:       opentracing.SpanFromContext(ctx),
+		opts:       opts,
+		send:       make(chan *message, opts.sendQueueSize),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	c.span.SetTag(ConnIdKey, id)
+
+	if opts.readLimit > 0 {
+		raw.SetReadLimit(opts.readLimit)
+	}
+	if opts.pingInterval > 0 {
+		_ = c.extendReadDeadline()
+		raw.SetPongHandler(c.handlePong)
+		raw.SetPingHandler(c.handlePing)
+	}
+
+	connCollector.add(c)
+	go c.writeLoop()
+	return c
+}
+
+// ID returns the unique id of connection.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// Context returns the context of connection, it contains the span, trace id and logger.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Raw returns the underlying websocket.Conn. It must not be used for read and write, because
+// they are neither traced nor synchronized with the writer goroutine.
+func (c *Conn) Raw() *websocket.Conn {
+	return c.raw
+}
+
+// Done returns a channel that's closed when the connection is failed or closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// ConnStats is the statistics of a connection.
+type ConnStats struct {
+	MessagesSent     uint64
+	MessagesReceived uint64
+	MessagesDropped  uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+	// SendQueueLength is the number of messages waiting in the send queue.
+	SendQueueLength int
+	// PingRTT is the round-trip time of the last ping and pong, 0 if no pong received.
+	PingRTT time.Duration
+}
+
+// Stats returns the statistics of connection. The metrics only export the sum of all connections.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		MessagesSent:     atomic.LoadUint64(&c.messagesSent),
+		MessagesReceived: atomic.LoadUint64(&c.messagesReceived),
+		MessagesDropped:  atomic.LoadUint64(&c.messagesDropped),
+		BytesSent:        atomic.LoadUint64(&c.bytesSent),
+		BytesReceived:    atomic.LoadUint64(&c.bytesReceived),
+		SendQueueLength:  len(c.send),
+		PingRTT:          time.Duration(atomic.LoadInt64(&c.pingRTT)),
+	}
+}
+
+// ReadMessage is wrapper for websocket.Conn.ReadMessage. The span of message starts after
+// the message received, because the waiting time is meaningless.
+//
+// The connection is closed if read failed, eg: received the close frame or heartbeat timeout.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, p, err = c.raw.ReadMessage()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	atomic.AddUint64(&c.messagesReceived, 1)
+	atomic.AddUint64(&c.bytesReceived, uint64(len(p)))
+	c.metrics.messagesReceived.Inc()
+	c.metrics.bytesReceived.Add(float64(len(p)))
+	if span := c.startMessageSpan("websocket read", messageType, len(p)); span != nil {
+		span.Finish()
+	}
+	return
+}
+
+// ReadJSON reads the next message and decodes it as JSON into v.
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// ReadProto reads the next message and decodes it as protobuf wire format into m.
+func (c *Conn) ReadProto(m proto.Message) error {
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(p, m)
+}
+
+// WriteMessage puts the message into send queue, it's sent by the writer goroutine later.
+// The data is copied, so the caller can reuse it after return.
+//
+// It returns ErrConnClosed if the connection is closed or closing, ErrSendQueueFull if the queue
+// is full and the message dropped by the OverflowPolicy. The failure of sending closes the connection.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeMessage(messageType, append([]byte(nil), data...))
+}
+
+// writeMessage is same as WriteMessage except the data is owned by Conn.
+func (c *Conn) writeMessage(messageType int, data []byte) error {
+	m := &message{messageType: messageType, data: data}
+	m.span = c.startMessageSpan("websocket write", messageType, len(data))
+	err := c.enqueue(m)
+	if err != nil {
+		m.finish(err)
+	}
+	return err
+}
+
+// WriteJSON encodes v as JSON and writes it as text message.
+func (c *Conn) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.TextMessage, b)
+}
+
+// WriteProto encodes m as protobuf wire format and writes it as binary message.
+func (c *Conn) WriteProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.BinaryMessage, b)
+}
+
+// Close closes the connection gracefully with websocket.CloseNormalClosure.
+func (c *Conn) Close() error {
+	return c.CloseWithCode(websocket.CloseNormalClosure, "")
+}
+
+// CloseWithCode closes the connection gracefully. The messages in queue are sent before the close
+// frame with code and text, then it waits for the close frame of peer for a short while and closes
+// the underlying connection. The span of connection is finished after that.
+//
+// It's safe to call CloseWithCode many times, only the first code is sent.
+func (c *Conn) CloseWithCode(code int, text string) error {
+	c.closingOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.closing)
+	})
+	<-c.writerDone
+	return c.closeErr
+}
+
+// enqueue puts the message into send queue by the OverflowPolicy.
+func (c *Conn) enqueue(m *message) error {
+	// The blocked sends are waked up by the done that closed before release drains the queue.
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+	if c.sendClosed {
+		return ErrConnClosed
+	}
+
+	select {
+	case <-c.closing:
+		return ErrConnClosed
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.send <- m:
+		return nil
+	default:
+	}
+
+	switch c.opts.overflowPolicy {
+	case OverflowDropNewest:
+		c.messageDropped()
+		return ErrSendQueueFull
+	case OverflowDropOldest:
+		for {
+			select {
+			case c.send <- m:
+				return nil
+			case old := <-c.send:
+				c.messageDropped()
+				old.finish(ErrSendQueueFull)
+			}
+		}
+	case OverflowClose:
+		c.messageDropped()
+		go func() { _ = c.CloseWithCode(websocket.CloseTryAgainLater, "send queue overflow") }()
+		return ErrSendQueueFull
+	default:
+		select {
+		case c.send <- m:
+			return nil
+		case <-c.closing:
+			return ErrConnClosed
+		case <-c.done:
+			return ErrConnClosed
+		}
+	}
+}
+
+// fail marks the connection failed with the err, the first one is kept.
+func (c *Conn) fail(err error) {
+	c.doneOnce.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// writeLoop is the only goroutine that writes the connection and uses the logger after created.
+func (c *Conn) writeLoop() {
+	defer c.release()
+
+	var pingC <-chan time.Time
+	if c.opts.pingInterval > 0 {
+		ticker := time.NewTicker(c.opts.pingInterval)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case m := <-c.send:
+			if err := c.write(m); err != nil {
+				c.fail(err)
+				return
+			}
+		case <-pingC:
+			var payload [8]byte
+			binary.BigEndian.PutUint64(payload[:], uint64(time.Now().UnixNano()))
+			if err := c.raw.WriteControl(websocket.PingMessage, payload[:], time.Now().Add(c.opts.writeTimeout)); err != nil {
+				c.fail(err)
+				return
+			}
+		case <-c.closing:
+			c.closeGracefully()
+			return
+		}
+	}
+}
+
+// closeGracefully sends the pending messages and the close frame, then waits for the close
+// frame of peer that read by the application.
+func (c *Conn) closeGracefully() {
+	for m := c.pending(); m != nil; m = c.pending() {
+		if err := c.write(m); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+
+	data := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+	if err := c.raw.WriteControl(websocket.CloseMessage, data, time.Now().Add(c.opts.writeTimeout)); err != nil {
+		c.fail(err)
+		return
+	}
+	c.span.SetTag("websocket.close_code", c.closeCode)
+
+	timer := time.NewTimer(closeGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+	case <-timer.C:
+		c.fail(nil)
+	}
+}
+
+// pending returns the next message in send queue without blocking, or nil if it's empty.
+func (c *Conn) pending() *message {
+	select {
+	case m := <-c.send:
+		return m
+	default:
+		return nil
+	}
+}
+
+func (c *Conn) write(m *message) (err error) {
+	if err = c.raw.SetWriteDeadline(time.Now().Add(c.opts.writeTimeout)); err == nil {
+		err = c.raw.WriteMessage(m.messageType, m.data)
+	}
+	if err != nil {
+		c.lg.Error().Error("websocket write message error", err).Fire()
+	} else {
+		atomic.AddUint64(&c.messagesSent, 1)
+		atomic.AddUint64(&c.bytesSent, uint64(len(m.data)))
+		c.metrics.messagesSent.Inc()
+		c.metrics.bytesSent.Add(float64(len(m.data)))
+	}
+	m.finish(err)
+	return
+}
+
+// release closes the underlying connection, drops the pending messages and finishes the span.
+func (c *Conn) release() {
+	c.fail(nil)
+	c.closeErr = c.raw.Close()
+	connCollector.remove(c)
+
+	c.sendMu.Lock()
+	c.sendClosed = true
+	c.sendMu.Unlock()
+	for m := c.pending(); m != nil; m = c.pending() {
+		c.messageDropped()
+		m.finish(ErrConnClosed)
+	}
+
+	err := c.err
+	if ce, ok := err.(*websocket.CloseError); ok {
+		c.span.SetTag("websocket.peer_close_code", ce.Code)
+		if ce.Code == websocket.CloseNormalClosure || ce.Code == websocket.CloseGoingAway {
+			err = nil
+		}
+	}
+	if err != nil {
+		c.lg.Warn().Error("websocket connection closed with error", err).Fire()
+		ext.Error.Set(c.span, true)
+		c.span.LogFields(tracerLog.Error(err))
+	} else {
+		c.lg.Debug().Msg("websocket connection closed").Fire()
+	}
+	c.span.Finish()
+	_ = c.lg.Close()
+	close(c.writerDone)
+}
+
+// messageDropped counts the message that dropped by the OverflowPolicy or connection closed.
+func (c *Conn) messageDropped() {
+	atomic.AddUint64(&c.messagesDropped, 1)
+	c.metrics.messagesDropped.Inc()
+}
+
+func (c *Conn) extendReadDeadline() error {
+	return c.raw.SetReadDeadline(time.Now().Add(c.opts.pongTimeout))
+}
+
+// handlePong records the round-trip time by the timestamp in ping payload.
+func (c *Conn) handlePong(appData string) error {
+	if len(appData) == 8 {
+		sent := int64(binary.BigEndian.Uint64([]byte(appData)))
+		rtt := time.Now().UnixNano() - sent
+		atomic.StoreInt64(&c.pingRTT, rtt)
+		c.metrics.pingRTT.Observe(time.Duration(rtt).Seconds())
+	}
+	return c.extendReadDeadline()
+}
+
+// handlePing is same as the default ping handler of websocket.Conn except extends the read deadline.
+func (c *Conn) handlePing(appData string) error {
+	if err := c.extendReadDeadline(); err != nil {
+		return err
+	}
+	err := c.raw.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(c.opts.writeTimeout))
+	if err == websocket.ErrCloseSent {
+		return nil
+	} else if e, ok := err.(net.Error); ok && e.Temporary() {
+		return nil
+	}
+	return err
+}
+
+// startMessageSpan returns nil if the message is not sampled.
+func (c *Conn) startMessageSpan(operationName string, messageType int, size int) opentracing.Span {
+	if c.opts.messageSampling <= 0 || rand.Float64() >= c.opts.messageSampling {
+		return nil
+	}
+	return c.tracer.StartSpan(
+		operationName, opentracing.ChildOf(c.span.Context()), wsComponentTag,
+		opentracing.Tag{Key: "message.type", Value: messageType},
+		opentracing.Tag{Key: "message.size", Value: size},
+	)
+}
+
+// contextWithTraceId returns the context with trace id and a new logger, the logger should
+// be closed by caller. The trace id is inherited from context, eg: set by ginmiddle.Trace,
+// or uses the trace id of span, or generates a new one.
+func contextWithTraceId(ctx context.Context, span opentracing.Span, idGen *idgenerator.IDGenerator) (context.Context, string) {
+	nl := glog.FromContext(ctx).Clone()
+
+	tid := gtrace.IdFromContext(ctx)
+	if tid == "" {
+		if tid = gtrace.TraceIdFromSpan(span); tid == "" {
+			var err error
+			if tid, err = idGen.Take(); err != nil {
+				nl.Error().Error("generate new trace id error", err).Fire()
+			}
+		}
+		nl.WithFields().AddString(gtrace.IdKey, tid)
+		ctx = gtrace.ContextWithId(ctx, tid)
+	}
+	return glog.WithContext(ctx, nl), tid
+}
@@ -0,0 +1,345 @@
+package gws
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataWorkbench/glog"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/web/ginmiddle"
+)
+
+func newTestContext(t *testing.T) (context.Context, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer, closer, err := gtrace.New(&gtrace.Config{ServiceName: "test", Backend: gtrace.BackendOTLP},
+		gtrace.WithSpanExporter(exporter), gtrace.WithSyncer())
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = closer.Close() })
+
+	ctx := glog.WithContext(context.Background(), glog.NewDefault().WithLevel(glog.ErrorLevel))
+	return gtrace.ContextWithTracer(ctx, tracer), exporter
+}
+
+func Test_ConnTracePropagation(t *testing.T) {
+	ctx, exporter := newTestContext(t)
+
+	upgrader := NewUpgrader(ctx, WithMessageSampling(1))
+	closed := make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(ginmiddle.Trace(ctx))
+	engine.GET("/ws", func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(ginmiddle.GetStdContext(c), c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer close(closed)
+		defer func() { _ = conn.Close() }()
+		for {
+			messageType, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(messageType, p); err != nil {
+				return
+			}
+		}
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tracer := gtrace.TracerFromContext(ctx)
+	span := tracer.StartSpan("client")
+	traceId := gtrace.TraceIdFromSpan(span)
+
+	conn, resp, err := NewDialer(ctx, WithMessageSampling(1)).DialContext(opentracing.ContextWithSpan(ctx, span), url, nil)
+	require.Nil(t, err)
+	require.Equal(t, traceId, resp.Header.Get(gtrace.HeaderKey))
+	require.Contains(t, resp.Header.Get("traceparent"), traceId)
+	require.Equal(t, traceId, gtrace.IdFromContext(conn.Context()))
+	require.NotEmpty(t, conn.ID())
+
+	require.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, p, err := conn.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(p))
+	require.Nil(t, conn.Close())
+	require.Nil(t, conn.Close())
+	<-closed
+	span.Finish()
+
+	var names []string
+	for _, s := range exporter.GetSpans() {
+		require.Equal(t, traceId, s.SpanContext.TraceID().String())
+		names = append(names, s.Name)
+	}
+	for _, name := range []string{"client", "websocket dial", "websocket upgrade", "websocket write", "websocket read"} {
+		require.Contains(t, names, name)
+	}
+}
+
+func Test_UpgradeFailed(t *testing.T) {
+	ctx, _ := newTestContext(t)
+
+	upgrader := NewUpgrader(ctx)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = upgrader.Upgrade(ctx, w, r, nil)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.Nil(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	var body qerror.Response
+	require.Nil(t, json.Unmarshal(b, &body))
+	require.Equal(t, qerror.WebSocketHandshakeFailed.Code(), body.Code)
+	require.NotEmpty(t, body.RequestID)
+	require.Equal(t, body.RequestID, resp.Header.Get(gtrace.HeaderKey))
+}
+
+func Test_ConnMessagesAndClose(t *testing.T) {
+	ctx, _ := newTestContext(t)
+
+	upgrader := NewUpgrader(ctx, WithHeartbeat(time.Millisecond*20, time.Millisecond*200))
+	closeErr := make(chan error, 1)
+	serverConn := make(chan *Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(ctx, w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		serverConn <- conn
+
+		var name wrapperspb.StringValue
+		if err = conn.ReadProto(&name); err != nil {
+			closeErr <- err
+			return
+		}
+		var body map[string]string
+		if err = conn.ReadJSON(&body); err != nil {
+			closeErr <- err
+			return
+		}
+		body["name"] = name.Value
+		if err = conn.WriteJSON(body); err != nil {
+			closeErr <- err
+			return
+		}
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				closeErr <- err
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	clientSent := testutil.ToFloat64(messagesSentCounter.WithLabelValues(sideClient))
+
+	conn, _, err := NewDialer(ctx).DialContext(ctx, url, nil)
+	require.Nil(t, err)
+	require.Nil(t, conn.WriteProto(wrapperspb.String("notebook")))
+	require.Nil(t, conn.WriteJSON(map[string]string{"job": "log"}))
+	var body map[string]string
+	require.Nil(t, conn.ReadJSON(&body))
+	require.Equal(t, map[string]string{"job": "log", "name": "notebook"}, body)
+
+	// The server keeps the connection alive by ping while the client is reading.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond * 100)
+	stats := conn.Stats()
+	require.Equal(t, uint64(2), stats.MessagesSent)
+	require.Equal(t, uint64(1), stats.MessagesReceived)
+	require.Equal(t, uint64(len(`{"job":"log","name":"notebook"}`)), stats.BytesReceived)
+	require.True(t, (<-serverConn).Stats().PingRTT > 0)
+
+	// The metrics are the sum of connections, one series per side.
+	require.Equal(t, 2, testutil.CollectAndCount(connCollector, "websocket_connections"))
+	require.Equal(t, 2, testutil.CollectAndCount(pingRTTHistogram, "websocket_ping_rtt_seconds"))
+	require.Equal(t, 2.0, testutil.ToFloat64(messagesSentCounter.WithLabelValues(sideClient))-clientSent)
+
+	require.Nil(t, conn.CloseWithCode(websocket.CloseGoingAway, "bye"))
+	require.True(t, websocket.IsCloseError(<-closeErr, websocket.CloseGoingAway))
+	require.Equal(t, ErrConnClosed, conn.WriteMessage(websocket.TextMessage, []byte("late")))
+	<-conn.Done()
+}
+
+func Test_ConnHeartbeatTimeout(t *testing.T) {
+	ctx, _ := newTestContext(t)
+
+	upgrader := NewUpgrader(ctx, WithHeartbeat(time.Millisecond*20, time.Millisecond*50))
+	readErr := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(ctx, w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _, err = conn.ReadMessage()
+		readErr <- err
+	}))
+	defer server.Close()
+
+	// The raw client never reads, so the pings are not answered.
+	raw, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.Nil(t, err)
+	defer func() { _ = raw.Close() }()
+
+	select {
+	case err = <-readErr:
+		netErr, ok := err.(net.Error)
+		require.True(t, ok)
+		require.True(t, netErr.Timeout())
+	case <-time.After(time.Second * 5):
+		t.Fatal("heartbeat timeout not detected")
+	}
+}
+
+func Test_ConnSendQueueOverflow(t *testing.T) {
+	newQueue := func(policy OverflowPolicy) *Conn {
+		return &Conn{
+			side:    sideServer,
+			metrics: sideMetrics[sideServer],
+			opts:    applyOptions([]Option{WithSendQueue(1, policy)}),
+			send:    make(chan *message, 1),
+			closing: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+	}
+
+	c := newQueue(OverflowDropNewest)
+	require.Nil(t, c.WriteMessage(websocket.TextMessage, []byte("1")))
+	require.Equal(t, ErrSendQueueFull, c.WriteMessage(websocket.TextMessage, []byte("2")))
+	require.Equal(t, "1", string(c.pending().data))
+	require.Equal(t, uint64(1), c.messagesDropped)
+
+	c = newQueue(OverflowDropOldest)
+	require.Nil(t, c.WriteMessage(websocket.TextMessage, []byte("1")))
+	require.Nil(t, c.WriteMessage(websocket.TextMessage, []byte("2")))
+	require.Equal(t, "2", string(c.pending().data))
+	require.Equal(t, uint64(1), c.messagesDropped)
+
+	c = newQueue(OverflowBlock)
+	require.Nil(t, c.WriteMessage(websocket.TextMessage, []byte("1")))
+	go c.fail(nil)
+	require.Equal(t, ErrConnClosed, c.WriteMessage(websocket.TextMessage, []byte("2")))
+}
+
+func Test_ConnWriteMessageCopy(t *testing.T) {
+	c := &Conn{
+		side:    sideServer,
+		metrics: sideMetrics[sideServer],
+		opts:    applyOptions([]Option{WithSendQueue(2, OverflowBlock)}),
+		send:    make(chan *message, 2),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	// The caller reuses the buffer after write.
+	buf := []byte("1")
+	require.Nil(t, c.WriteMessage(websocket.TextMessage, buf))
+	buf[0] = '2'
+	require.Nil(t, c.WriteMessage(websocket.TextMessage, buf))
+	require.Equal(t, 2, c.Stats().SendQueueLength)
+	require.Equal(t, "1", string(c.pending().data))
+	require.Equal(t, "2", string(c.pending().data))
+}
+
+func Test_ConnWriteDuringClose(t *testing.T) {
+	ctx, exporter := newTestContext(t)
+
+	upgrader := NewUpgrader(ctx)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(ctx, w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := NewDialer(ctx, WithMessageSampling(1), WithSendQueue(4, OverflowDropOldest)).
+		DialContext(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.Nil(t, err)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var writes uint64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				atomic.AddUint64(&writes, 1)
+				if conn.WriteMessage(websocket.TextMessage, []byte("message")) == ErrConnClosed {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond * 20)
+	_ = conn.Close()
+	wg.Wait()
+
+	// The span of every message is finished, including the ones rejected or dropped by close.
+	var spans uint64
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "websocket write" {
+			spans++
+		}
+	}
+	require.Equal(t, writes, spans)
+	require.Equal(t, 0, len(conn.send))
+
+	// The writes are rejected once the queue drained by release, even if the done isn't closed.
+	c := &Conn{
+		opts:       applyOptions([]Option{WithSendQueue(1, OverflowBlock)}),
+		send:       make(chan *message, 1),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+		sendClosed: true,
+	}
+	require.Equal(t, ErrConnClosed, c.WriteMessage(websocket.TextMessage, []byte("late")))
+	require.Equal(t, 0, len(c.send))
+}
@@ -9,17 +9,22 @@ import (
 	"github.com/DataWorkbench/glog"
 	"github.com/gorilla/websocket"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
 
 	"github.com/DataWorkbench/common/gtrace"
+	"github.com/DataWorkbench/common/utils/idgenerator"
 )
 
 type Dialer struct {
 	raw    *websocket.Dialer
 	tracer opentracing.Tracer
+	idGen  *idgenerator.IDGenerator
+	opts   *options
 }
 
 // NewDialer return an instance of websocket.Dialer with default config.
-func NewDialer(ctx context.Context) *Dialer {
+func NewDialer(ctx context.Context, opts ...Option) *Dialer {
 	raw := &websocket.Dialer{
 		NetDial:           nil,
 		NetDialContext:    nil,
@@ -36,30 +41,48 @@ func NewDialer(ctx context.Context) *Dialer {
 	ws := &Dialer{
 		raw:    raw,
 		tracer: gtrace.TracerFromContext(ctx),
+		idGen:  idgenerator.New(""),
+		opts:   applyOptions(opts),
 	}
 	return ws
 }
 
-// Send is wrapper for websocket.Dialer.DialContext. To support opentracing span.
-func (ws *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (conn *websocket.Conn, resp *http.Response, err error) {
+// DialContext is wrapper for websocket.Dialer.DialContext. To support opentracing span.
+//
+// The span of connection is injected into request header, it finishes when the Conn closed.
+func (ws *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (conn *Conn, resp *http.Response, err error) {
+	if requestHeader == nil {
+		requestHeader = make(http.Header)
+	}
+
+	span, ctx := opentracing.StartSpanFromContextWithTracer(ctx, ws.tracer, "websocket dial", ext.SpanKindRPCClient, wsComponentTag)
+	ext.HTTPUrl.Set(span, urlStr)
+
+	ctx, tid := contextWithTraceId(ctx, span, ws.idGen)
 	lg := glog.FromContext(ctx)
 
-	if tid := gtrace.IdFromContext(ctx); tid != "" {
-		requestHeader.Set(gtrace.HeaderKey, tid)
-	}
-	if span := opentracing.SpanFromContext(ctx); span != nil {
-		err = ws.tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(requestHeader))
-		if err != nil {
-			lg.Error().Error("inject span to websocket request header error", err).Fire()
-		}
+	requestHeader.Set(gtrace.HeaderKey, tid)
+	err = ws.tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(requestHeader))
+	if err != nil {
+		lg.Error().Error("inject span to websocket request header error", err).Fire()
 	}
 
 	lg.Debug().String("sending request to url", urlStr).Fire()
-	conn, resp, err = ws.raw.DialContext(ctx, urlStr, requestHeader)
+	var raw *websocket.Conn
+	raw, resp, err = ws.raw.DialContext(ctx, urlStr, requestHeader)
+	if resp != nil {
+		ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	}
 	if err != nil {
 		lg.Error().Error("send request error", err).Fire()
+		ext.Error.Set(span, true)
+		span.LogFields(tracerLog.Error(err))
+		span.Finish()
+		_ = lg.Close()
 		return
 	}
 	lg.Debug().Int("successful request with status", resp.StatusCode).Fire()
+
+	conn = newConn(ctx, raw, ws.tracer, ws.idGen, sideClient, ws.opts)
 	return
 }
@@ -0,0 +1,128 @@
+package gws
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const subsystem = "websocket"
+
+var connCollector = &connStatsCollector{conns: make(map[*Conn]struct{})}
+
+var (
+	messagesSentCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "messages_sent_total",
+		Help:      "Number of messages sent to the peer of connections.",
+	}, []string{"side"})
+	messagesReceivedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "messages_received_total",
+		Help:      "Number of messages received from the peer of connections.",
+	}, []string{"side"})
+	messagesDroppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "messages_dropped_total",
+		Help:      "Number of messages dropped by the overflow policy or connection closed.",
+	}, []string{"side"})
+	bytesSentCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "sent_bytes_total",
+		Help:      "Size of messages sent to the peer of connections.",
+	}, []string{"side"})
+	bytesReceivedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "received_bytes_total",
+		Help:      "Size of messages received from the peer of connections.",
+	}, []string{"side"})
+	pingRTTHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: subsystem,
+		Name:      "ping_rtt_seconds",
+		Help:      "Round-trip time of the ping and pong of connections.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"side"})
+)
+
+func init() {
+	prometheus.MustRegister(connCollector, messagesSentCounter, messagesReceivedCounter, messagesDroppedCounter,
+		bytesSentCounter, bytesReceivedCounter, pingRTTHistogram)
+}
+
+// connMetrics is the metrics of one side, the connections add their statistics to it.
+// The per-connection values are got by Conn.Stats to keep the cardinality bounded.
+type connMetrics struct {
+	messagesSent     prometheus.Counter
+	messagesReceived prometheus.Counter
+	messagesDropped  prometheus.Counter
+	bytesSent        prometheus.Counter
+	bytesReceived    prometheus.Counter
+	pingRTT          prometheus.Observer
+}
+
+func newConnMetrics(side string) *connMetrics {
+	return &connMetrics{
+		messagesSent:     messagesSentCounter.WithLabelValues(side),
+		messagesReceived: messagesReceivedCounter.WithLabelValues(side),
+		messagesDropped:  messagesDroppedCounter.WithLabelValues(side),
+		bytesSent:        bytesSentCounter.WithLabelValues(side),
+		bytesReceived:    bytesReceivedCounter.WithLabelValues(side),
+		pingRTT:          pingRTTHistogram.WithLabelValues(side),
+	}
+}
+
+var sideMetrics = map[string]*connMetrics{
+	sideServer: newConnMetrics(sideServer),
+	sideClient: newConnMetrics(sideClient),
+}
+
+var (
+	connectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "connections"),
+		"Number of open websocket connections.",
+		[]string{"side"}, nil,
+	)
+	sendQueueLengthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("", subsystem, "send_queue_length"),
+		"Number of messages waiting in the send queues of open connections.",
+		[]string{"side"}, nil,
+	)
+)
+
+// connStatsCollector implements prometheus.Collector to export the gauges of open connections.
+type connStatsCollector struct {
+	mu    sync.Mutex
+	conns map[*Conn]struct{}
+}
+
+func (c *connStatsCollector) add(conn *Conn) {
+	c.mu.Lock()
+	c.conns[conn] = struct{}{}
+	c.mu.Unlock()
+}
+
+func (c *connStatsCollector) remove(conn *Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+}
+
+func (c *connStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionsDesc
+	ch <- sendQueueLengthDesc
+}
+
+func (c *connStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	connections := map[string]int{sideServer: 0, sideClient: 0}
+	queueLength := map[string]int{sideServer: 0, sideClient: 0}
+	for conn := range c.conns {
+		connections[conn.side]++
+		queueLength[conn.side] += len(conn.send)
+	}
+	for side, n := range connections {
+		ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(n), side)
+		ch <- prometheus.MustNewConstMetric(sendQueueLengthDesc, prometheus.GaugeValue, float64(queueLength[side]), side)
+	}
+}
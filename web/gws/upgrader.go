@@ -10,18 +10,23 @@ import (
 	"github.com/DataWorkbench/glog"
 	"github.com/gorilla/websocket"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	tracerLog "github.com/opentracing/opentracing-go/log"
 
 	"github.com/DataWorkbench/common/gtrace"
 	"github.com/DataWorkbench/common/qerror"
+	"github.com/DataWorkbench/common/utils/idgenerator"
 )
 
 type Upgrader struct {
 	raw    *websocket.Upgrader
 	tracer opentracing.Tracer
+	idGen  *idgenerator.IDGenerator
+	opts   *options
 }
 
 // NewUpgrader return an instance of websocket.Upgrader with default config.
-func NewUpgrader(ctx context.Context) *Upgrader {
+func NewUpgrader(ctx context.Context, opts ...Option) *Upgrader {
 	raw := &websocket.Upgrader{
 		HandshakeTimeout: time.Second * 5,
 		ReadBufferSize:   4096,
@@ -29,16 +34,7 @@ func NewUpgrader(ctx context.Context) *Upgrader {
 		WriteBufferPool:  &sync.Pool{},
 		Subprotocols:     nil,
 		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
-			//http.Error(w, reason.Error(), status)
-			resp := &qerror.Response{
-				Code:      "WebSocketError",
-				Status:    status,
-				RequestID: w.Header().Get(gtrace.HeaderKey),
-				Detail: qerror.Detail{
-					EnUs: reason.Error(),
-					ZhCn: reason.Error(),
-				},
-			}
+			resp := qerror.NewResponse(handshakeError(status, reason), w.Header().Get(gtrace.HeaderKey))
 			b, err := json.Marshal(resp)
 			if err != nil {
 				panic(err)
@@ -46,7 +42,7 @@ func NewUpgrader(ctx context.Context) *Upgrader {
 
 			w.Header().Set("Sec-Websocket-Version", "13")
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(status)
+			w.WriteHeader(resp.Status)
 			_, _ = w.Write(b)
 		},
 		CheckOrigin: func(r *http.Request) bool {
@@ -58,32 +54,79 @@ func NewUpgrader(ctx context.Context) *Upgrader {
 	ws := &Upgrader{
 		raw:    raw,
 		tracer: gtrace.TracerFromContext(ctx),
+		idGen:  idgenerator.New(""),
+		opts:   applyOptions(opts),
 	}
 	return ws
 }
 
-func (ws *Upgrader) Upgrade(ctx context.Context, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (conn *websocket.Conn, err error) {
+// Upgrade is wrapper for websocket.Upgrader.Upgrade. To support opentracing span.
+//
+// The span of connection is a child of the span in ctx, eg: created by ginmiddle.Trace, or the
+// span extracted from request header. It's injected into the response header with trace id, and
+// finishes when the Conn closed. The failures are responded as qerror.Response.
+func (ws *Upgrader) Upgrade(ctx context.Context, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (conn *Conn, err error) {
+	var parentSpan opentracing.SpanContext
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		parentSpan = span.Context()
+	} else {
+		parentSpan, err = ws.tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		if err != nil && err != opentracing.ErrSpanContextNotFound {
+			glog.FromContext(ctx).Error().Error("extract parent span from websocket request header error", err).Fire()
+		}
+	}
+	span := ws.tracer.StartSpan(
+		"websocket upgrade", opentracing.ChildOf(parentSpan),
+		ext.SpanKindRPCServer, wsComponentTag,
+	)
+	ext.HTTPMethod.Set(span, r.Method)
+	ext.HTTPUrl.Set(span, r.RequestURI)
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	ctx, tid := contextWithTraceId(ctx, span, ws.idGen)
 	lg := glog.FromContext(ctx)
 
-	//if responseHeader == nil {
-	//	responseHeader = make(http.Header, 2)
-	//	if tid := gtrace.IdFromContext(ctx); tid != "" {
-	//		responseHeader.Set(gtrace.HeaderKey, tid)
-	//	}
-	//	if span := opentracing.SpanFromContext(ctx); span != nil {
-	//		err = ws.tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(responseHeader))
-	//		if err != nil {
-	//			lg.Error().Error("inject span to websocket response header error", err).Fire()
-	//		}
-	//	}
-	//}
+	// The websocket.Upgrader only sends the responseHeader if upgrade succeed.
+	if responseHeader == nil {
+		responseHeader = make(http.Header, 4)
+	}
+	responseHeader.Set(gtrace.HeaderKey, tid)
+	err = ws.tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(responseHeader))
+	if err != nil {
+		lg.Error().Error("inject span to websocket response header error", err).Fire()
+	}
+	if w.Header().Get(gtrace.HeaderKey) == "" {
+		w.Header().Set(gtrace.HeaderKey, tid)
+	}
 
 	lg.Debug().Msg("upgrading request to websocket").Fire()
-	conn, err = ws.raw.Upgrade(w, r, responseHeader)
+	var raw *websocket.Conn
+	raw, err = ws.raw.Upgrade(w, r, responseHeader)
 	if err != nil {
 		lg.Error().Error("upgraded websocket error", err).Fire()
+		ext.Error.Set(span, true)
+		span.LogFields(tracerLog.Error(err))
+		span.Finish()
+		_ = lg.Close()
 		return
 	}
+	ext.HTTPStatusCode.Set(span, http.StatusSwitchingProtocols)
 	lg.Debug().Msg("successful upgraded to websocket").Fire()
+
+	conn = newConn(ctx, raw, ws.tracer, ws.idGen, sideServer, ws.opts)
 	return
 }
+
+// handshakeError converts the status and reason of websocket.Upgrader failures to qerror.
+func handshakeError(status int, reason error) *qerror.Error {
+	switch {
+	case status == http.StatusForbidden:
+		return qerror.PermissionDenied
+	case status == http.StatusMethodNotAllowed:
+		return qerror.MethodNotAllowed
+	case status >= http.StatusInternalServerError:
+		return qerror.Internal
+	default:
+		return qerror.WebSocketHandshakeFailed.Format(reason.Error())
+	}
+}